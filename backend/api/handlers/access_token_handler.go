@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAccessTokenNotAllowed = errors.New("access tokens cannot manage access tokens or calendar feeds")
+	ErrRestrictedToken       = errors.New("access token is restricted to specific projects")
+)
+
+// projectRoutePattern matches the routes of a specific project, which are guarded by ProjectAccessMiddleware
+var projectRoutePattern = regexp.MustCompile(`^/project/[0-9]+(/|$)`)
+
+// restrictedTokenRoutes are the routes outside of projects which only return data
+// of the projects a restricted access token can access
+var restrictedTokenRoutes = map[string]bool{
+	"/project":                   true,
+	"/user/me/upcoming-meetings": true,
+	"/user/me/search":            true,
+}
+
+type AccessTokenHandler struct {
+	srv       services.AccessTokenService
+	projSrv   services.ProjectService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewAccessTokenHandler(
+	srv services.AccessTokenService,
+	projSrv services.ProjectService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *AccessTokenHandler {
+	return &AccessTokenHandler{srv, projSrv, logger, validator}
+}
+
+type accessTokenDto struct {
+	Name       string `json:"name" validate:"required,min=1,max=64"`
+	ExpiresAt  string `json:"expires_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	ReadOnly   bool   `json:"read_only"`
+	ProjectIDs []uint `json:"project_ids"`
+}
+
+type createdAccessTokenResponse struct {
+	model.AccessToken
+	// Token is the plain access token. It is only returned once
+	Token string `json:"token"`
+}
+
+// AccessTokenMiddleware prevents personal access tokens from managing other access tokens and calendar feeds,
+// which would escape the restrictions of the token
+func (h *AccessTokenHandler) AccessTokenMiddleware(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	if u.IsAccessToken() {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrAccessTokenNotAllowed))
+	}
+	return ctx.Next()
+}
+
+// RestrictedTokenMiddleware only allows access tokens which are restricted to specific projects
+// to access the routes of these projects (and the read-only routes which filter by project),
+// since all other routes (e.g. creating projects or accepting invites) would escape the restriction
+func RestrictedTokenMiddleware(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	if u.ProjectIDs == nil {
+		return ctx.Next()
+	}
+	// routes are neither case-sensitive nor strict about trailing slashes
+	route := strings.TrimSuffix(strings.ToLower(ctx.Path()), "/")
+	if projectRoutePattern.MatchString(route) {
+		return ctx.Next()
+	}
+	if restrictedTokenRoutes[route] && ctx.Method() == fiber.MethodGet {
+		return ctx.Next()
+	}
+	return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrRestrictedToken))
+}
+
+// ListTokens returns all access tokens of the current user
+func (h *AccessTokenHandler) ListTokens(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	tokens, err := h.srv.FindTokensByUser(u.UserID)
+	return fiberResponse(ctx, "access tokens", tokens, err)
+}
+
+// CreateToken creates a new access token for the current user
+func (h *AccessTokenHandler) CreateToken(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	var payload accessTokenDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+
+	var expiresAt sql.NullTime
+	if payload.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, payload.ExpiresAt)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if t.Before(time.Now()) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrExpiresInPast))
+		}
+		expiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+
+	// tokens can only be restricted to projects the user has access to
+	for _, projectID := range payload.ProjectIDs {
+		project, err := h.projSrv.FindProject(projectID, "Members")
+		if err != nil || !util.Can(project, u.UserID, util.PermissionRead) {
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoAccess))
+		}
+	}
+
+	token, plain, err := h.srv.CreateToken(u.UserID, payload.Name, expiresAt, payload.ReadOnly, payload.ProjectIDs)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	h.logger.Infof("user %s created access token %d", u.UserID, token.ID)
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("access token created",
+		createdAccessTokenResponse{*token, plain}))
+}
+
+// RevokeToken deletes an access token of the current user
+func (h *AccessTokenHandler) RevokeToken(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	tokenID, err := ctx.ParamsInt("token_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err = h.srv.DeleteToken(u.UserID, uint(tokenID)); err != nil {
+		if errors.Is(err, services.ErrNotMatches) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("access token revoked", nil))
+}
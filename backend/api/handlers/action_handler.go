@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"time"
 )
@@ -80,7 +80,7 @@ func fiberResponse[T any](ctx *fiber.Ctx, message string, value T, err error) er
 
 func (a ActionHandler) CreateAction(ctx *fiber.Ctx) error {
 	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	var dto actionDto
 	if err := ctx.BodyParser(&dto); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
@@ -91,11 +91,14 @@ func (a ActionHandler) CreateAction(ctx *fiber.Ctx) error {
 	}
 	// create action
 	action, err := a.srv.CreateAction(dto.Title, dto.Description, dueDate, dto.PriorityID, p.ID, u.UserID)
-	return fiberResponse(ctx, "created action", action, err)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponse(ctx, "created action", action, nil)
 }
 
 func (a ActionHandler) ListActionsForProjectAndUser(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
 	openOnly := ctx.Query("open", "false") != "false"
 	a.logger.Infof("list actions for project %d and user %s (open only: %v q/%v)", p.ID, u.UserID, openOnly, ctx.Query("open", "false"))
@@ -128,6 +131,7 @@ func (a ActionHandler) FindAction(ctx *fiber.Ctx) error {
 }
 
 func (a ActionHandler) EditAction(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	var dto actionDto
 	if err := ctx.BodyParser(&dto); err != nil {
@@ -138,13 +142,19 @@ func (a ActionHandler) EditAction(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
 	// edit action
-	return fiberResponseNoVal(ctx, "created action",
-		a.srv.EditAction(action.ID, dto.Title, dto.Description, dueDate, dto.PriorityID))
+	if err = a.srv.EditAction(action.ID, dto.Title, dto.Description, dueDate, dto.PriorityID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "created action", nil)
 }
 
 func (a ActionHandler) DeleteAction(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
-	return fiberResponseNoVal(ctx, "deleted action", a.srv.DeleteAction(action.ID))
+	if err := a.srv.DeleteAction(action.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "deleted action", nil)
 }
 
 func (a ActionHandler) ListActionsForMeeting(ctx *fiber.Ctx) error {
@@ -206,15 +216,23 @@ func (a ActionHandler) ListActionsForTopic(ctx *fiber.Ctx) error {
 }
 
 func (a ActionHandler) LinkTopic(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	topic := ctx.Locals("topic").(model.Topic)
-	return fiberResponseNoVal(ctx, "linked topic", a.srv.LinkTopic(action.ID, topic.ID))
+	if err := a.srv.LinkTopic(action.ID, topic.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked topic", nil)
 }
 
 func (a ActionHandler) UnlinkTopic(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	topic := ctx.Locals("topic").(model.Topic)
-	return fiberResponseNoVal(ctx, "unlinked topic", a.srv.UnlinkTopic(action.ID, topic.ID))
+	if err := a.srv.UnlinkTopic(action.ID, topic.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked topic", nil)
 }
 
 // :action_id/user/:user_id
@@ -222,7 +240,7 @@ func (a ActionHandler) UnlinkTopic(ctx *fiber.Ctx) error {
 func (a ActionHandler) LinkUser(ctx *fiber.Ctx) error {
 	action := ctx.Locals("action").(model.Action)
 	projectUser := ctx.Locals("project_user").(model.User)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 
 	// create notification for linked user
 	if u.UserID != projectUser.ID {
@@ -237,37 +255,86 @@ func (a ActionHandler) LinkUser(ctx *fiber.Ctx) error {
 		}
 	}
 
-	return fiberResponseNoVal(ctx, "linked user", a.srv.LinkUser(action.ID, projectUser.ID))
+	if err := a.srv.LinkUser(action.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked user", nil)
 }
 
 func (a ActionHandler) UnlinkUser(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	projectUser := ctx.Locals("project_user").(model.User)
-	return fiberResponseNoVal(ctx, "unlinked user", a.srv.UnlinkUser(action.ID, projectUser.ID))
+	if err := a.srv.UnlinkUser(action.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked user", nil)
 }
 
 // :action_id/tag/:tag_id
 
 func (a ActionHandler) LinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "linked tag", a.srv.LinkTag(action.ID, tag.ID))
+	if err := a.srv.LinkTag(action.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked tag", nil)
 }
 
 func (a ActionHandler) UnlinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "unlinked tag", a.srv.UnlinkTag(action.ID, tag.ID))
+	if err := a.srv.UnlinkTag(action.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked tag", nil)
+}
+
+// ListFiles returns the files attached to the action
+func (a ActionHandler) ListFiles(ctx *fiber.Ctx) error {
+	action := ctx.Locals("action").(model.Action)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("files", action.Files))
+}
+
+func (a ActionHandler) LinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	action := ctx.Locals("action").(model.Action)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := a.srv.LinkFile(action.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "attached file", nil)
+}
+
+func (a ActionHandler) UnlinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	action := ctx.Locals("action").(model.Action)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := a.srv.UnlinkFile(action.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "detached file", nil)
 }
 
 // :action_id/close
 
 func (a ActionHandler) CloseAction(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
-	return fiberResponseNoVal(ctx, "closed action", a.srv.CloseAction(action.ID))
+	if err := a.srv.CloseAction(action.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "closed action", nil)
 }
 
 func (a ActionHandler) OpenAction(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	action := ctx.Locals("action").(model.Action)
-	return fiberResponseNoVal(ctx, "opened action", a.srv.OpenAction(action.ID))
+	if err := a.srv.OpenAction(action.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "opened action", nil)
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"time"
+)
+
+type AuditHandler struct {
+	srv    services.AuditService
+	logger *zap.SugaredLogger
+}
+
+func NewAuditHandler(srv services.AuditService, logger *zap.SugaredLogger) *AuditHandler {
+	return &AuditHandler{srv, logger}
+}
+
+type auditPageResponse struct {
+	Events   []model.AuditEvent `json:"events"`
+	Total    int64              `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+}
+
+// ListEvents returns the audit events of the current project (newest first).
+// The events can be filtered using the query parameters actor_id, user_id, entity_type, entity_id, action, since and until
+func (h *AuditHandler) ListEvents(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionViewAuditLog) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	filter := services.AuditFilter{
+		ActorID:    ctx.Query("actor_id"),
+		UserID:     ctx.Query("user_id"),
+		EntityType: model.AuditEntityType(ctx.Query("entity_type")),
+		EntityID:   uint(ctx.QueryInt("entity_id", 0)),
+		Action:     model.AuditAction(ctx.Query("action")),
+	}
+	var err error
+	if since := ctx.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+	}
+	if until := ctx.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+	}
+	events, total, err := h.srv.FindEvents(p.ID, filter, page)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("audit events", auditPageResponse{
+		Events:   events,
+		Total:    total,
+		Page:     page,
+		PageSize: h.srv.PageSize(),
+	}))
+}
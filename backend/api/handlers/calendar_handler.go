@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"strings"
+)
+
+type CalendarHandler struct {
+	srv       services.CalendarService
+	projSrv   services.ProjectService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewCalendarHandler(
+	srv services.CalendarService,
+	projSrv services.ProjectService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *CalendarHandler {
+	return &CalendarHandler{srv, projSrv, logger, validator}
+}
+
+type calendarFeedDto struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+	// ProjectID restricts the feed to a project (optional)
+	ProjectID      *uint `json:"project_id"`
+	IncludeActions bool  `json:"include_actions"`
+}
+
+type createdCalendarFeedResponse struct {
+	model.CalendarFeed
+	// Token is the plain feed token. It is only returned once
+	Token string `json:"token"`
+	// Path is the path of the feed URL relative to the api
+	Path string `json:"path"`
+}
+
+func feedResponse(feed *model.CalendarFeed, token string) createdCalendarFeedResponse {
+	return createdCalendarFeedResponse{*feed, token, "/calendar/" + token + ".ics"}
+}
+
+// Feed serves the iCalendar feed of the token. The route is not authenticated, the token is the secret
+func (h *CalendarHandler) Feed(ctx *fiber.Ctx) error {
+	token := strings.TrimSuffix(ctx.Params("token"), ".ics")
+	cal, err := h.srv.Calendar(token)
+	if err != nil {
+		if errors.Is(err, services.ErrCalendarFeedNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	var buf bytes.Buffer
+	if err = cal.Encode(&buf); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	ctx.Set(fiber.HeaderContentDisposition, `inline; filename="perplex.ics"`)
+	ctx.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return ctx.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// ListFeeds returns all calendar feeds of the current user
+func (h *CalendarHandler) ListFeeds(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	feeds, err := h.srv.FindFeedsByUser(u.UserID)
+	return fiberResponse(ctx, "calendar feeds", feeds, err)
+}
+
+// CreateFeed creates a new calendar feed for the current user
+func (h *CalendarHandler) CreateFeed(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	var payload calendarFeedDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	// feeds can only be restricted to projects the user has access to
+	if payload.ProjectID != nil {
+		project, err := h.projSrv.FindProject(*payload.ProjectID, "Members")
+		if err != nil || !util.Can(project, u.UserID, util.PermissionRead) {
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoAccess))
+		}
+	}
+	feed, token, err := h.srv.CreateFeed(u.UserID, payload.Name, payload.ProjectID, payload.IncludeActions)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	h.logger.Infof("user %s created calendar feed %d", u.UserID, feed.ID)
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("calendar feed created",
+		feedResponse(feed, token)))
+}
+
+// RotateFeed replaces the token of a calendar feed of the current user
+func (h *CalendarHandler) RotateFeed(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	feedID, err := ctx.ParamsInt("feed_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	feed, token, err := h.srv.RotateFeed(u.UserID, uint(feedID))
+	if err != nil {
+		if errors.Is(err, services.ErrCalendarFeedNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	h.logger.Infof("user %s rotated calendar feed %d", u.UserID, feed.ID)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("calendar feed rotated",
+		feedResponse(feed, token)))
+}
+
+// DeleteFeed deletes a calendar feed of the current user
+func (h *CalendarHandler) DeleteFeed(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	feedID, err := ctx.ParamsInt("feed_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err = h.srv.DeleteFeed(u.UserID, uint(feedID)); err != nil {
+		if errors.Is(err, services.ErrCalendarFeedNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("calendar feed deleted", nil))
+}
@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/utils"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"strconv"
 )
@@ -177,7 +177,7 @@ func (h *CommentHandler) CommentLocalsMiddleware(ctx *fiber.Ctx) error {
 // the authenticated user is the author of this comment. If it's not the same, it returns a status of unauthorized.
 // If the comment is found and the user is the author, it stores the comment in local context and continues to the next handler.
 func (h *CommentHandler) CommentOwnershipMiddleware(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	c := ctx.Locals("comment").(model.Comment)
 	if u.UserID != c.AuthorID {
 		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrNoAccess))
@@ -208,7 +208,7 @@ func addEntityComment[T model.Ownership](
 		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrNoAccess))
 	}
 	// create comment
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	comment, err := srv.AddComment(u.UserID, content, func(comment *model.Comment) {
 		populateComment(comment, entity)
 	})
@@ -309,10 +309,11 @@ func (h *CommentHandler) ListGenericComment(ctx *fiber.Ctx) error {
 
 // EditComment is an endpoint function to modify the content of an existing comment.
 func (h *CommentHandler) EditComment(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	c := ctx.Locals("comment").(model.Comment)
 
 	content := utils.CopyString(string(ctx.Body()))
-	if err := h.srv.EditComment(c.ID, content); err != nil {
+	if err := h.srv.EditComment(c.ID, content, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("comment updated", nil))
@@ -320,24 +321,66 @@ func (h *CommentHandler) EditComment(ctx *fiber.Ctx) error {
 
 // DeleteComment is an endpoint function to delete an existing comment.
 func (h *CommentHandler) DeleteComment(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	c := ctx.Locals("comment").(model.Comment)
-	if err := h.srv.DeleteComment(c.ID); err != nil {
+	if err := h.srv.DeleteComment(c.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("comment deleted", nil))
 }
 
+// ListFiles returns the files attached to the comment
+func (h *CommentHandler) ListFiles(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	c := ctx.Locals("comment").(model.Comment)
+	if !c.CheckProjectOwnership(p.ID) {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("files", c.Files))
+}
+
+// LinkFile attaches a file of the project to the comment
+func (h *CommentHandler) LinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	c := ctx.Locals("comment").(model.Comment)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if !c.CheckProjectOwnership(p.ID) {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	if err := h.srv.LinkFile(c.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "attached file", nil)
+}
+
+// UnlinkFile detaches a file from the comment
+func (h *CommentHandler) UnlinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	c := ctx.Locals("comment").(model.Comment)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if !c.CheckProjectOwnership(p.ID) {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	if err := h.srv.UnlinkFile(c.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "detached file", nil)
+}
+
 // MarkSolutionComment creates a handler function that marks or unmarks a comment as the solution for a topic.
 func (h *CommentHandler) MarkSolutionComment(mark bool) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
+		u := ctx.Locals("user").(auth.Principal)
 		c := ctx.Locals("comment").(model.Comment)
-		var action func(uint) error
+		var action func(uint, string) error
 		if mark {
 			action = h.srv.MarkCommentSolution
 		} else {
 			action = h.srv.UnmarkCommentSolution
 		}
-		if err := action(c.ID); err != nil {
+		if err := action(c.ID, u.UserID); err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 		}
 		return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("solution updated", nil))
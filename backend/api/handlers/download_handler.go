@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type DownloadHandler struct {
+	storage services.StorageService
+	signer  services.DownloadSigner
+}
+
+func NewDownloadHandler(storage services.StorageService, signer services.DownloadSigner) *DownloadHandler {
+	return &DownloadHandler{storage, signer}
+}
+
+// ServeSignedDownload streams an object through the API if the signature of the URL is valid.
+// This route does not require authentication since the signature is only issued to authorized users.
+// The object is always sent as attachment since the inline parameter is not part of the signature
+func (h *DownloadHandler) ServeSignedDownload(ctx *fiber.Ctx) error {
+	key := ctx.Params("*")
+	name, contentType := ctx.Query("name"), ctx.Query("type")
+	if err := h.signer.Verify(key, name, contentType, ctx.Query("expires"), ctx.Query("signature")); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	return sendObject(ctx, h.storage, key, name, contentType, false, nil)
+}
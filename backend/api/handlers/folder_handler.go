@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type FolderHandler struct {
+	srv       services.FolderService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewFolderHandler(
+	srv services.FolderService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *FolderHandler {
+	return &FolderHandler{srv, logger, validator}
+}
+
+// folderErrorStatus returns the status code for errors of the folder service
+func folderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrFolderNotEmpty), errors.Is(err, services.ErrFolderNameTaken):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrFolderCycle):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+func (h *FolderHandler) FolderLocalsMiddleware(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	folderID, err := ctx.ParamsInt("folder_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	folder, err := h.srv.FindFolder(p.ID, uint(folderID))
+	if err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Locals("folder", *folder)
+	return ctx.Next()
+}
+
+func (h *FolderHandler) ListRoot(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	contents, err := h.srv.ListContents(p.ID, nil)
+	if err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("folder contents", contents))
+}
+
+type createFolderDto struct {
+	Name     string `json:"name" validate:"required,min=1,max=128,excludesall=/\\"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+func (h *FolderHandler) CreateFolder(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var dto createFolderDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	folder, err := h.srv.CreateFolder(p.ID, dto.ParentID, dto.Name, u.UserID)
+	if err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("created folder", folder))
+}
+
+type moveFileDto struct {
+	FolderID *uint `json:"folder_id"`
+}
+
+// MoveFile moves a file to another folder. A null folder_id moves the file to the root folder
+func (h *FolderHandler) MoveFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var dto moveFileDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.MoveFile(&f, dto.FolderID, u.UserID); err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("moved file", f))
+}
+
+// :folder_id
+
+func (h *FolderHandler) ListContents(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	folder := ctx.Locals("folder").(model.ProjectFolder)
+	contents, err := h.srv.ListContents(p.ID, &folder.ID)
+	if err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("folder contents", contents))
+}
+
+type renameFolderDto struct {
+	Name string `json:"name" validate:"required,min=1,max=128,excludesall=/\\"`
+}
+
+func (h *FolderHandler) RenameFolder(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	folder := ctx.Locals("folder").(model.ProjectFolder)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var dto renameFolderDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.RenameFolder(&folder, dto.Name, u.UserID); err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("renamed folder", folder))
+}
+
+type moveFolderDto struct {
+	ParentID *uint `json:"parent_id"`
+}
+
+// MoveFolder moves a folder to another parent folder. A null parent_id moves the folder to the root folder
+func (h *FolderHandler) MoveFolder(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	folder := ctx.Locals("folder").(model.ProjectFolder)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var dto moveFolderDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.MoveFolder(&folder, dto.ParentID, u.UserID); err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("moved folder", folder))
+}
+
+func (h *FolderHandler) DeleteFolder(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	folder := ctx.Locals("folder").(model.ProjectFolder)
+	// users can always delete their own folders
+	if folder.CreatorID != u.UserID && !hasPermission(ctx, util.PermissionDeleteFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.DeleteFolder(&folder, u.UserID); err != nil {
+		return ctx.Status(folderErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("deleted folder", nil))
+}
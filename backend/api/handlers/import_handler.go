@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/ical"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"io"
+)
+
+// MaxCalendarSize is the maximum size of imported iCalendar files
+const MaxCalendarSize = 1024 * 1024 // 1 MiB
+
+var ErrCalendarTooLarge = errors.New("calendar file too large")
+
+type ImportHandler struct {
+	srv    services.ImportService
+	logger *zap.SugaredLogger
+}
+
+func NewImportHandler(srv services.ImportService, logger *zap.SugaredLogger) *ImportHandler {
+	return &ImportHandler{srv, logger}
+}
+
+// readCalendar reads the calendar from the "file" field of a multipart form or from the request body
+func readCalendar(ctx *fiber.Ctx) (*ical.Calendar, error) {
+	var data []byte
+	if header, err := ctx.FormFile("file"); err == nil {
+		if header.Size > MaxCalendarSize {
+			return nil, ErrCalendarTooLarge
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return nil, err
+		}
+	} else {
+		data = ctx.Body()
+	}
+	if len(data) > MaxCalendarSize {
+		return nil, ErrCalendarTooLarge
+	}
+	return ical.Decode(bytes.NewReader(data))
+}
+
+// ImportICS imports the meetings of an iCalendar file. With "?preview=true",
+// the planned changes are returned without creating or updating meetings
+func (h *ImportHandler) ImportICS(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	cal, err := readCalendar(ctx)
+	if err != nil {
+		if errors.Is(err, ErrCalendarTooLarge) {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if ctx.QueryBool("preview") {
+		items, err := h.srv.Preview(&p, cal)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("import preview", items))
+	}
+	items, err := h.srv.Import(&p, u.UserID, cal)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	h.logger.Infof("user %s imported %d events into project %d", u.UserID, len(items), p.ID)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("imported calendar", items))
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+var ErrExpiresInPast = errors.New("expiry date in the past")
+
+type InviteHandler struct {
+	srv       services.InviteService
+	userSrv   services.UserService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewInviteHandler(
+	srv services.InviteService,
+	userSrv services.UserService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *InviteHandler {
+	return &InviteHandler{srv, userSrv, logger, validator}
+}
+
+type inviteDto struct {
+	Role      model.ProjectRole `json:"role"`
+	Email     string            `json:"email" validate:"omitempty,email,max=256"`
+	MaxUses   int               `json:"max_uses" validate:"min=0"`
+	ExpiresAt string            `json:"expires_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+// InviteLocalsMiddleware checks if the invite exists and belongs to the project.
+// It also adds the invite to the context.
+func (h *InviteHandler) InviteLocalsMiddleware(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	inviteID, err := ctx.ParamsInt("invite_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	invite, err := h.srv.FindInvite(uint(inviteID))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(err))
+	}
+	if invite.ProjectID != p.ID {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	ctx.Locals("invite", *invite)
+	return ctx.Next()
+}
+
+// CreateInvite creates a new invitation link for the current project
+func (h *InviteHandler) CreateInvite(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+
+	payload := inviteDto{Role: model.RoleMember}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if !payload.Role.IsValid() {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrInvalidRole))
+	}
+	// users can only invite others with roles they are allowed to manage
+	actorRole, _ := util.RoleOf(&p, u.UserID)
+	if !util.CanManageRole(actorRole, payload.Role) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+
+	var expiresAt sql.NullTime
+	if payload.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, payload.ExpiresAt)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if t.Before(time.Now()) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrExpiresInPast))
+		}
+		expiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+
+	invite, err := h.srv.CreateInvite(p.ID, u.UserID, payload.Role, payload.Email, payload.MaxUses, expiresAt)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("invite created", invite))
+}
+
+// ListInvites returns all invites of the current project
+func (h *InviteHandler) ListInvites(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionManageUsers) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	invites, err := h.srv.FindInvitesByProject(p.ID)
+	return fiberResponse(ctx, "project invites", invites, err)
+}
+
+// RevokeInvite deletes an invite so it cannot be accepted anymore
+func (h *InviteHandler) RevokeInvite(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	invite := ctx.Locals("invite").(model.ProjectInvite)
+	actorRole, _ := util.RoleOf(&p, u.UserID)
+	if !util.CanManageRole(actorRole, invite.Role) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.DeleteInvite(invite.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "invite revoked", nil)
+}
+
+type invitePreviewResponse struct {
+	ProjectID   uint              `json:"project_id"`
+	ProjectName string            `json:"project_name"`
+	Role        model.ProjectRole `json:"role"`
+	Usable      bool              `json:"usable"`
+}
+
+// GetInvite returns the project and role of an invitation link without accepting it
+func (h *InviteHandler) GetInvite(ctx *fiber.Ctx) error {
+	invite, err := h.srv.FindInviteByToken(ctx.Params("token"))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("invite", invitePreviewResponse{
+		ProjectID:   invite.ProjectID,
+		ProjectName: invite.Project.Name,
+		Role:        invite.Role,
+		Usable:      invite.IsUsable(),
+	}))
+}
+
+// AcceptInvite adds the requesting user to the project of the invitation link
+func (h *InviteHandler) AcceptInvite(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	// invites issued for an email address can only be claimed with a verified email address
+	invite, err := h.srv.AcceptInvite(ctx.Params("token"), u.UserID, u.VerifiedEmail())
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		case errors.Is(err, services.ErrInviteNotUsable),
+			errors.Is(err, services.ErrInviteWrongEmail),
+			errors.Is(err, services.ErrAlreadyProjectUser):
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	h.notifyOwner(util.GetFriendlyName(ctx), invite)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("invite accepted", invite.Project))
+}
+
+// AcceptPendingInvites accepts all invites issued for the email address of a newly registered user
+func (h *InviteHandler) AcceptPendingInvites(u auth.Principal, userName string) {
+	// only verified email addresses can be used to claim invites
+	invites, err := h.srv.AcceptPendingEmailInvites(u.UserID, u.VerifiedEmail())
+	if err != nil {
+		h.logger.Warnf("cannot accept pending invites for user %s: %v", u.UserID, err)
+		return
+	}
+	for _, invite := range invites {
+		h.logger.Infof("user %s joined project %d by email invite %d", u.UserID, invite.ProjectID, invite.ID)
+		h.notifyOwner(userName, &invite)
+	}
+}
+
+func (h *InviteHandler) notifyOwner(userName string, invite *model.ProjectInvite) {
+	if err := h.userSrv.CreateNotification(
+		invite.Project.OwnerID,
+		invite.Project.Name,
+		"project",
+		fmt.Sprintf("%s joined the project as %s", userName, invite.Role),
+		fmt.Sprintf("/project/%d", invite.ProjectID),
+		"Go to Project"); err != nil {
+		h.logger.Warnf("cannot create notification for user %s: %v", invite.Project.OwnerID, err)
+	}
+}
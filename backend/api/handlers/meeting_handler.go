@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"sort"
 	"time"
@@ -18,11 +18,21 @@ import (
 const MaxDescriptionLength = 1024 * 1024 // 1 MiB
 var ErrDescriptionTooLong = errors.New("description too long")
 var ErrEndBeforeStart = errors.New("end date before start date")
+var ErrInvalidScope = errors.New("scope must be 'this' or 'following'")
+var ErrFollowUpRequired = errors.New("either follow_up_id or follow_up is required")
+
+const (
+	// ScopeThis applies a change only to the occurrence of a meeting series
+	ScopeThis = "this"
+	// ScopeFollowing applies a change to the occurrence and all following occurrences of a meeting series
+	ScopeFollowing = "following"
+)
 
 type MeetingHandler struct {
 	srv       services.MeetingService
 	projSrv   services.ProjectService
 	userSrv   services.UserService
+	seriesSrv services.SeriesService
 	logger    *zap.SugaredLogger
 	validator *validator.Validate
 }
@@ -31,10 +41,24 @@ func NewMeetingHandler(
 	srv services.MeetingService,
 	projSrv services.ProjectService,
 	userSrv services.UserService,
+	seriesSrv services.SeriesService,
 	logger *zap.SugaredLogger,
 	validator *validator.Validate,
 ) *MeetingHandler {
-	return &MeetingHandler{srv, projSrv, userSrv, logger, validator}
+	return &MeetingHandler{srv, projSrv, userSrv, seriesSrv, logger, validator}
+}
+
+// seriesScope returns the scope of a change of a meeting. Meetings which are not part of a series
+// always use ScopeThis
+func seriesScope(ctx *fiber.Ctx, m model.Meeting) (string, error) {
+	scope := ctx.Query("scope", ScopeThis)
+	if scope != ScopeThis && scope != ScopeFollowing {
+		return "", ErrInvalidScope
+	}
+	if m.SeriesID == nil {
+		return ScopeThis, nil
+	}
+	return scope, nil
 }
 
 type meetingDto struct {
@@ -68,6 +92,10 @@ func (h *MeetingHandler) ValidateMeetingDto(dto *meetingDto) (*time.Time, *time.
 // PreloadMeetingsMiddleware preloads meetings for the current project and updates the project in the locals
 func (h *MeetingHandler) PreloadMeetingsMiddleware(ctx *fiber.Ctx) error {
 	p := ctx.Locals("project").(model.Project)
+	// create the upcoming occurrences of recurring meetings
+	if err := h.seriesSrv.Materialize(p.ID, time.Now().Add(services.SeriesHorizon)); err != nil {
+		h.logger.Warnf("cannot create occurrences of meeting series in project %d: %v", p.ID, err)
+	}
 	if err := h.projSrv.Extend(&p, "Meetings", "Meetings.Tags", "Meetings.AssignedUsers"); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
@@ -93,7 +121,7 @@ func (h *MeetingHandler) MeetingAccessMiddleware(ctx *fiber.Ctx) error {
 	}
 
 	// append assigned users
-	if err = h.srv.Extend(&m, "AssignedUsers", "Tags"); err != nil {
+	if err = h.srv.Extend(&m, "AssignedUsers", "Tags", "Files"); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	ctx.Locals("meeting", m)
@@ -102,7 +130,7 @@ func (h *MeetingHandler) MeetingAccessMiddleware(ctx *fiber.Ctx) error {
 
 // AddMeeting creates a new meeting for the current project
 func (h *MeetingHandler) AddMeeting(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
 	// parse payload and time
 	var payload meetingDto
@@ -139,17 +167,39 @@ func (h *MeetingHandler) GetMeeting(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting found", m))
 }
 
-// DeleteMeeting deletes a meeting
+// DeleteMeeting deletes a meeting. For occurrences of a series, "?scope=following" also
+// removes all following occurrences from the series
 func (h *MeetingHandler) DeleteMeeting(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	m := ctx.Locals("meeting").(model.Meeting)
-	if err := h.srv.DeleteMeeting(m.ID); err != nil {
+	if !hasPermission(ctx, util.PermissionDeleteMeetings) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	scope, err := seriesScope(ctx, m)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if scope == ScopeFollowing {
+		if err = h.seriesSrv.EndSeries(&m, u.UserID); err != nil {
+			return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+		}
+	}
+	if err = h.srv.DeleteMeeting(m.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
+	// the deleted occurrence must not be created again
+	if scope == ScopeThis && m.SeriesID != nil {
+		if err = h.seriesSrv.ExcludeOccurrence(&m); err != nil {
+			h.logger.Warnf("cannot exclude occurrence of meeting %d from series: %v", m.ID, err)
+		}
+	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting deleted", nil))
 }
 
-// EditMeeting edits the name and start date of a meeting
+// EditMeeting edits the name and start date of a meeting. For occurrences of a series, "?scope=following"
+// applies the changes to the occurrence and all following occurrences
 func (h *MeetingHandler) EditMeeting(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	m := ctx.Locals("meeting").(model.Meeting)
 	var payload meetingDto
 	if err := ctx.BodyParser(&payload); err != nil {
@@ -159,9 +209,26 @@ func (h *MeetingHandler) EditMeeting(ctx *fiber.Ctx) error {
 	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	if err = h.srv.EditMeeting(m.ID, payload.Name, payload.Description, *startTime, *endTime); err != nil {
+	scope, err := seriesScope(ctx, m)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if scope == ScopeFollowing {
+		series, err := h.seriesSrv.SplitSeries(&m, payload.Name, payload.Description, *startTime, *endTime, u.UserID)
+		if err != nil {
+			return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting series edited", series))
+	}
+	if err = h.srv.EditMeeting(m.ID, payload.Name, payload.Description, *startTime, *endTime, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
+	// edited occurrences are no longer updated with the series
+	if m.SeriesID != nil {
+		if err = h.seriesSrv.MarkException(m.ID); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+		}
+	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting edited", nil))
 }
 
@@ -170,7 +237,7 @@ func (h *MeetingHandler) LinkUser(ctx *fiber.Ctx) error {
 	projectUser := ctx.Locals("project_user").(model.User)
 
 	// create notification for linked user if not self link
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	if u.UserID != projectUser.ID {
 		if err := h.userSrv.CreateNotification(
 			projectUser.ID,
@@ -183,27 +250,68 @@ func (h *MeetingHandler) LinkUser(ctx *fiber.Ctx) error {
 		}
 	}
 
-	return fiberResponseNoVal(ctx, "linked user", h.srv.LinkUser(meeting.ID, projectUser.ID))
+	if err := h.srv.LinkUser(meeting.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked user", nil)
 }
 
 func (h *MeetingHandler) UnlinkUser(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	meeting := ctx.Locals("meeting").(model.Meeting)
 	projectUser := ctx.Locals("project_user").(model.User)
-	return fiberResponseNoVal(ctx, "unlinked user", h.srv.UnlinkUser(meeting.ID, projectUser.ID))
+	if err := h.srv.UnlinkUser(meeting.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked user", nil)
 }
 
 // LinkTag and UnlinkTag
 
 func (h *MeetingHandler) LinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	meeting := ctx.Locals("meeting").(model.Meeting)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "linked tag", h.srv.LinkTag(meeting.ID, tag.ID))
+	if err := h.srv.LinkTag(meeting.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked tag", nil)
 }
 
 func (h *MeetingHandler) UnlinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	meeting := ctx.Locals("meeting").(model.Meeting)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "unlinked tag", h.srv.UnlinkTag(meeting.ID, tag.ID))
+	if err := h.srv.UnlinkTag(meeting.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked tag", nil)
+}
+
+// ListFiles returns the files attached to the meeting
+func (h *MeetingHandler) ListFiles(ctx *fiber.Ctx) error {
+	meeting := ctx.Locals("meeting").(model.Meeting)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("files", meeting.Files))
+}
+
+func (h *MeetingHandler) LinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	meeting := ctx.Locals("meeting").(model.Meeting)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := h.srv.LinkFile(meeting.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "attached file", nil)
+}
+
+func (h *MeetingHandler) UnlinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	meeting := ctx.Locals("meeting").(model.Meeting)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := h.srv.UnlinkFile(meeting.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "detached file", nil)
 }
 
 type editReadyPayload struct {
@@ -211,13 +319,82 @@ type editReadyPayload struct {
 }
 
 func (h *MeetingHandler) EditReady(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	m := ctx.Locals("meeting").(model.Meeting)
 	var payload editReadyPayload
 	if err := ctx.BodyParser(&payload); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	if err := h.srv.SetReady(m.ID, payload.Ready); err != nil {
+	if err := h.srv.SetReady(m.ID, payload.Ready, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting edited", nil))
 }
+
+type concludeDto struct {
+	Mode string `validate:"required,oneof=move copy" json:"mode"`
+	// FollowUpID is the ID of an existing meeting the open topics are carried over to
+	FollowUpID uint `json:"follow_up_id"`
+	// FollowUp is created as the follow-up meeting if no FollowUpID is given
+	FollowUp *meetingDto `validate:"omitempty" json:"follow_up"`
+}
+
+type concludeResponse struct {
+	// FollowUp is the meeting the open topics were carried over to
+	FollowUp model.Meeting `json:"follow_up"`
+	// Topics are the carried over topics
+	Topics []services.CarriedTopic `json:"topics"`
+}
+
+// ConcludeMeeting concludes the meeting and moves or copies all open topics to an existing
+// or a newly created follow-up meeting
+func (h *MeetingHandler) ConcludeMeeting(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	m := ctx.Locals("meeting").(model.Meeting)
+	var payload concludeDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	var followUp model.Meeting
+	switch {
+	case payload.FollowUpID != 0:
+		existing, ok := util.Any(p.Meetings, func(t model.Meeting) bool {
+			return t.ID == payload.FollowUpID
+		})
+		if !ok {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		followUp = existing
+	case payload.FollowUp != nil:
+		startTime, endTime, err := h.ValidateMeetingDto(payload.FollowUp)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		followUp = model.Meeting{
+			Name:        payload.FollowUp.Name,
+			Description: payload.FollowUp.Description,
+			StartDate:   *startTime,
+			EndDate:     *endTime,
+			ProjectID:   p.ID,
+			CreatorID:   u.UserID,
+		}
+	default:
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrFollowUpRequired))
+	}
+	concluded := m
+	carried, err := h.srv.ConcludeMeeting(&concluded, &followUp, services.CarryOverMode(payload.Mode), u.UserID)
+	if err != nil {
+		if errors.Is(err, services.ErrFollowUpIsMeeting) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting concluded", concludeResponse{
+		FollowUp: followUp,
+		Topics:   carried,
+	}))
+}
@@ -3,10 +3,11 @@ package handlers
 import (
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"sort"
 )
@@ -59,10 +60,10 @@ func (a PriorityHandler) ListPrioritiesForProject(ctx *fiber.Ctx) error {
 }
 
 func (a PriorityHandler) CreatePriority(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManagePriorities) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	var dto priorityDto
 	if err := ctx.BodyParser(&dto); err != nil {
@@ -71,7 +72,7 @@ func (a PriorityHandler) CreatePriority(ctx *fiber.Ctx) error {
 	if err := a.validator.Struct(dto); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	priority, err := a.srv.CreatePriority(dto.Title, dto.Color, dto.Weight, p.ID)
+	priority, err := a.srv.CreatePriority(dto.Title, dto.Color, dto.Weight, p.ID, u.UserID)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
@@ -84,11 +85,10 @@ func (a PriorityHandler) FindPriority(ctx *fiber.Ctx) error {
 }
 
 func (a PriorityHandler) EditPriority(ctx *fiber.Ctx) error {
-	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	py := ctx.Locals("priority").(model.Priority)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManagePriorities) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	var dto priorityDto
 	if err := ctx.BodyParser(&dto); err != nil {
@@ -97,20 +97,19 @@ func (a PriorityHandler) EditPriority(ctx *fiber.Ctx) error {
 	if err := a.validator.Struct(dto); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	if err := a.srv.EditPriority(py.ID, dto.Title, dto.Color, dto.Weight); err != nil {
+	if err := a.srv.EditPriority(py.ID, dto.Title, dto.Color, dto.Weight, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("updated priority", nil))
 }
 
 func (a PriorityHandler) DeletePriority(ctx *fiber.Ctx) error {
-	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	py := ctx.Locals("priority").(model.Priority)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManagePriorities) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
-	if err := a.srv.DeletePriority(py.ID); err != nil {
+	if err := a.srv.DeletePriority(py.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("deleted priority", nil))
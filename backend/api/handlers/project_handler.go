@@ -1,17 +1,26 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bufio"
 	"errors"
 	"fmt"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,23 +30,55 @@ var ErrAlreadyInProject = errors.New("user already in project")
 var ErrNotInProject = errors.New("user not in project")
 var ErrOnlyOwner = errors.New("only owners can perform this action")
 var ErrOnlyUser = errors.New("only users can perform this action")
+var ErrForbidden = errors.New("insufficient permissions")
+var ErrInvalidRole = errors.New("invalid role")
+var ErrNoTransfer = errors.New("no pending ownership transfer")
 
 type ProjectHandler struct {
-	srv       services.ProjectService
-	userSrv   services.UserService
-	s3Srv     services.S3Service
-	logger    *zap.SugaredLogger
-	validator *validator.Validate
+	srv        services.ProjectService
+	userSrv    services.UserService
+	storage    services.StorageService
+	objectSrv  services.ObjectService
+	uploadSrv  services.UploadService
+	processSrv services.FileProcessingService
+	versionSrv services.FileVersionService
+	scanSrv    services.ScanService
+	quotaSrv   services.QuotaService
+	// downloadSigner signs URLs which stream downloads through the API instead of returning
+	// presigned URLs of the storage (nil if downloads are not proxied)
+	downloadSigner services.DownloadSigner
+	logger         *zap.SugaredLogger
+	validator      *validator.Validate
 }
 
 func NewProjectHandler(
 	srv services.ProjectService,
 	userSrv services.UserService,
-	s3Srv services.S3Service,
+	storage services.StorageService,
+	objectSrv services.ObjectService,
+	uploadSrv services.UploadService,
+	processSrv services.FileProcessingService,
+	versionSrv services.FileVersionService,
+	scanSrv services.ScanService,
+	quotaSrv services.QuotaService,
+	downloadSigner services.DownloadSigner,
 	logger *zap.SugaredLogger,
 	validator *validator.Validate,
 ) *ProjectHandler {
-	return &ProjectHandler{srv, userSrv, s3Srv, logger, validator}
+	return &ProjectHandler{
+		srv,
+		userSrv,
+		storage,
+		objectSrv,
+		uploadSrv,
+		processSrv,
+		versionSrv,
+		scanSrv,
+		quotaSrv,
+		downloadSigner,
+		logger,
+		validator,
+	}
 }
 
 type projectDto struct {
@@ -58,20 +99,25 @@ func (h *ProjectHandler) ValidateProjectDto(dto *projectDto) error {
 // ProjectAccessMiddleware checks if the requesting user has access to the project
 // and preloads the project data to the "project" local
 func (h *ProjectHandler) ProjectAccessMiddleware(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	projectID, err := ctx.ParamsInt("project_id")
 	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
 
-	// find project to check if the requester is the owner
-	project, err := h.srv.FindProject(uint(projectID), "Users")
+	// find project to check if the requester is a member
+	project, err := h.srv.FindProject(uint(projectID), "Users", "Members")
 	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
 
-	// owner always has access
-	if project.OwnerID == u.UserID || util.HasAccess(project, u.UserID) {
+	// access tokens can be restricted to specific projects
+	if !u.CanAccessProject(project.ID) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoAccess))
+	}
+
+	// every role (including the owner) can read the project
+	if util.Can(project, u.UserID, util.PermissionRead) {
 		ctx.Locals("project", *project)
 		return ctx.Next()
 	}
@@ -79,9 +125,34 @@ func (h *ProjectHandler) ProjectAccessMiddleware(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrNoAccess))
 }
 
+// ProjectWriteMiddleware rejects all mutating requests of users without write permission (viewers)
+func (h *ProjectHandler) ProjectWriteMiddleware(ctx *fiber.Ctx) error {
+	switch ctx.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return ctx.Next()
+	}
+	return requirePermission(ctx, util.PermissionWrite)
+}
+
+// requirePermission responds with 403 if the requesting user does not have the permission in the current project,
+// otherwise it continues with the next handler
+func requirePermission(ctx *fiber.Ctx, permission util.Permission) error {
+	if !hasPermission(ctx, permission) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	return ctx.Next()
+}
+
+// hasPermission checks if the requesting user has the permission in the current project
+func hasPermission(ctx *fiber.Ctx, permission util.Permission) bool {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	return util.Can(&p, u.UserID, permission)
+}
+
 // AddProject creates a new project
 func (h *ProjectHandler) AddProject(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	h.logger.Infof("user %s is requesting to create a new project", u.UserID)
 
 	var payload projectDto
@@ -92,7 +163,9 @@ func (h *ProjectHandler) AddProject(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
 
-	project, err := h.srv.CreateProject(payload.Name, payload.Description, u.UserID)
+	quotas := h.quotaSrv.Config()
+	project, err := h.srv.CreateProject(payload.Name, payload.Description, u.UserID,
+		quotas.DefaultMaxFileSize, quotas.DefaultProjectQuota)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
@@ -103,7 +176,7 @@ func (h *ProjectHandler) AddProject(ctx *fiber.Ctx) error {
 
 // GetProjects returns a list of all projects the user has access to
 func (h *ProjectHandler) GetProjects(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	h.logger.Infof("user %s is requesting a list of projects", u.UserID)
 
 	projects := make(map[uint]model.Project)
@@ -126,7 +199,9 @@ func (h *ProjectHandler) GetProjects(ctx *fiber.Ctx) error {
 
 	result := make([]model.Project, 0, len(projects))
 	for _, v := range projects {
-		result = append(result, v)
+		if u.CanAccessProject(v.ID) {
+			result = append(result, v)
+		}
 	}
 
 	sort.Slice(result, func(i, j int) bool {
@@ -137,12 +212,12 @@ func (h *ProjectHandler) GetProjects(ctx *fiber.Ctx) error {
 
 // LeaveProject is a self-service for users
 func (h *ProjectHandler) LeaveProject(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
 	if u.UserID == p.OwnerID {
 		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyUser))
 	}
-	if err := h.srv.RemoveUser(p.ID, u.UserID); err != nil {
+	if err := h.srv.RemoveUser(p.ID, u.UserID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("project left", nil))
@@ -150,12 +225,12 @@ func (h *ProjectHandler) LeaveProject(ctx *fiber.Ctx) error {
 
 // DeleteProject deletes a project
 func (h *ProjectHandler) DeleteProject(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	if u.UserID != p.OwnerID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionDeleteProject) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
-	if err := h.srv.DeleteProject(p.ID); err != nil {
+	if err := h.srv.DeleteProject(p.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("project deleted", nil))
@@ -163,10 +238,10 @@ func (h *ProjectHandler) DeleteProject(ctx *fiber.Ctx) error {
 
 // EditProject edits the name and description of a project
 func (h *ProjectHandler) EditProject(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	if u.UserID != p.OwnerID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionEditProject) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	var payload projectDto
 	if err := ctx.BodyParser(&payload); err != nil {
@@ -175,7 +250,7 @@ func (h *ProjectHandler) EditProject(ctx *fiber.Ctx) error {
 	if err := h.ValidateProjectDto(&payload); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	if err := h.srv.EditProject(p.ID, payload.Name, payload.Description); err != nil {
+	if err := h.srv.EditProject(p.ID, payload.Name, payload.Description, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("project updated", nil))
@@ -199,11 +274,26 @@ func (h *ProjectHandler) GetProject(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("project", p))
 }
 
+type memberRoleDto struct {
+	Role model.ProjectRole `json:"role"`
+}
+
 func (h *ProjectHandler) AddUser(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	if u.UserID != p.OwnerID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	// the role is optional and defaults to member
+	payload := memberRoleDto{Role: model.RoleMember}
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&payload); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+	}
+	if !payload.Role.IsValid() {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrInvalidRole))
+	}
+	actorRole, _ := util.RoleOf(&p, u.UserID)
+	if !util.CanManageRole(actorRole, payload.Role) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	userID := ctx.Params("user_id")
 	// find user
@@ -215,7 +305,7 @@ func (h *ProjectHandler) AddUser(ctx *fiber.Ctx) error {
 	if util.HasAccess(&p, userID) {
 		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrAlreadyInProject))
 	}
-	if err := h.srv.AddUser(p.ID, userID); err != nil {
+	if err := h.srv.AddUser(p.ID, userID, payload.Role, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 
@@ -234,17 +324,19 @@ func (h *ProjectHandler) AddUser(ctx *fiber.Ctx) error {
 }
 
 func (h *ProjectHandler) RemoveUser(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	if u.UserID != p.OwnerID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
-	}
 	userID := ctx.Params("user_id")
 	// check if user is in project
-	if !util.HasAccess(&p, userID) {
+	targetRole, ok := util.RoleOf(&p, userID)
+	if !ok {
 		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotInProject))
 	}
-	if err := h.srv.RemoveUser(p.ID, userID); err != nil {
+	actorRole, _ := util.RoleOf(&p, u.UserID)
+	if !util.CanManageRole(actorRole, targetRole) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.RemoveUser(p.ID, userID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 
@@ -264,20 +356,166 @@ func (h *ProjectHandler) RemoveUser(ctx *fiber.Ctx) error {
 		}
 	}
 
-	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("user added", nil))
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("user removed", nil))
+}
+
+// ChangeUserRole changes the role of a project member
+func (h *ProjectHandler) ChangeUserRole(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	userID := ctx.Params("user_id")
+	var payload memberRoleDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if !payload.Role.IsValid() {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrInvalidRole))
+	}
+	targetRole, ok := util.RoleOf(&p, userID)
+	if !ok {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotInProject))
+	}
+	// the actor must be able to manage both the current and the new role of the user
+	actorRole, _ := util.RoleOf(&p, u.UserID)
+	if !util.CanManageRole(actorRole, targetRole) || !util.CanManageRole(actorRole, payload.Role) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.SetUserRole(p.ID, userID, payload.Role, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("role changed", nil))
+}
+
+// RequestOwnershipTransfer nominates an existing member as the new owner of the project.
+// The ownership is transferred when the nominee accepts the transfer
+func (h *ProjectHandler) RequestOwnershipTransfer(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionTransferOwnership) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	userID := ctx.Params("user_id")
+	if role, ok := util.RoleOf(&p, userID); !ok || role == model.RoleOwner {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotInProject))
+	}
+	if err := h.srv.SetPendingOwner(p.ID, &userID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+
+	// create notification for nominee
+	if err := h.userSrv.CreateNotification(
+		userID,
+		p.Name,
+		"project",
+		fmt.Sprintf("%s wants to transfer the ownership of the project to you", util.GetFriendlyName(ctx)),
+		fmt.Sprintf("/project/%d", p.ID),
+		"Go to Project"); err != nil {
+		h.logger.Warnf("cannot create notification for user %s: %v", userID, err)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("ownership transfer requested", nil))
+}
+
+// AcceptOwnershipTransfer makes the requesting user (the nominee) the new owner of the project
+func (h *ProjectHandler) AcceptOwnershipTransfer(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if p.PendingOwnerID == nil || *p.PendingOwnerID != u.UserID {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoTransfer))
+	}
+	if err := h.srv.TransferOwnership(p.ID, u.UserID); err != nil {
+		if errors.Is(err, services.ErrNoPendingTransfer) {
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+
+	// create notifications for both parties
+	for userID, message := range map[string]string{
+		p.OwnerID: fmt.Sprintf("%s is now the owner of the project, you are now an admin", util.GetFriendlyName(ctx)),
+		u.UserID:  "You are now the owner of the project",
+	} {
+		if err := h.userSrv.CreateNotification(
+			userID,
+			p.Name,
+			"project",
+			message,
+			fmt.Sprintf("/project/%d", p.ID),
+			"Go to Project"); err != nil {
+			h.logger.Warnf("cannot create notification for user %s: %v", userID, err)
+		}
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("ownership transferred", nil))
+}
+
+// CancelOwnershipTransfer cancels (owner) or declines (nominee) a pending ownership transfer
+func (h *ProjectHandler) CancelOwnershipTransfer(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if p.PendingOwnerID == nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNoTransfer))
+	}
+	nomineeID := *p.PendingOwnerID
+	if u.UserID != nomineeID && !hasPermission(ctx, util.PermissionTransferOwnership) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.SetPendingOwner(p.ID, nil, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+
+	// notify the other party
+	receiver, message := nomineeID, "The ownership transfer of the project was cancelled"
+	if u.UserID == nomineeID {
+		receiver = p.OwnerID
+		message = fmt.Sprintf("%s declined the ownership transfer", util.GetFriendlyName(ctx))
+	}
+	if err := h.userSrv.CreateNotification(
+		receiver,
+		p.Name,
+		"project",
+		message,
+		fmt.Sprintf("/project/%d", p.ID),
+		"Go to Project"); err != nil {
+		h.logger.Warnf("cannot create notification for user %s: %v", receiver, err)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("ownership transfer cancelled", nil))
+}
+
+type memberResponse struct {
+	model.User
+	Role model.ProjectRole `json:"role"`
+}
+
+// ListMembersForProject returns all users of the project with their roles
+func (h *ProjectHandler) ListMembersForProject(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	if err := h.srv.Extend(&p, "Owner"); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	members := make([]memberResponse, 0, len(p.Users)+1)
+	members = append(members, memberResponse{p.Owner, model.RoleOwner})
+	for _, u := range p.Users {
+		role, _ := util.RoleOf(&p, u.ID)
+		members = append(members, memberResponse{u, role})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("project members", members))
 }
 
 // Files
 
 var (
-	ErrNoFileUploaded  = errors.New("no files")
-	ErrFileTooBig      = errors.New("file too big")
-	ErrNoFileQuotaLeft = errors.New("no file quota left")
+	ErrNoFileUploaded = errors.New("no files")
+	ErrNoUploadAccess = errors.New("upload was reserved by another user")
 )
 
 func (h *ProjectHandler) UploadFile(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
 
 	form, err := ctx.MultipartForm()
 	if err != nil {
@@ -288,43 +526,35 @@ func (h *ProjectHandler) UploadFile(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrNoFileUploaded))
 	}
 
-	// if the project has a file size quota, check if the user is allowed to upload
-	var totalSize *uint64
-	if p.ProjectFileSizeQuota >= 0 {
-		ts, err := h.srv.GetTotalProjectFileSize(p.ID)
-		if err != nil {
-			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
-		}
-		totalSize = ts
-	}
-
 	var uploaded uint
 	for _, file := range files {
-		if p.MaxProjectFileSize >= 0 && file.Size > p.MaxProjectFileSize {
-			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrFileTooBig))
+		// the quota includes already uploaded files and pending direct uploads
+		if err := h.uploadSrv.CheckQuota(&p, u.UserID, file.Size); err != nil {
+			return quotaErrorResponse(ctx, err)
 		}
-		if totalSize != nil {
-			*totalSize += uint64(file.Size)
-			if *totalSize > uint64(p.ProjectFileSizeQuota) {
-				return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoFileQuotaLeft))
-			}
-		}
-		key, err := h.s3Srv.UploadFile(u.UserID, p.ID, file)
+		object, err := h.uploadFile(u.UserID, p.ID, file)
 		if err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 		}
+		scan := h.scanSrv.ScanObject(object.ObjectKey)
 		// save file to database
-		if err := h.srv.CreateFile(p.ID, model.ProjectFile{
+		projectFile := model.ProjectFile{
 			Name:           file.Filename,
-			ObjectKey:      key,
-			Size:           file.Size,
+			ObjectKey:      object.ObjectKey,
+			Size:           object.Size,
+			Hash:           object.Hash,
+			ScanStatus:     scan.Status,
+			ScanSignature:  scan.Signature,
 			ProjectID:      p.ID,
 			CreatorID:      u.UserID,
 			LastAccessedAt: time.Now(),
 			AccessCount:    0,
-		}); err != nil {
+		}
+		if err := h.srv.CreateFile(p.ID, &projectFile); err != nil {
+			h.releaseObject(object.ObjectKey)
 			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 		}
+		processFile(h.processSrv, h.logger, &projectFile)
 		uploaded++
 	}
 
@@ -334,6 +564,180 @@ func (h *ProjectHandler) UploadFile(ctx *fiber.Ctx) error {
 	))
 }
 
+// uploadFile stores the content of an uploaded file and returns the stored object.
+// Identical content which is already stored is shared
+func (h *ProjectHandler) uploadFile(userID string, projectID uint, file *multipart.FileHeader) (*model.StoredObject, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	// the content type sent by the client is not trusted
+	contentType, body, err := services.DetectContentType(src)
+	if err != nil {
+		return nil, err
+	}
+	object, err := h.objectSrv.Store(body, file.Size, services.PutOptions{
+		ContentType: contentType,
+		Tags: map[string]string{
+			"ProjectID": strconv.FormatUint(uint64(projectID), 10),
+			"UserID":    userID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.logger.Infof("uploaded file %s with key %s", file.Filename, object.ObjectKey)
+	return object, nil
+}
+
+// releaseObject releases a reference to the object. The object is deleted if it is no longer referenced
+func (h *ProjectHandler) releaseObject(key string) {
+	if err := h.objectSrv.Release(key); err != nil {
+		h.logger.Warnf("cannot release object %s: %v", key, err)
+	}
+}
+
+// quotaErrorResponse returns a forbidden response for quota errors and an internal server error otherwise
+func quotaErrorResponse(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrFileTooBig) ||
+		errors.Is(err, services.ErrNoFileQuotaLeft) ||
+		errors.Is(err, services.ErrNoUserQuotaLeft) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+}
+
+// uploadReservationTTL is the time a client has to upload a file directly to the storage
+const uploadReservationTTL = 30 * time.Minute
+
+type reserveUploadDto struct {
+	Name        string `validate:"required,min=1,max=255" json:"name"`
+	Size        int64  `validate:"required,min=1" json:"size"`
+	ContentType string `validate:"max=255" json:"content_type"`
+	// FileID is the ID of the file the upload is a new version of (optional)
+	FileID uint `json:"file_id"`
+}
+
+type reservedUploadResponse struct {
+	Upload *model.ProjectFileUpload `json:"upload"`
+	// URL is the presigned URL the file must be uploaded to
+	URL string `json:"url"`
+	// Method is the HTTP method which must be used for the upload
+	Method string `json:"method"`
+	// Headers are the headers which must be sent with the upload
+	Headers map[string]string `json:"headers"`
+}
+
+// ReserveUpload reserves quota for a file which is uploaded directly to the storage
+// and returns a presigned URL for the upload. The upload must be completed using CompleteUpload
+func (h *ProjectHandler) ReserveUpload(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var payload reserveUploadDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if payload.ContentType == "" {
+		payload.ContentType = fiber.MIMEOctetStream
+	}
+	if payload.FileID != 0 {
+		if _, err := h.srv.FindFile(p.ID, payload.FileID); err != nil {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+	}
+	upload := &model.ProjectFileUpload{
+		Name:        payload.Name,
+		Size:        payload.Size,
+		ContentType: payload.ContentType,
+		FileID:      payload.FileID,
+		CreatorID:   u.UserID,
+	}
+	if err := h.uploadSrv.ReserveUpload(&p, upload, uploadReservationTTL); err != nil {
+		return quotaErrorResponse(ctx, err)
+	}
+	url, err := h.storage.UploadURL(upload.ObjectKey, upload.ContentType, upload.Size, uploadReservationTTL)
+	if err != nil {
+		if cancelErr := h.uploadSrv.CancelUpload(upload); cancelErr != nil {
+			h.logger.Warnf("cannot cancel upload %d: %v", upload.ID, cancelErr)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("upload reserved", reservedUploadResponse{
+		Upload: upload,
+		URL:    url,
+		Method: fiber.MethodPut,
+		Headers: map[string]string{
+			fiber.HeaderContentType: upload.ContentType,
+		},
+	}))
+}
+
+// UploadLocalsMiddleware loads the upload reservation into the "upload" local.
+// Reservations can only be accessed by the user who requested them
+func (h *ProjectHandler) UploadLocalsMiddleware(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	uploadID, err := ctx.ParamsInt("upload_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	upload, err := h.uploadSrv.FindUpload(uint(uploadID))
+	if err != nil || upload.ProjectID != p.ID {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	if upload.CreatorID != u.UserID {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoUploadAccess))
+	}
+	ctx.Locals("upload", *upload)
+	return ctx.Next()
+}
+
+type completeUploadDto struct {
+	// ETag is the entity tag returned by the storage (optional)
+	ETag string `json:"etag"`
+}
+
+// CompleteUpload verifies that the reserved file was uploaded and adds it to the project files
+func (h *ProjectHandler) CompleteUpload(ctx *fiber.Ctx) error {
+	upload := ctx.Locals("upload").(model.ProjectFileUpload)
+	var payload completeUploadDto
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&payload); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+	}
+	file, err := h.uploadSrv.CompleteUpload(&upload, payload.ETag)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadReservationGone):
+			return ctx.Status(fiber.StatusGone).JSON(presenter.ErrorResponse(err))
+		case errors.Is(err, services.ErrUploadNotFound),
+			errors.Is(err, services.ErrUploadSizeMismatch),
+			errors.Is(err, services.ErrUploadETagMismatch):
+			return ctx.Status(fiber.StatusConflict).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	processFile(h.processSrv, h.logger, file)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file uploaded", file))
+}
+
+// CancelUpload deletes the upload reservation and frees the reserved quota
+func (h *ProjectHandler) CancelUpload(ctx *fiber.Ctx) error {
+	upload := ctx.Locals("upload").(model.ProjectFileUpload)
+	if err := h.uploadSrv.CancelUpload(&upload); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("upload cancelled", nil))
+}
+
 func (h *ProjectHandler) ListFiles(ctx *fiber.Ctx) error {
 	p := ctx.Locals("project").(model.Project)
 	files, err := h.srv.FindFiles(p.ID)
@@ -349,27 +753,68 @@ func (h *ProjectHandler) GetFile(ctx *fiber.Ctx) error {
 }
 
 func (h *ProjectHandler) DeleteFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	f := ctx.Locals("file").(model.ProjectFile)
-	// try to delete file from s3
-	if err := h.s3Srv.DeleteFile(f.ObjectKey); err != nil {
+	// users can always delete their own files
+	if f.CreatorID != u.UserID && !hasPermission(ctx, util.PermissionDeleteFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	// delete previous versions of the file
+	versions, err := h.versionSrv.DeleteVersions(f.ID)
+	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	// delete file from database
-	if err := h.srv.DeleteFile(f.ProjectID, f.ID); err != nil {
+	if err := h.srv.DeleteFile(f.ProjectID, f.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
+	// objects (and their thumbnails) are only deleted from the storage if no other file or version
+	// references them. Objects which cannot be deleted are removed by the storage reconciliation
+	h.releaseObject(f.ObjectKey)
+	for _, v := range versions {
+		h.releaseObject(v.ObjectKey)
+	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file deleted", nil))
 }
 
+var (
+	ErrFileInfected   = errors.New("malware was found in the file")
+	ErrFileNotScanned = errors.New("the file was not scanned for malware")
+)
+
+// checkScanStatus returns an error if downloads of content with the scan status are refused.
+// Downloads are only refused if scanning is enforced
+func (h *ProjectHandler) checkScanStatus(status model.ScanStatus) error {
+	if !h.scanSrv.Enforced() {
+		return nil
+	}
+	switch status {
+	case model.ScanStatusClean:
+		return nil
+	case model.ScanStatusInfected:
+		return ErrFileInfected
+	}
+	return ErrFileNotScanned
+}
+
+// downloadURL returns a URL which downloads the object for 60 minutes without further authentication.
+// If downloads are proxied, the URL is signed by the API and the content is streamed by the API,
+// so the storage does not need to be reachable by the client
+func (h *ProjectHandler) downloadURL(ctx *fiber.Ctx, key, name, contentType string) (string, error) {
+	if h.downloadSigner == nil {
+		return h.storage.DownloadURL(key, 60*time.Minute)
+	}
+	query := h.downloadSigner.Sign(key, name, contentType, 60*time.Minute)
+	return fmt.Sprintf("%s/downloads/%s?%s", ctx.BaseURL(), key, query.Encode()), nil
+}
+
 func (h *ProjectHandler) DownloadFile(ctx *fiber.Ctx) error {
-	// get file from s3
 	f := ctx.Locals("file").(model.ProjectFile)
-	req, _ := h.s3Srv.GetObjectRequest(f.ObjectKey)
-	if req.Error != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(req.Error))
+	if err := h.checkScanStatus(f.ScanStatus); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
 	}
 	// create presigned url
-	url, err := req.Presign(60 * time.Minute)
+	url, err := h.downloadURL(ctx, f.ObjectKey, f.Name, f.ContentType)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
@@ -380,16 +825,503 @@ func (h *ProjectHandler) DownloadFile(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file download url", url))
 }
 
+var ErrRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// parseByteRange parses a Range header with a single byte range ("bytes=0-99", "bytes=100-" or "bytes=-100").
+// ok is false if the header should be ignored, e.g. if it is missing or contains multiple ranges
+func parseByteRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, ErrRangeNotSatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// FileContent streams the content of the file through the API.
+// Single byte ranges are supported for seeking in videos. Using ?inline=true
+// the file is displayed in the browser instead of being downloaded
+func (h *ProjectHandler) FileContent(ctx *fiber.Ctx) error {
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := h.checkScanStatus(f.ScanStatus); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	return h.streamObject(ctx, f, f.ObjectKey, f.ContentType)
+}
+
+// streamObject streams an object of the file (the current or a previous version) through the API
+func (h *ProjectHandler) streamObject(ctx *fiber.Ctx, f model.ProjectFile, key, contentType string) error {
+	return sendObject(ctx, h.storage, key, f.Name, contentType, ctx.QueryBool("inline"), func() {
+		if err := h.srv.UpdateFileAccess(f.ID); err != nil {
+			h.logger.Warnf("cannot update file access: %v", err)
+		}
+	})
+}
+
+// sendObject streams an object of the storage as a download with the given file name.
+// If inline is true, the object is displayed in the browser instead of being downloaded.
+// Single byte ranges are supported. onDownload (optional) is called when a download starts
+func sendObject(
+	ctx *fiber.Ctx,
+	storage services.StorageService,
+	key, name, contentType string,
+	inline bool,
+	onDownload func(),
+) error {
+	info, err := storage.Stat(key)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	lastModified := info.LastModified.UTC().Format(http.TimeFormat)
+	if info.ETag != "" {
+		ctx.Set(fiber.HeaderETag, info.ETag)
+		if ctx.Get(fiber.HeaderIfNoneMatch) == info.ETag {
+			return ctx.SendStatus(fiber.StatusNotModified)
+		}
+	}
+	ctx.Set(fiber.HeaderLastModified, lastModified)
+	ctx.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	ctx.Set(fiber.HeaderContentType, contentType)
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	ctx.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{
+		"filename": name,
+	}))
+
+	start, length := int64(0), info.Size
+	status := fiber.StatusOK
+	// ranges are ignored if the file changed since the client requested the first part
+	ifRange := ctx.Get(fiber.HeaderIfRange)
+	if rangeHeader := ctx.Get(fiber.HeaderRange); rangeHeader != "" && (ifRange == "" || ifRange == info.ETag || ifRange == lastModified) {
+		var ok bool
+		start, length, ok, err = parseByteRange(rangeHeader, info.Size)
+		if err != nil {
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+			return ctx.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(presenter.ErrorResponse(err))
+		}
+		if ok {
+			status = fiber.StatusPartialContent
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		} else {
+			start, length = 0, info.Size
+		}
+	}
+
+	// only count the first request of a download, not every seek
+	if start == 0 && ctx.Method() == fiber.MethodGet && onDownload != nil {
+		onDownload()
+	}
+	ctx.Status(status)
+	if ctx.Method() == fiber.MethodHead || length == 0 {
+		ctx.Response().Header.SetContentLength(int(length))
+		return nil
+	}
+	body, _, err := storage.GetRange(key, start, length)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	// fasthttp closes the body after it has been sent
+	return ctx.SendStream(body, int(length))
+}
+
+// maxArchiveFiles is the maximum number of files which can be selected for an archive
+const maxArchiveFiles = 1000
+
+var (
+	ErrNoArchiveFiles      = errors.New("no files selected (use ?ids=1,2,3 or ?meeting_id=1)")
+	ErrTooManyArchiveFiles = fmt.Errorf("too many files selected (max. %d)", maxArchiveFiles)
+	ErrArchiveFileNotFound = errors.New("some of the selected files do not exist")
+)
+
+// parseFileIDs parses a comma separated list of file IDs and removes duplicates
+func parseFileIDs(raw string) ([]uint, error) {
+	var ids []uint
+	seen := make(map[uint]struct{})
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[uint(id)]; ok {
+			continue
+		}
+		seen[uint(id)] = struct{}{}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// archiveEntryName returns a name for the file inside the archive which was not used before.
+// Collisions are resolved by appending a counter to the name: "report.pdf" -> "report (1).pdf"
+func archiveEntryName(name string, used map[string]struct{}) string {
+	// the name must not contain a directory, otherwise it is extracted to another location
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "file"
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		// file systems on Windows and macOS are case-insensitive
+		key := strings.ToLower(candidate)
+		if _, ok := used[key]; !ok {
+			used[key] = struct{}{}
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// archiveMethod returns the compression method for the file.
+// Compressing media and archives costs CPU time without reducing their size
+func archiveMethod(contentType string) uint16 {
+	switch {
+	case strings.HasPrefix(contentType, "image/"),
+		strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "audio/"),
+		contentType == "application/zip",
+		contentType == "application/gzip",
+		contentType == "application/pdf":
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// DownloadArchive streams a ZIP archive of the selected files (?ids=1,2,3) or of
+// all files attached to a meeting (?meeting_id=1). The files are read from the storage
+// one after another, so the archive is never buffered completely
+func (h *ProjectHandler) DownloadArchive(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	var (
+		files       []model.ProjectFile
+		archiveName string
+	)
+	switch {
+	case ctx.Query("meeting_id") != "":
+		meetingID, err := strconv.ParseUint(ctx.Query("meeting_id"), 10, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if files, err = h.srv.FindMeetingFiles(p.ID, uint(meetingID)); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+		}
+		if len(files) == 0 {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		archiveName = fmt.Sprintf("%s-meeting-%d.zip", p.Name, meetingID)
+	case ctx.Query("ids") != "":
+		ids, err := parseFileIDs(ctx.Query("ids"))
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if len(ids) > maxArchiveFiles {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrTooManyArchiveFiles))
+		}
+		if files, err = h.srv.FindFilesByIDs(p.ID, ids); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+		}
+		if len(files) != len(ids) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrArchiveFileNotFound))
+		}
+		archiveName = fmt.Sprintf("%s-files.zip", p.Name)
+	default:
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrNoArchiveFiles))
+	}
+	if len(files) > maxArchiveFiles {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrTooManyArchiveFiles))
+	}
+	for _, f := range files {
+		if err := h.checkScanStatus(f.ScanStatus); err != nil {
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(fmt.Errorf("%s: %w", f.Name, err)))
+		}
+	}
+
+	ctx.Set(fiber.HeaderContentType, "application/zip")
+	ctx.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName,
+	}))
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	ctx.Status(fiber.StatusOK)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := h.writeArchive(w, files); err != nil {
+			// the status was already sent, so the client is only notified by the incomplete archive
+			h.logger.Warnf("cannot write archive of project %d: %v", p.ID, err)
+		}
+	})
+	return nil
+}
+
+// writeArchive writes the files as ZIP archive to w
+func (h *ProjectHandler) writeArchive(w *bufio.Writer, files []model.ProjectFile) error {
+	zw := zip.NewWriter(w)
+	used := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		body, _, err := h.storage.Get(f.ObjectKey)
+		if err != nil {
+			return fmt.Errorf("cannot read file %d: %w", f.ID, err)
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     archiveEntryName(f.Name, used),
+			Method:   archiveMethod(f.ContentType),
+			Modified: f.UpdatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, body)
+		}
+		_ = body.Close()
+		if err != nil {
+			return fmt.Errorf("cannot write file %d: %w", f.ID, err)
+		}
+		// send the file to the client before reading the next one
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		if err = h.srv.UpdateFileAccess(f.ID); err != nil {
+			h.logger.Warnf("cannot update file access: %v", err)
+		}
+	}
+	return zw.Close()
+}
+
+// processFile detects the content type of an uploaded file and queues the generation of its thumbnail.
+// The upload is not failed if the file cannot be processed
+func processFile(srv services.FileProcessingService, logger *zap.SugaredLogger, file *model.ProjectFile) {
+	if err := srv.ProcessFile(file); err != nil {
+		logger.Warnf("cannot process file %d: %v", file.ID, err)
+	}
+}
+
+// Thumbnail returns the generated thumbnail of an image file
+func (h *ProjectHandler) Thumbnail(ctx *fiber.Ctx) error {
+	f := ctx.Locals("file").(model.ProjectFile)
+	// thumbnails are generated from the content, so they are not shown for infected files
+	if f.ScanStatus == model.ScanStatusInfected {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrFileInfected))
+	}
+	if err := h.checkScanStatus(f.ScanStatus); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	if f.ThumbnailKey == "" {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	// the thumbnail changes with the current version of the file (e.g. after a new version
+	// was uploaded or an old version was restored), so the content is used as the entity tag
+	// and clients have to revalidate the thumbnail before using a cached copy
+	etag := `"` + f.ObjectKey + `"`
+	ctx.Set(fiber.HeaderETag, etag)
+	ctx.Set(fiber.HeaderCacheControl, "private, no-cache")
+	if ctx.Get(fiber.HeaderIfNoneMatch) == etag {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+	body, info, err := h.storage.Get(f.ThumbnailKey)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Set(fiber.HeaderContentType, services.ThumbnailContentType)
+	return ctx.SendStream(body, int(info.Size))
+}
+
+var ErrScannerUnavailable = errors.New("file could not be scanned")
+
+// ScanFile scans the current version of the file again, e.g. if the scanner
+// was not reachable during the upload or the signatures were updated
+func (h *ProjectHandler) ScanFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	// the result applies to all files and versions with identical content
+	scan := h.scanSrv.ScanObject(f.ObjectKey)
+	if err := h.srv.UpdateObjectScan(f.ObjectKey, scan, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	// the result of an earlier scan is kept if the scanner is not available
+	if scan.Status == model.ScanStatusUnscanned {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(presenter.ErrorResponse(ErrScannerUnavailable))
+	}
+	f.ScanStatus = scan.Status
+	f.ScanSignature = scan.Signature
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file scanned", f))
+}
+
+// Versions
+
+var ErrNoVersionUploaded = errors.New("exactly one file must be uploaded as new version")
+
+// UploadVersion uploads a new version of the file. The previous version is kept in the version history
+func (h *ProjectHandler) UploadVersion(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	files := form.File["file"]
+	if len(files) != 1 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrNoVersionUploaded))
+	}
+	// previous versions count towards the quota
+	if err = h.uploadSrv.CheckQuota(&p, u.UserID, files[0].Size); err != nil {
+		return quotaErrorResponse(ctx, err)
+	}
+	object, err := h.uploadFile(u.UserID, p.ID, files[0])
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	scan := h.scanSrv.ScanObject(object.ObjectKey)
+	file, err := h.versionSrv.AddVersion(f.ID, object, scan, u.UserID)
+	if err != nil {
+		h.releaseObject(object.ObjectKey)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	processFile(h.processSrv, h.logger, file)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("version uploaded", file))
+}
+
+type fileVersionsResponse struct {
+	// Current is the file with its current version
+	Current model.ProjectFile `json:"current"`
+	// Versions are the previous versions of the file (newest first)
+	Versions []model.ProjectFileVersion `json:"versions"`
+}
+
+// ListVersions returns the version history of the file
+func (h *ProjectHandler) ListVersions(ctx *fiber.Ctx) error {
+	f := ctx.Locals("file").(model.ProjectFile)
+	versions, err := h.versionSrv.FindVersions(f.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file versions", fileVersionsResponse{
+		Current:  f,
+		Versions: versions,
+	}))
+}
+
+// FileVersionLocalsMiddleware loads a previous version of the file into the "file_version" local
+func (h *ProjectHandler) FileVersionLocalsMiddleware(ctx *fiber.Ctx) error {
+	f := ctx.Locals("file").(model.ProjectFile)
+	version, err := ctx.ParamsInt("version")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	v, err := h.versionSrv.FindVersion(f.ID, version)
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	ctx.Locals("file_version", *v)
+	return ctx.Next()
+}
+
+// DownloadVersion returns a download url for a previous version of the file
+func (h *ProjectHandler) DownloadVersion(ctx *fiber.Ctx) error {
+	v := ctx.Locals("file_version").(model.ProjectFileVersion)
+	if err := h.checkScanStatus(v.ScanStatus); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	f := ctx.Locals("file").(model.ProjectFile)
+	url, err := h.downloadURL(ctx, v.ObjectKey, f.Name, v.ContentType)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file version download url", url))
+}
+
+// VersionContent streams the content of a previous version of the file through the API
+func (h *ProjectHandler) VersionContent(ctx *fiber.Ctx) error {
+	f := ctx.Locals("file").(model.ProjectFile)
+	v := ctx.Locals("file_version").(model.ProjectFileVersion)
+	if err := h.checkScanStatus(v.ScanStatus); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	return h.streamObject(ctx, f, v.ObjectKey, v.ContentType)
+}
+
+// RestoreVersion makes a previous version the current version of the file
+func (h *ProjectHandler) RestoreVersion(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	f := ctx.Locals("file").(model.ProjectFile)
+	v := ctx.Locals("file_version").(model.ProjectFileVersion)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	file, err := h.versionSrv.RestoreVersion(f.ID, v.Version, u.UserID)
+	if err != nil {
+		if errors.Is(err, services.ErrVersionIsCurrent) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("version restored", file))
+}
+
 type quotaInfoResponse struct {
 	// TotalSize is the total size of all files in the project
 	TotalSize uint64 `json:"total_size"`
+	// Reserved is the size of all pending direct uploads of the project
+	Reserved uint64 `json:"reserved"`
 	// Quota is the quota (max total size of all files) of the project
 	Quota int64 `json:"quota"`
 	// MaxFileSize is the maximum file size of a single file
 	MaxFileSize int64 `json:"max_file_size"`
+	// User is the quota of the requesting user across all projects
+	User *services.UserQuotaInfo `json:"user"`
 }
 
 func (h *ProjectHandler) FileQuotaInfo(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
 	totalSize, err := h.srv.GetTotalProjectFileSize(p.ID)
 	if err != nil {
@@ -399,9 +1331,56 @@ func (h *ProjectHandler) FileQuotaInfo(ctx *fiber.Ctx) error {
 	if totalSize != nil {
 		totalSizeUint64 = *totalSize
 	}
+	usedSize, err := h.uploadSrv.UsedQuota(p.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	userQuota, err := h.quotaSrv.UserQuotaInfo(u.UserID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	// both sizes are read separately, so a file completed in between can be included
+	// in the total size but not in the used size
+	var reserved uint64
+	if usedSize > totalSizeUint64 {
+		reserved = usedSize - totalSizeUint64
+	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("", quotaInfoResponse{
 		TotalSize:   totalSizeUint64,
+		Reserved:    reserved,
 		Quota:       p.ProjectFileSizeQuota,
 		MaxFileSize: p.MaxProjectFileSize,
+		User:        userQuota,
 	}))
 }
+
+type fileQuotaDto struct {
+	// MaxFileSize is the maximum size of a single file (-1 for unlimited)
+	MaxFileSize int64 `json:"max_file_size" validate:"min=-1"`
+	// Quota is the maximum size of all files in the project (-1 for unlimited)
+	Quota int64 `json:"quota" validate:"min=-1"`
+}
+
+// EditFileQuota changes the maximum file size and file quota of the project.
+// The values are limited by the quota configuration of the instance
+func (h *ProjectHandler) EditFileQuota(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionManageQuota) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	var payload fileQuotaDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.quotaSrv.SetProjectQuota(p.ID, payload.MaxFileSize, payload.Quota, u.UserID); err != nil {
+		if errors.Is(err, services.ErrQuotaAboveLimit) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file quota updated", nil))
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type RetentionHandler struct {
+	srv       services.RetentionService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewRetentionHandler(
+	srv services.RetentionService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *RetentionHandler {
+	return &RetentionHandler{srv, logger, validator}
+}
+
+// retentionErrorStatus returns the status code for errors of the retention service
+func retentionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRetentionPolicyNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrRetentionNoCondition), errors.Is(err, services.ErrArchiveNotSupported):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+type retentionPolicyDto struct {
+	Name           string                `json:"name" validate:"required,min=1,max=64"`
+	Action         model.RetentionAction `json:"action" validate:"required,oneof=delete archive"`
+	MaxIdleDays    int                   `json:"max_idle_days" validate:"min=0,max=36500"`
+	MeetingAgeDays int                   `json:"meeting_age_days" validate:"min=0,max=36500"`
+	NoticeDays     int                   `json:"notice_days" validate:"min=0,max=365"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+// parsePolicy parses and validates the request body and applies it to the policy
+func (h *RetentionHandler) parsePolicy(ctx *fiber.Ctx, policy *model.RetentionPolicy) error {
+	var dto retentionPolicyDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return err
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		return err
+	}
+	policy.Name = dto.Name
+	policy.Action = dto.Action
+	policy.MaxIdleDays = dto.MaxIdleDays
+	policy.MeetingAgeDays = dto.MeetingAgeDays
+	policy.NoticeDays = dto.NoticeDays
+	policy.Enabled = dto.Enabled == nil || *dto.Enabled
+	return nil
+}
+
+func (h *RetentionHandler) ListPolicies(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	policies, err := h.srv.FindPolicies(p.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("retention policies", policies))
+}
+
+func (h *RetentionHandler) CreatePolicy(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionEditProject) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	policy := model.RetentionPolicy{
+		ProjectID: p.ID,
+		CreatorID: u.UserID,
+	}
+	if err := h.parsePolicy(ctx, &policy); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.CreatePolicy(&policy, u.UserID); err != nil {
+		return ctx.Status(retentionErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("created retention policy", policy))
+}
+
+// Report lists the files which are scheduled for cleanup by retention policies
+func (h *RetentionHandler) Report(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	files, err := h.srv.ScheduledCleanups(p.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("scheduled cleanups", fiber.Map{
+		"files": files,
+		"size":  size,
+	}))
+}
+
+func (h *RetentionHandler) RetentionPolicyLocalsMiddleware(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	policyID, err := ctx.ParamsInt("policy_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	policy, err := h.srv.FindPolicy(p.ID, uint(policyID))
+	if err != nil {
+		return ctx.Status(retentionErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Locals("retention_policy", *policy)
+	return ctx.Next()
+}
+
+// :policy_id
+
+func (h *RetentionHandler) FindPolicy(ctx *fiber.Ctx) error {
+	policy := ctx.Locals("retention_policy").(model.RetentionPolicy)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("found retention policy", policy))
+}
+
+func (h *RetentionHandler) EditPolicy(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	policy := ctx.Locals("retention_policy").(model.RetentionPolicy)
+	if !hasPermission(ctx, util.PermissionEditProject) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.parsePolicy(ctx, &policy); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.UpdatePolicy(&policy, u.UserID); err != nil {
+		return ctx.Status(retentionErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("updated retention policy", policy))
+}
+
+func (h *RetentionHandler) DeletePolicy(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	policy := ctx.Locals("retention_policy").(model.RetentionPolicy)
+	if !hasPermission(ctx, util.PermissionEditProject) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.DeletePolicy(&policy, u.UserID); err != nil {
+		return ctx.Status(retentionErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("deleted retention policy", nil))
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/rrule"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"time"
+)
+
+// maxOccurrenceSpan is the maximum time span for which occurrences can be listed or created at once
+const maxOccurrenceSpan = 366 * 24 * time.Hour
+
+var ErrSpanTooLong = errors.New("time span must not exceed one year")
+
+type SeriesHandler struct {
+	srv       services.SeriesService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewSeriesHandler(
+	srv services.SeriesService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *SeriesHandler {
+	return &SeriesHandler{srv, logger, validator}
+}
+
+// seriesErrorStatus returns the status code for errors of the series service
+func seriesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrSeriesNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrInvalidTimeZone),
+		errors.Is(err, services.ErrNotAnOccurrence),
+		errors.Is(err, rrule.ErrInvalidRule),
+		errors.Is(err, rrule.ErrUnsupportedRule),
+		errors.Is(err, rrule.ErrCountAndUntil),
+		errors.Is(err, rrule.ErrUnsupportedByDay),
+		errors.Is(err, rrule.ErrUnsupportedMonthly):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+type seriesDto struct {
+	meetingDto
+	// TimeZone is the IANA time zone of the series (defaults to UTC)
+	TimeZone string   `json:"time_zone"`
+	RRule    string   `validate:"required,max=256" json:"rrule"`
+	ExDates  []string `validate:"dive,datetime=2006-01-02T15:04:05Z07:00" json:"exdates"`
+}
+
+// parseSeries parses and validates the request body and applies it to the series
+func (h *SeriesHandler) parseSeries(ctx *fiber.Ctx, series *model.MeetingSeries) error {
+	var dto seriesDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return err
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		return err
+	}
+	if len(dto.Description) > MaxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+	startTime, err := time.Parse(time.RFC3339, dto.StartDate)
+	if err != nil {
+		return err
+	}
+	endTime, err := time.Parse(time.RFC3339, dto.EndDate)
+	if err != nil {
+		return err
+	}
+	if endTime.Before(startTime) {
+		return ErrEndBeforeStart
+	}
+	exDates := make(model.TimeList, len(dto.ExDates))
+	for i, raw := range dto.ExDates {
+		if exDates[i], err = time.Parse(time.RFC3339, raw); err != nil {
+			return err
+		}
+	}
+	if dto.TimeZone == "" {
+		dto.TimeZone = "UTC"
+	}
+	series.Name = dto.Name
+	series.Description = dto.Description
+	series.StartDate = startTime
+	series.EndDate = endTime
+	series.TimeZone = dto.TimeZone
+	series.RRule = dto.RRule
+	series.ExDates = exDates
+	return nil
+}
+
+func (h *SeriesHandler) ListSeries(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	series, err := h.srv.FindSeriesForProject(p.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting series", series))
+}
+
+func (h *SeriesHandler) CreateSeries(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	series := model.MeetingSeries{
+		ProjectID: p.ID,
+		CreatorID: u.UserID,
+	}
+	if err := h.parseSeries(ctx, &series); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.CreateSeries(&series); err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.MaterializeSeries(&series, time.Now().Add(services.SeriesHorizon)); err != nil {
+		h.logger.Warnf("cannot create occurrences of meeting series %d: %v", series.ID, err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("meeting series created", series))
+}
+
+func (h *SeriesHandler) SeriesLocalsMiddleware(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	seriesID, err := ctx.ParamsInt("series_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	series, err := h.srv.FindSeries(p.ID, uint(seriesID))
+	if err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Locals("series", *series)
+	return ctx.Next()
+}
+
+// :series_id
+
+func (h *SeriesHandler) FindSeries(ctx *fiber.Ctx) error {
+	series := ctx.Locals("series").(model.MeetingSeries)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("found meeting series", series))
+}
+
+// EditSeries changes the series. Past meetings and edited occurrences are kept unchanged
+func (h *SeriesHandler) EditSeries(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	series := ctx.Locals("series").(model.MeetingSeries)
+	if err := h.parseSeries(ctx, &series); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.srv.UpdateSeries(&series, u.UserID); err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting series edited", series))
+}
+
+// DeleteSeries deletes the series and its upcoming meetings. Upcoming meetings with topics are kept
+func (h *SeriesHandler) DeleteSeries(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	series := ctx.Locals("series").(model.MeetingSeries)
+	if !hasPermission(ctx, util.PermissionDeleteMeetings) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	if err := h.srv.DeleteSeries(&series, u.UserID); err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting series deleted", nil))
+}
+
+// queryTime parses the RFC 3339 query parameter or returns def if it is not set
+func queryTime(ctx *fiber.Ctx, key string, def time.Time) (time.Time, error) {
+	raw := ctx.Query(key)
+	if raw == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// Occurrences lists the occurrences of the series between "?from=" (default now)
+// and "?to=" (default now + SeriesHorizon)
+func (h *SeriesHandler) Occurrences(ctx *fiber.Ctx) error {
+	series := ctx.Locals("series").(model.MeetingSeries)
+	from, err := queryTime(ctx, "from", time.Now())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	to, err := queryTime(ctx, "to", from.Add(services.SeriesHorizon))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if to.Sub(from) > maxOccurrenceSpan {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrSpanTooLong))
+	}
+	occurrences, err := h.srv.Occurrences(&series, from, to)
+	if err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("occurrences", occurrences))
+}
+
+// Materialize creates meetings for all occurrences of the series starting before "?until="
+func (h *SeriesHandler) Materialize(ctx *fiber.Ctx) error {
+	series := ctx.Locals("series").(model.MeetingSeries)
+	until, err := queryTime(ctx, "until", time.Now().Add(services.SeriesHorizon))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if until.Sub(time.Now()) > maxOccurrenceSpan {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrSpanTooLong))
+	}
+	if err = h.srv.MaterializeSeries(&series, until); err != nil {
+		return ctx.Status(seriesErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("created occurrences", series))
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"io"
+	"strconv"
+)
+
+type StorageHandler struct {
+	srv       services.LocalStorage
+	uploadSrv services.UploadService
+	logger    *zap.SugaredLogger
+}
+
+func NewStorageHandler(
+	srv services.LocalStorage,
+	uploadSrv services.UploadService,
+	logger *zap.SugaredLogger,
+) *StorageHandler {
+	return &StorageHandler{srv, uploadSrv, logger}
+}
+
+// ServeSignedFile serves a file of the local storage driver if the signature of the URL is valid.
+// This route does not require authentication since the signature is only issued to authorized users
+func (h *StorageHandler) ServeSignedFile(ctx *fiber.Ctx) error {
+	key := ctx.Params("*")
+	if err := h.srv.VerifyDownloadSignature(key, ctx.Query("expires"), ctx.Query("signature")); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	body, info, err := h.srv.Get(key)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	if info.ContentType != "" {
+		ctx.Set(fiber.HeaderContentType, info.ContentType)
+	} else {
+		ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	}
+	// fasthttp closes the body after it has been sent
+	return ctx.SendStream(body, int(info.Size))
+}
+
+// UploadSignedFile stores the request body in the local storage driver if the signature of the URL is valid.
+// It is the equivalent of a presigned PUT URL of S3
+func (h *StorageHandler) UploadSignedFile(ctx *fiber.Ctx) error {
+	key := ctx.Params("*")
+	sizeStr := ctx.Query("size")
+	if err := h.srv.VerifyUploadSignature(key, ctx.Query("expires"), sizeStr, ctx.Query("signature")); err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	// the signature stays valid after the upload was completed or cancelled,
+	// so the object may only be written while it is reserved
+	if _, err = h.uploadSrv.FindUploadByKey(key); err != nil {
+		if errors.Is(err, services.ErrUploadReservationGone) {
+			return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(err))
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	var body io.Reader
+	if stream := ctx.Context().RequestBodyStream(); stream != nil {
+		body = stream
+	} else {
+		body = bytes.NewReader(ctx.Body())
+	}
+	// read at most one byte more than announced to detect oversized uploads
+	counter := &countingReader{r: io.LimitReader(body, size+1)}
+	if err = h.srv.Put(key, counter, size, services.PutOptions{
+		ContentType: ctx.Get(fiber.HeaderContentType),
+	}); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	if counter.n != size {
+		if err = h.srv.Delete(key); err != nil {
+			h.logger.Warnf("cannot delete oversized upload %s: %v", key, err)
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(services.ErrUploadSizeMismatch))
+	}
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
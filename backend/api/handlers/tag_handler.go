@@ -3,10 +3,11 @@ package handlers
 import (
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 )
 
@@ -39,10 +40,10 @@ type tagDto struct {
 }
 
 func (a TagHandler) CreateTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManageTags) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	var dto tagDto
 	if err := ctx.BodyParser(&dto); err != nil {
@@ -51,7 +52,7 @@ func (a TagHandler) CreateTag(ctx *fiber.Ctx) error {
 	if err := a.validator.Struct(dto); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	tag, err := a.srv.CreateTag(dto.Title, dto.Color, p.ID)
+	tag, err := a.srv.CreateTag(dto.Title, dto.Color, p.ID, u.UserID)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
@@ -79,11 +80,10 @@ func (a TagHandler) FindTag(ctx *fiber.Ctx) error {
 }
 
 func (a TagHandler) EditTag(ctx *fiber.Ctx) error {
-	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("tag").(model.Tag)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManageTags) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
 	var dto tagDto
 	if err := ctx.BodyParser(&dto); err != nil {
@@ -92,20 +92,19 @@ func (a TagHandler) EditTag(ctx *fiber.Ctx) error {
 	if err := a.validator.Struct(dto); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
-	if err := a.srv.EditTag(t.ID, dto.Title, dto.Color); err != nil {
+	if err := a.srv.EditTag(t.ID, dto.Title, dto.Color, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("updated tag", nil))
 }
 
 func (a TagHandler) DeleteTag(ctx *fiber.Ctx) error {
-	p := ctx.Locals("project").(model.Project)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("tag").(model.Tag)
-	if p.OwnerID != u.UserID {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(presenter.ErrorResponse(ErrOnlyOwner))
+	if !hasPermission(ctx, util.PermissionManageTags) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
 	}
-	if err := a.srv.DeleteTag(t.ID); err != nil {
+	if err := a.srv.DeleteTag(t.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("deleted tag", nil))
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"time"
+)
+
+type TemplateHandler struct {
+	srv       services.TemplateService
+	logger    *zap.SugaredLogger
+	validator *validator.Validate
+}
+
+func NewTemplateHandler(
+	srv services.TemplateService,
+	logger *zap.SugaredLogger,
+	validator *validator.Validate,
+) *TemplateHandler {
+	return &TemplateHandler{srv, logger, validator}
+}
+
+// templateErrorStatus returns the status code for errors of the template service
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTemplateNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrInvalidTemplate):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+type templateDto struct {
+	Name        string `validate:"required,min=1,max=128,startsnotwith= ,endsnotwith= " json:"name"`
+	Description string `json:"description"`
+	// Duration is the default duration of meetings in minutes
+	Duration int        `validate:"required,min=1,max=1440" json:"duration"`
+	TagIDs   []uint     `json:"tag_ids"`
+	UserIDs  []string   `json:"user_ids"`
+	Topics   []topicDto `validate:"max=100,dive" json:"topics"`
+}
+
+// parseTemplate parses and validates the request body and applies it to the template
+func (h *TemplateHandler) parseTemplate(ctx *fiber.Ctx, template *model.MeetingTemplate) ([]uint, []string, error) {
+	var dto templateDto
+	if err := ctx.BodyParser(&dto); err != nil {
+		return nil, nil, err
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		return nil, nil, err
+	}
+	if len(dto.Description) > MaxDescriptionLength {
+		return nil, nil, ErrDescriptionTooLong
+	}
+	template.Name = dto.Name
+	template.Description = dto.Description
+	template.Duration = dto.Duration
+	template.Topics = make([]model.TemplateTopic, len(dto.Topics))
+	for i, topic := range dto.Topics {
+		if len(topic.Description) > MaxDescriptionLength {
+			return nil, nil, ErrDescriptionTooLong
+		}
+		template.Topics[i] = model.TemplateTopic{
+			Title:         topic.Title,
+			Description:   topic.Description,
+			ForceSolution: topic.ForceSolution,
+		}
+		if topic.PriorityID > 0 {
+			priorityID := topic.PriorityID
+			template.Topics[i].PriorityID = &priorityID
+		}
+	}
+	return dto.TagIDs, dto.UserIDs, nil
+}
+
+func (h *TemplateHandler) ListTemplates(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	templates, err := h.srv.FindTemplates(p.ID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting templates", templates))
+}
+
+func (h *TemplateHandler) CreateTemplate(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	template := model.MeetingTemplate{
+		ProjectID: p.ID,
+		CreatorID: u.UserID,
+	}
+	tagIDs, userIDs, err := h.parseTemplate(ctx, &template)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err = h.srv.CreateTemplate(&template, tagIDs, userIDs); err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	created, err := h.srv.FindTemplate(p.ID, template.ID)
+	if err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("meeting template created", created))
+}
+
+func (h *TemplateHandler) TemplateLocalsMiddleware(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	templateID, err := ctx.ParamsInt("template_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	template, err := h.srv.FindTemplate(p.ID, uint(templateID))
+	if err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	ctx.Locals("template", *template)
+	return ctx.Next()
+}
+
+// :template_id
+
+func (h *TemplateHandler) FindTemplate(ctx *fiber.Ctx) error {
+	template := ctx.Locals("template").(model.MeetingTemplate)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("found meeting template", template))
+}
+
+// EditTemplate replaces the template. Meetings created from the template are not changed
+func (h *TemplateHandler) EditTemplate(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	template := ctx.Locals("template").(model.MeetingTemplate)
+	tagIDs, userIDs, err := h.parseTemplate(ctx, &template)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err = h.srv.UpdateTemplate(&template, tagIDs, userIDs, u.UserID); err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	updated, err := h.srv.FindTemplate(template.ProjectID, template.ID)
+	if err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting template edited", updated))
+}
+
+func (h *TemplateHandler) DeleteTemplate(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	template := ctx.Locals("template").(model.MeetingTemplate)
+	if err := h.srv.DeleteTemplate(&template, u.UserID); err != nil {
+		return ctx.Status(templateErrorStatus(err)).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("meeting template deleted", nil))
+}
+
+type templateMeetingDto struct {
+	// Name defaults to the name of the template
+	Name string `validate:"omitempty,max=128,startsnotwith= ,endsnotwith= " json:"name"`
+	// Description defaults to the description of the template
+	Description string `json:"description"`
+	StartDate   string `validate:"required,datetime=2006-01-02T15:04:05Z07:00" json:"start_date"`
+	// EndDate defaults to the start date plus the duration of the template
+	EndDate string `validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00" json:"end_date"`
+}
+
+// CreateMeeting creates a meeting with the tags, users and topics of the template
+func (h *TemplateHandler) CreateMeeting(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	template := ctx.Locals("template").(model.MeetingTemplate)
+	var payload templateMeetingDto
+	if err := ctx.BodyParser(&payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if err := h.validator.Struct(payload); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	if len(payload.Description) > MaxDescriptionLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrDescriptionTooLong))
+	}
+	startTime, err := time.Parse(time.RFC3339, payload.StartDate)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	endTime := startTime.Add(time.Duration(template.Duration) * time.Minute)
+	if payload.EndDate != "" {
+		if endTime, err = time.Parse(time.RFC3339, payload.EndDate); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if endTime.Before(startTime) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrEndBeforeStart))
+		}
+	}
+	if payload.Name == "" {
+		payload.Name = template.Name
+	}
+	if payload.Description == "" {
+		payload.Description = template.Description
+	}
+	created, err := h.srv.CreateMeeting(&template, u.UserID, payload.Name, payload.Description, startTime, endTime)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(presenter.SuccessResponse("meeting created", created))
+}
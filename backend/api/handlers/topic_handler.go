@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/lexorank"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/darmiel/perplex/pkg/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -78,7 +78,7 @@ func (h *TopicHandler) TopicAuthorizationMiddleware(ctx *fiber.Ctx) error {
 // AddTopic adds a new topic to a meeting.
 // It retrieves topic details from the request body and validates it.
 func (h *TopicHandler) AddTopic(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	m := ctx.Locals("meeting").(model.Meeting)
 
 	var payload topicDto
@@ -122,8 +122,9 @@ func (h *TopicHandler) GetTopic(ctx *fiber.Ctx) error {
 
 // DeleteTopic deletes the topic from a meeting.
 func (h *TopicHandler) DeleteTopic(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("topic").(model.Topic)
-	if err := h.srv.DeleteTopic(t.ID); err != nil {
+	if err := h.srv.DeleteTopic(t.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("topic deleted", nil))
@@ -131,6 +132,7 @@ func (h *TopicHandler) DeleteTopic(ctx *fiber.Ctx) error {
 
 // EditTopic edits the details of an existing topic.
 func (h *TopicHandler) EditTopic(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("topic").(model.Topic)
 
 	var payload topicDto
@@ -141,7 +143,7 @@ func (h *TopicHandler) EditTopic(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
 	}
 
-	if err := h.srv.EditTopic(t.ID, payload.Title, payload.Description, payload.ForceSolution, payload.PriorityID); err != nil {
+	if err := h.srv.EditTopic(t.ID, payload.Title, payload.Description, payload.ForceSolution, payload.PriorityID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("topic edited", nil))
@@ -149,11 +151,12 @@ func (h *TopicHandler) EditTopic(ctx *fiber.Ctx) error {
 
 // SetStatusChecked sets the status of a topic as checked (or closed).
 func (h *TopicHandler) SetStatusChecked(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("topic").(model.Topic)
 	if t.ForceSolution && t.SolutionID <= 0 {
 		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoSolution))
 	}
-	if err := h.srv.CheckTopic(t.ID); err != nil {
+	if err := h.srv.CheckTopic(t.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("topic closed", nil))
@@ -161,23 +164,58 @@ func (h *TopicHandler) SetStatusChecked(ctx *fiber.Ctx) error {
 
 // SetStatusUnchecked sets the status of a topic as unchecked (or opened).
 func (h *TopicHandler) SetStatusUnchecked(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	t := ctx.Locals("topic").(model.Topic)
-	if err := h.srv.UncheckTopic(t.ID); err != nil {
+	if err := h.srv.UncheckTopic(t.ID, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("topic opened", nil))
 }
 
 func (h *TopicHandler) LinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	topic := ctx.Locals("topic").(model.Topic)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "linked tag", h.srv.LinkTag(topic.ID, tag.ID))
+	if err := h.srv.LinkTag(topic.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked tag", nil)
 }
 
 func (h *TopicHandler) UnlinkTag(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	topic := ctx.Locals("topic").(model.Topic)
 	tag := ctx.Locals("tag").(model.Tag)
-	return fiberResponseNoVal(ctx, "unlinked tag", h.srv.UnlinkTag(topic.ID, tag.ID))
+	if err := h.srv.UnlinkTag(topic.ID, tag.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked tag", nil)
+}
+
+// ListFiles returns the files attached to the topic
+func (h *TopicHandler) ListFiles(ctx *fiber.Ctx) error {
+	topic := ctx.Locals("topic").(model.Topic)
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("files", topic.Files))
+}
+
+func (h *TopicHandler) LinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	topic := ctx.Locals("topic").(model.Topic)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := h.srv.LinkFile(topic.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "attached file", nil)
+}
+
+func (h *TopicHandler) UnlinkFile(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	topic := ctx.Locals("topic").(model.Topic)
+	f := ctx.Locals("file").(model.ProjectFile)
+	if err := h.srv.UnlinkFile(topic.ID, f.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "detached file", nil)
 }
 
 func (h *TopicHandler) LinkUser(ctx *fiber.Ctx) error {
@@ -187,7 +225,7 @@ func (h *TopicHandler) LinkUser(ctx *fiber.Ctx) error {
 	projectUser := ctx.Locals("project_user").(model.User)
 
 	// create notification for linked user if not self link
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	if u.UserID != projectUser.ID {
 		if err := h.userSrv.CreateNotification(
 			projectUser.ID,
@@ -201,31 +239,38 @@ func (h *TopicHandler) LinkUser(ctx *fiber.Ctx) error {
 		}
 	}
 
-	return fiberResponseNoVal(ctx, "linked user", h.srv.LinkUser(topic.ID, projectUser.ID))
+	if err := h.srv.LinkUser(topic.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "linked user", nil)
 }
 
 func (h *TopicHandler) UnlinkUser(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	topic := ctx.Locals("topic").(model.Topic)
 	projectUser := ctx.Locals("project_user").(model.User)
-	return fiberResponseNoVal(ctx, "unlinked user", h.srv.UnlinkUser(topic.ID, projectUser.ID))
+	if err := h.srv.UnlinkUser(topic.ID, projectUser.ID, u.UserID); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return fiberResponseNoVal(ctx, "unlinked user", nil)
 }
 
 func (h *TopicHandler) IsSubscribed(ctx *fiber.Ctx) error {
 	topic := ctx.Locals("topic").(model.Topic)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	subscribed, err := h.srv.IsSubscribed(topic.ID, u.UserID)
 	return fiberResponse(ctx, "is subscribed", subscribed, err)
 }
 
 func (h *TopicHandler) SubscribeUser(ctx *fiber.Ctx) error {
 	topic := ctx.Locals("topic").(model.Topic)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	return fiberResponseNoVal(ctx, "subscribed user", h.srv.SubscribeUser(topic.ID, u.UserID))
 }
 
 func (h *TopicHandler) UnsubscribeUser(ctx *fiber.Ctx) error {
 	topic := ctx.Locals("topic").(model.Topic)
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	return fiberResponseNoVal(ctx, "unsubscribed user", h.srv.UnsubscribeUser(topic.ID, u.UserID))
 }
 
@@ -244,6 +289,7 @@ const (
 )
 
 func (h *TopicHandler) UpdateOrder(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
 	var payload orderPayload
 	if err := ctx.BodyParser(&payload); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
@@ -265,11 +311,11 @@ func (h *TopicHandler) UpdateOrder(ctx *fiber.Ctx) error {
 			if err != nil {
 				return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 			}
-			if err = h.srv.SetLexoRank(currentFirstTopic.ID, currentNewRank); err != nil {
+			if err = h.srv.SetLexoRank(currentFirstTopic.ID, currentNewRank, u.UserID); err != nil {
 				return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 			}
 		}
-		if err = h.srv.SetLexoRank(t.ID, LexoRankTop); err != nil {
+		if err = h.srv.SetLexoRank(t.ID, LexoRankTop, u.UserID); err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 		}
 		return fiberResponseNoVal(ctx, "order updated (top)", nil)
@@ -290,11 +336,11 @@ func (h *TopicHandler) UpdateOrder(ctx *fiber.Ctx) error {
 			if err != nil {
 				return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 			}
-			if err = h.srv.SetLexoRank(currentLastTopic.ID, currentNewRank); err != nil {
+			if err = h.srv.SetLexoRank(currentLastTopic.ID, currentNewRank, u.UserID); err != nil {
 				return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 			}
 		}
-		if err = h.srv.SetLexoRank(t.ID, LexoRankBottom); err != nil {
+		if err = h.srv.SetLexoRank(t.ID, LexoRankBottom, u.UserID); err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 		}
 		return fiberResponseNoVal(ctx, "order updated (bottom)", nil)
@@ -326,7 +372,7 @@ func (h *TopicHandler) UpdateOrder(ctx *fiber.Ctx) error {
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
-	if err = h.srv.SetLexoRank(t.ID, newRank); err != nil {
+	if err = h.srv.SetLexoRank(t.ID, newRank, u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 
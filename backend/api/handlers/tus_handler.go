@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/api/presenter"
+	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// tusContentType is the required content type of PATCH requests
+	tusContentType = "application/offset+octet-stream"
+)
+
+var (
+	ErrTusVersionNotSupported = errors.New("unsupported tus version")
+	ErrTusInvalidLength       = errors.New("invalid Upload-Length")
+	ErrTusInvalidOffset       = errors.New("invalid Upload-Offset")
+	ErrTusInvalidContentType  = errors.New("content type must be " + tusContentType)
+)
+
+type TusHandler struct {
+	srv        services.TusService
+	projectSrv services.ProjectService
+	processSrv services.FileProcessingService
+	logger     *zap.SugaredLogger
+}
+
+func NewTusHandler(
+	srv services.TusService,
+	projectSrv services.ProjectService,
+	processSrv services.FileProcessingService,
+	logger *zap.SugaredLogger,
+) *TusHandler {
+	return &TusHandler{srv, projectSrv, processSrv, logger}
+}
+
+// TusResumableMiddleware sets the Tus-Resumable header and rejects requests of unsupported protocol versions.
+// OPTIONS requests are used for discovery and don't need to specify a version
+func (h *TusHandler) TusResumableMiddleware(ctx *fiber.Ctx) error {
+	ctx.Set("Tus-Resumable", tusVersion)
+	if ctx.Method() != fiber.MethodOptions && ctx.Get("Tus-Resumable") != tusVersion {
+		ctx.Set("Tus-Version", tusVersion)
+		return ctx.Status(fiber.StatusPreconditionFailed).JSON(presenter.ErrorResponse(ErrTusVersionNotSupported))
+	}
+	return ctx.Next()
+}
+
+// Options returns the supported protocol version and extensions
+func (h *TusHandler) Options(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	ctx.Set("Tus-Version", tusVersion)
+	ctx.Set("Tus-Extension", tusExtensions)
+	if p.MaxProjectFileSize >= 0 {
+		ctx.Set("Tus-Max-Size", strconv.FormatInt(p.MaxProjectFileSize, 10))
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// parseTusMetadata parses the Upload-Metadata header which consists of comma separated
+// key-value pairs where the value is base64 encoded
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// Create creates a new resumable upload. The size of the file must be known in advance
+func (h *TusHandler) Create(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	if !hasPermission(ctx, util.PermissionUploadFiles) {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrForbidden))
+	}
+	length, err := strconv.ParseInt(ctx.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrTusInvalidLength))
+	}
+	rawMetadata := ctx.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(rawMetadata)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	// tus clients use different keys for the file name and type
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	if name == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrNoFileUploaded))
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["type"]
+	}
+	upload := model.ProjectFileTusUpload{
+		Name:        name,
+		ContentType: contentType,
+		Length:      length,
+		Metadata:    rawMetadata,
+		CreatorID:   u.UserID,
+	}
+	// uploads with a file_id are stored as a new version of the file
+	if rawFileID, ok := metadata["file_id"]; ok {
+		fileID, err := strconv.ParseUint(rawFileID, 10, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+		}
+		if _, err = h.projectSrv.FindFile(p.ID, uint(fileID)); err != nil {
+			return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+		}
+		upload.FileID = uint(fileID)
+	}
+	if err = h.srv.CreateUpload(&p, &upload); err != nil {
+		if errors.Is(err, services.ErrFileTooBig) {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(presenter.ErrorResponse(err))
+		}
+		return quotaErrorResponse(ctx, err)
+	}
+	ctx.Set(fiber.HeaderLocation, fmt.Sprintf("%s%s/%d", ctx.BaseURL(), strings.TrimSuffix(ctx.Path(), "/"), upload.ID))
+	ctx.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	return ctx.SendStatus(fiber.StatusCreated)
+}
+
+// TusUploadLocalsMiddleware loads the upload into the "tus_upload" local.
+// Uploads can only be accessed by the user who created them
+func (h *TusHandler) TusUploadLocalsMiddleware(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	p := ctx.Locals("project").(model.Project)
+	uploadID, err := ctx.ParamsInt("upload_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
+	}
+	upload, err := h.srv.FindUpload(uint(uploadID))
+	if err != nil || upload.ProjectID != p.ID {
+		return ctx.Status(fiber.StatusNotFound).JSON(presenter.ErrorResponse(ErrNotFound))
+	}
+	if upload.CreatorID != u.UserID {
+		return ctx.Status(fiber.StatusForbidden).JSON(presenter.ErrorResponse(ErrNoUploadAccess))
+	}
+	if upload.IsExpired() {
+		return ctx.Status(fiber.StatusGone).JSON(presenter.ErrorResponse(services.ErrTusUploadExpired))
+	}
+	ctx.Locals("tus_upload", *upload)
+	return ctx.Next()
+}
+
+// setUploadHeaders sets the headers describing the current state of the upload
+func setUploadHeaders(ctx *fiber.Ctx, upload model.ProjectFileTusUpload) {
+	ctx.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Head returns the current offset of the upload
+func (h *TusHandler) Head(ctx *fiber.Ctx) error {
+	upload := ctx.Locals("tus_upload").(model.ProjectFileTusUpload)
+	setUploadHeaders(ctx, upload)
+	ctx.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		ctx.Set("Upload-Metadata", upload.Metadata)
+	}
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// Patch appends the request body to the upload. If all bytes were received,
+// the file is handed to the storage and added to the project files
+func (h *TusHandler) Patch(ctx *fiber.Ctx) error {
+	p := ctx.Locals("project").(model.Project)
+	upload := ctx.Locals("tus_upload").(model.ProjectFileTusUpload)
+	if ctx.Get(fiber.HeaderContentType) != tusContentType {
+		return ctx.Status(fiber.StatusUnsupportedMediaType).JSON(presenter.ErrorResponse(ErrTusInvalidContentType))
+	}
+	offset, err := strconv.ParseInt(ctx.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(ErrTusInvalidOffset))
+	}
+	var body io.Reader
+	if stream := ctx.Context().RequestBodyStream(); stream != nil {
+		body = stream
+	} else {
+		body = bytes.NewReader(ctx.Body())
+	}
+	_, err = h.srv.WriteChunk(&p, &upload, offset, int64(ctx.Request().Header.ContentLength()), body)
+	setUploadHeaders(ctx, upload)
+	if err != nil && !errors.Is(err, services.ErrTusUploadComplete) {
+		switch {
+		case errors.Is(err, services.ErrTusOffsetMismatch):
+			return ctx.Status(fiber.StatusConflict).JSON(presenter.ErrorResponse(err))
+		case errors.Is(err, services.ErrTusUploadLocked):
+			return ctx.Status(fiber.StatusLocked).JSON(presenter.ErrorResponse(err))
+		case errors.Is(err, services.ErrTusUploadExpired):
+			return ctx.Status(fiber.StatusGone).JSON(presenter.ErrorResponse(err))
+		case errors.Is(err, services.ErrNoFileQuotaLeft), errors.Is(err, services.ErrNoUserQuotaLeft):
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(presenter.ErrorResponse(err))
+		}
+		// the received bytes are kept, so the client can resume the upload after querying the offset
+		h.logger.Warnf("cannot write chunk of tus upload %d: %v", upload.ID, err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	if upload.Offset == upload.Length {
+		// retried if a previous attempt to finish the upload failed
+		file, err := h.srv.FinishUpload(&upload)
+		if errors.Is(err, services.ErrTusUploadFinished) {
+			// the upload was finished by a concurrent request
+			return ctx.SendStatus(fiber.StatusNoContent)
+		}
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+		}
+		processFile(h.processSrv, h.logger, file)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// Delete terminates the upload and deletes all received bytes
+func (h *TusHandler) Delete(ctx *fiber.Ctx) error {
+	upload := ctx.Locals("tus_upload").(model.ProjectFileTusUpload)
+	if err := h.srv.DeleteUpload(&upload); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -4,10 +4,10 @@ import (
 	"errors"
 	"github.com/darmiel/perplex/api/presenter"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"sort"
@@ -20,6 +20,7 @@ type UserHandler struct {
 	meetSrv   services.MeetingService
 	topicSrv  services.TopicService
 	actionSrv services.ActionService
+	quotaSrv  services.QuotaService
 	logger    *zap.SugaredLogger
 	validator *validator.Validate
 }
@@ -32,10 +33,11 @@ func NewUserHandler(
 	meetSrv services.MeetingService,
 	topicSrv services.TopicService,
 	actionSrv services.ActionService,
+	quotaSrv services.QuotaService,
 	logger *zap.SugaredLogger,
 	validator *validator.Validate,
 ) *UserHandler {
-	return &UserHandler{srv, projSrv, meetSrv, topicSrv, actionSrv, logger, validator}
+	return &UserHandler{srv, projSrv, meetSrv, topicSrv, actionSrv, quotaSrv, logger, validator}
 }
 
 type changeNameDto struct {
@@ -43,7 +45,7 @@ type changeNameDto struct {
 }
 
 func (h UserHandler) UpdateName(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	var payload changeNameDto
 	if err := ctx.BodyParser(&payload); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
@@ -80,9 +82,14 @@ func (h UserHandler) List(ctx *fiber.Ctx) error {
 }
 
 func (h UserHandler) UpcomingMeetings(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
-	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("upcoming meetings",
-		h.srv.ListUpcomingMeetings(u.UserID)))
+	u := ctx.Locals("user").(auth.Principal)
+	meetings := make([]*model.Meeting, 0)
+	for _, m := range h.srv.ListUpcomingMeetings(u.UserID) {
+		if u.CanAccessProject(m.ProjectID) {
+			meetings = append(meetings, m)
+		}
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("upcoming meetings", meetings))
 }
 
 func containsFold(haystack, needle string) bool {
@@ -98,7 +105,7 @@ type searchResult struct {
 }
 
 func (h UserHandler) Search(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 
 	query := ctx.Query("query", "")
 	if len(query) < 2 {
@@ -127,6 +134,10 @@ func (h UserHandler) Search(ctx *fiber.Ctx) error {
 	result.TopicProjectID = make(map[uint]uint)
 
 	for _, p := range projectsMap {
+		// skip projects the access token is restricted from
+		if !u.CanAccessProject(p.ID) {
+			continue
+		}
 		if containsFold(p.Name, query) {
 			result.Projects = append(result.Projects, p)
 		}
@@ -185,7 +196,7 @@ func (h UserHandler) Search(ctx *fiber.Ctx) error {
 }
 
 func (h UserHandler) ListUnreadNotifications(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	notifications, err := h.srv.FindNotifications(u.UserID, true)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
@@ -198,7 +209,7 @@ func (h UserHandler) ListUnreadNotifications(ctx *fiber.Ctx) error {
 }
 
 func (h UserHandler) ListAllNotifications(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	notifications, err := h.srv.FindNotifications(u.UserID, false)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
@@ -211,7 +222,7 @@ func (h UserHandler) ListAllNotifications(ctx *fiber.Ctx) error {
 }
 
 func (h UserHandler) MarkNotificationAsRead(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	notificationID, err := ctx.ParamsInt("notification_id")
 	if err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(presenter.ErrorResponse(err))
@@ -223,9 +234,19 @@ func (h UserHandler) MarkNotificationAsRead(ctx *fiber.Ctx) error {
 }
 
 func (h UserHandler) MarkAllNotificationsAsRead(ctx *fiber.Ctx) error {
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	if err := h.srv.MarkAllNotificationsRead(u.UserID); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
 	}
 	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("marked all notifications as read", nil))
 }
+
+// FileQuota returns the file quota of the user across all projects
+func (h UserHandler) FileQuota(ctx *fiber.Ctx) error {
+	u := ctx.Locals("user").(auth.Principal)
+	info, err := h.quotaSrv.UserQuotaInfo(u.UserID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(presenter.ErrorResponse(err))
+	}
+	return ctx.Status(fiber.StatusOK).JSON(presenter.SuccessResponse("file quota", info))
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func AccessTokenRoutes(router fiber.Router, handler *handlers.AccessTokenHandler) {
+	router.Use("/", handler.AccessTokenMiddleware)
+	router.Get("/", handler.ListTokens)
+	router.Post("/", handler.CreateToken)
+	router.Delete("/:token_id", handler.RevokeToken)
+}
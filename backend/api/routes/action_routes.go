@@ -44,6 +44,13 @@ func ActionRoutes(router fiber.Router, handler *handlers.ActionHandler, middlewa
 	specificTag.Post("/", handler.LinkTag)
 	specificTag.Delete("/", handler.UnlinkTag)
 
+	// file attachments
+	specific.Get("/file", handler.ListFiles)
+	specificFile := specific.Group("/file/:file_id")
+	specificFile.Use("/", middlewares.FileLocalsMiddleware)
+	specificFile.Post("/", handler.LinkFile)
+	specificFile.Delete("/", handler.UnlinkFile)
+
 	specific.Post("/close", handler.CloseAction)
 	specific.Post("/open", handler.OpenAction)
 }
@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func AuditRoutes(router fiber.Router, handler *handlers.AuditHandler) {
+	router.Get("/", handler.ListEvents)
+}
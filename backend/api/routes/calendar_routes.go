@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CalendarFeedRoutes serves the calendar feeds. The routes must be registered before the authentication
+func CalendarFeedRoutes(router fiber.Router, handler *handlers.CalendarHandler) {
+	router.Get("/:token", handler.Feed)
+}
+
+// CalendarRoutes manages the calendar feeds of the current user. Feeds grant access to the meetings
+// of all projects of the user, so personal access tokens cannot manage them
+func CalendarRoutes(router fiber.Router, handler *handlers.CalendarHandler, tokens *handlers.AccessTokenHandler) {
+	router.Use("/", tokens.AccessTokenMiddleware)
+	router.Get("/", handler.ListFeeds)
+	router.Post("/", handler.CreateFeed)
+	router.Post("/:feed_id/rotate", handler.RotateFeed)
+	router.Delete("/:feed_id", handler.DeleteFeed)
+}
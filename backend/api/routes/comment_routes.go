@@ -5,12 +5,16 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func CommentRoutes(router fiber.Router, handler *handlers.CommentHandler) {
+func CommentRoutes(router fiber.Router, handler *handlers.CommentHandler, middlewares *handlers.MiddlewareHandler) {
 	solutionGroup := router.Group("/solution/:comment_id")
 	solutionGroup.Use("/", handler.CommentLocalsMiddleware)
 	solutionGroup.Post("/", handler.MarkSolutionComment(true))
 	solutionGroup.Delete("/", handler.MarkSolutionComment(false))
 
+	// attached files can be listed by everyone, but only changed by the author.
+	// registered before the generic routes which would also match this path
+	router.Get("/:comment_id/file", handler.CommentLocalsMiddleware, handler.ListFiles)
+
 	typeGroup := router.Group("/:comment_target_type/:comment_target_id")
 	typeGroup.Get("/", handler.ListGenericComment)
 	typeGroup.Post("/", handler.AddGenericComment)
@@ -20,4 +24,9 @@ func CommentRoutes(router fiber.Router, handler *handlers.CommentHandler) {
 	specificCommentGroup.Use("/", handler.CommentOwnershipMiddleware)
 	specificCommentGroup.Put("/", handler.EditComment)
 	specificCommentGroup.Delete("/", handler.DeleteComment)
+
+	fileGroup := specificCommentGroup.Group("/file/:file_id")
+	fileGroup.Use("/", middlewares.FileLocalsMiddleware)
+	fileGroup.Post("/", handler.LinkFile)
+	fileGroup.Delete("/", handler.UnlinkFile)
 }
@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func DownloadRoutes(router fiber.Router, handler *handlers.DownloadHandler) {
+	router.Get("/*", handler.ServeSignedDownload)
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func FolderRoutes(router fiber.Router, handler *handlers.FolderHandler, middlewares *handlers.MiddlewareHandler) {
+	router.Get("/", handler.ListRoot)
+	router.Post("/", handler.CreateFolder)
+
+	// moving files is registered before the folder routes since "files" would be matched as a folder id
+	router.Put("/files/:file_id", middlewares.FileLocalsMiddleware, handler.MoveFile)
+
+	specific := router.Group("/:folder_id")
+	specific.Use("/", handler.FolderLocalsMiddleware)
+	specific.Get("/", handler.ListContents)
+	specific.Put("/", handler.RenameFolder)
+	specific.Post("/move", handler.MoveFolder)
+	specific.Delete("/", handler.DeleteFolder)
+}
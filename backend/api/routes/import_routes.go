@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ImportRoutes(router fiber.Router, handler *handlers.ImportHandler) {
+	router.Post("/ics", handler.ImportICS)
+}
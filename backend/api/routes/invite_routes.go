@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func InviteRoutes(router fiber.Router, handler *handlers.InviteHandler) {
+	router.Get("/", handler.ListInvites)
+	router.Post("/", handler.CreateInvite)
+
+	specific := router.Group("/:invite_id")
+	specific.Use("/", handler.InviteLocalsMiddleware)
+	specific.Delete("/", handler.RevokeInvite)
+}
+
+// InviteAcceptRoutes are used by users which are not (yet) part of the project
+func InviteAcceptRoutes(router fiber.Router, handler *handlers.InviteHandler) {
+	router.Get("/:token", handler.GetInvite)
+	router.Post("/:token/accept", handler.AcceptInvite)
+}
@@ -19,6 +19,7 @@ func MeetingRoutes(router fiber.Router, handler *handlers.MeetingHandler, middle
 	specific.Delete("/", handler.DeleteMeeting)
 	specific.Put("/", handler.EditMeeting)
 	specific.Put("/ready", handler.EditReady)
+	specific.Post("/conclude", handler.ConcludeMeeting)
 
 	// linkUser routes
 	linkUser := specific.Group("/link/user/:user_id")
@@ -31,4 +32,11 @@ func MeetingRoutes(router fiber.Router, handler *handlers.MeetingHandler, middle
 	tagGroup.Use("/", middlewares.TagLocalsMiddleware)
 	tagGroup.Post("/", handler.LinkTag)
 	tagGroup.Delete("/", handler.UnlinkTag)
+
+	// file attachments
+	specific.Get("/link/file", handler.ListFiles)
+	fileGroup := specific.Group("/link/file/:file_id")
+	fileGroup.Use("/", middlewares.FileLocalsMiddleware)
+	fileGroup.Post("/", handler.LinkFile)
+	fileGroup.Delete("/", handler.UnlinkFile)
 }
@@ -5,29 +5,76 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func ProjectRoutes(router fiber.Router, handler *handlers.ProjectHandler, middlewares *handlers.MiddlewareHandler) {
+func ProjectRoutes(
+	router fiber.Router,
+	handler *handlers.ProjectHandler,
+	tusHandler *handlers.TusHandler,
+	middlewares *handlers.MiddlewareHandler,
+) {
 	router.Post("/", handler.AddProject)
 	router.Get("/", handler.GetProjects)
 
 	specific := router.Group("/:project_id")
 	specific.Use("/", handler.ProjectAccessMiddleware)
+	// leaving a project and answering an ownership transfer is allowed for every role,
+	// so these routes are registered before the write check
+	specific.Delete("/leave", handler.LeaveProject)
+	specific.Post("/transfer/accept", handler.AcceptOwnershipTransfer)
+	specific.Delete("/transfer", handler.CancelOwnershipTransfer)
+	// viewers cannot access mutating routes of the project (including meetings, topics, ...)
+	specific.Use("/", handler.ProjectWriteMiddleware)
 	specific.Get("/", handler.GetProject)
 	specific.Get("/users", handler.ListUsersForProject)
+	specific.Get("/members", handler.ListMembersForProject)
 	specific.Delete("/delete", handler.DeleteProject)
-	specific.Delete("/leave", handler.LeaveProject)
 	specific.Put("/", handler.EditProject)
 
 	specific.Post("/user/:user_id", handler.AddUser)
 	specific.Delete("/user/:user_id", handler.RemoveUser)
+	specific.Put("/user/:user_id/role", handler.ChangeUserRole)
+	specific.Post("/transfer/:user_id", handler.RequestOwnershipTransfer)
 
 	files := specific.Group("/files")
 	files.Post("/", handler.UploadFile)
 	files.Get("/", handler.ListFiles)
 	files.Get("/quota", handler.FileQuotaInfo)
+	files.Put("/quota", handler.EditFileQuota)
+	files.Get("/archive", handler.DownloadArchive)
+
+	// direct uploads to the storage are registered before the file routes
+	// since "upload" would be matched as a file id
+	files.Post("/upload", handler.ReserveUpload)
+	upload := files.Group("/upload/:upload_id")
+	upload.Use("/", handler.UploadLocalsMiddleware)
+	upload.Post("/complete", handler.CompleteUpload)
+	upload.Delete("/", handler.CancelUpload)
+
+	// resumable uploads (tus protocol)
+	tus := files.Group("/tus")
+	tus.Use("/", tusHandler.TusResumableMiddleware)
+	tus.Options("/", tusHandler.Options)
+	tus.Post("/", tusHandler.Create)
+	tusUpload := tus.Group("/:upload_id")
+	tusUpload.Use("/", tusHandler.TusUploadLocalsMiddleware)
+	tusUpload.Head("/", tusHandler.Head)
+	tusUpload.Patch("/", tusHandler.Patch)
+	tusUpload.Delete("/", tusHandler.Delete)
 
 	specificFile := files.Group("/:file_id")
 	specificFile.Use("/", middlewares.FileLocalsMiddleware)
 	specificFile.Get("/", handler.GetFile)
 	specificFile.Delete("/", handler.DeleteFile)
 	specificFile.Get("/download", handler.DownloadFile)
+	specificFile.Get("/content", handler.FileContent)
+	specificFile.Get("/thumbnail", handler.Thumbnail)
+	specificFile.Post("/scan", handler.ScanFile)
+
+	versions := specificFile.Group("/versions")
+	versions.Get("/", handler.ListVersions)
+	versions.Post("/", handler.UploadVersion)
+	specificVersion := versions.Group("/:version")
+	specificVersion.Use("/", handler.FileVersionLocalsMiddleware)
+	specificVersion.Get("/download", handler.DownloadVersion)
+	specificVersion.Get("/content", handler.VersionContent)
+	specificVersion.Post("/restore", handler.RestoreVersion)
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RetentionRoutes(router fiber.Router, handler *handlers.RetentionHandler) {
+	router.Get("/", handler.ListPolicies)
+	router.Post("/", handler.CreatePolicy)
+	// the report is registered before the policy routes since "report" would be matched as a policy id
+	router.Get("/report", handler.Report)
+
+	specific := router.Group("/:policy_id")
+	specific.Use("/", handler.RetentionPolicyLocalsMiddleware)
+	specific.Get("/", handler.FindPolicy)
+	specific.Put("/", handler.EditPolicy)
+	specific.Delete("/", handler.DeletePolicy)
+}
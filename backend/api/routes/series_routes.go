@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SeriesRoutes(router fiber.Router, handler *handlers.SeriesHandler) {
+	router.Get("/", handler.ListSeries)
+	router.Post("/", handler.CreateSeries)
+
+	specific := router.Group("/:series_id")
+	specific.Use("/", handler.SeriesLocalsMiddleware)
+	specific.Get("/", handler.FindSeries)
+	specific.Put("/", handler.EditSeries)
+	specific.Delete("/", handler.DeleteSeries)
+	specific.Get("/occurrences", handler.Occurrences)
+	specific.Post("/materialize", handler.Materialize)
+}
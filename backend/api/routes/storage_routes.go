@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func StorageRoutes(router fiber.Router, handler *handlers.StorageHandler) {
+	router.Get("/*", handler.ServeSignedFile)
+	router.Put("/*", handler.UploadSignedFile)
+}
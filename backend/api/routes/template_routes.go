@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/darmiel/perplex/api/handlers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TemplateRoutes(router fiber.Router, handler *handlers.TemplateHandler) {
+	router.Get("/", handler.ListTemplates)
+	router.Post("/", handler.CreateTemplate)
+
+	specific := router.Group("/:template_id")
+	specific.Use("/", handler.TemplateLocalsMiddleware)
+	specific.Get("/", handler.FindTemplate)
+	specific.Put("/", handler.EditTemplate)
+	specific.Delete("/", handler.DeleteTemplate)
+	specific.Post("/meeting", handler.CreateMeeting)
+}
@@ -34,4 +34,11 @@ func TopicRoutes(router fiber.Router, handler *handlers.TopicHandler, middleware
 	tagGroup.Use("/", middlewares.TagLocalsMiddleware)
 	tagGroup.Post("/", handler.LinkTag)
 	tagGroup.Delete("/", handler.UnlinkTag)
+
+	// file attachments
+	specific.Get("/file", handler.ListFiles)
+	fileGroup := specific.Group("/file/:file_id")
+	fileGroup.Use("/", middlewares.FileLocalsMiddleware)
+	fileGroup.Post("/", handler.LinkFile)
+	fileGroup.Delete("/", handler.UnlinkFile)
 }
@@ -13,6 +13,8 @@ func UserRoutes(router fiber.Router, handler *handlers.UserHandler) {
 	// upcoming meetings
 	router.Get("/me/upcoming-meetings", handler.UpcomingMeetings)
 	router.Get("/me/search", handler.Search)
+	// file quota across all projects
+	router.Get("/me/quota", handler.FileQuota)
 	// notifications
 	router.Get("/me/notification/unread", handler.ListUnreadNotifications)
 	router.Get("/me/notification/all", handler.ListAllNotifications)
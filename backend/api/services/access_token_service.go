@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/darmiel/perplex/pkg/auth"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type AccessTokenService interface {
+	auth.Authenticator
+	CreateToken(userID, name string, expiresAt sql.NullTime, readOnly bool, projectIDs []uint) (*model.AccessToken, string, error)
+	FindTokensByUser(userID string) ([]model.AccessToken, error)
+	DeleteToken(userID string, tokenID uint) error
+}
+
+type accessTokenService struct {
+	DB *gorm.DB
+}
+
+func NewAccessTokenService(db *gorm.DB) AccessTokenService {
+	return &accessTokenService{
+		DB: db,
+	}
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a new access token and returns the token model and the plain token.
+// The plain token is only returned once and cannot be recovered
+func (a *accessTokenService) CreateToken(
+	userID, name string,
+	expiresAt sql.NullTime,
+	readOnly bool,
+	projectIDs []uint,
+) (*model.AccessToken, string, error) {
+	secret, err := randomHexString(40)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := auth.AccessTokenPrefix + secret
+	token := model.AccessToken{
+		Name:      name,
+		UserID:    userID,
+		TokenHash: hashAccessToken(plain),
+		Prefix:    plain[:len(auth.AccessTokenPrefix)+6],
+		ExpiresAt: expiresAt,
+		ReadOnly:  readOnly,
+	}
+	for _, id := range projectIDs {
+		token.Projects = append(token.Projects, model.Project{
+			Model: gorm.Model{
+				ID: id,
+			},
+		})
+	}
+	// don't upsert the (incomplete) projects, only create the associations
+	if err = a.DB.Omit("Projects.*").Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	return &token, plain, nil
+}
+
+func (a *accessTokenService) FindTokensByUser(userID string) ([]model.AccessToken, error) {
+	var tokens []model.AccessToken
+	if err := a.DB.Preload("Projects").
+		Where(&model.AccessToken{
+			UserID: userID,
+		}).
+		Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (a *accessTokenService) DeleteToken(userID string, tokenID uint) error {
+	res := a.DB.Where("user_id = ?", userID).Delete(&model.AccessToken{
+		Model: gorm.Model{
+			ID: tokenID,
+		},
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected <= 0 {
+		return ErrNotMatches
+	}
+	return nil
+}
+
+// Authenticate verifies a personal access token and records the time of usage
+func (a *accessTokenService) Authenticate(_ context.Context, plain string) (*auth.Principal, error) {
+	var token model.AccessToken
+	if err := a.DB.Where("token_hash = ?", hashAccessToken(plain)).
+		First(&token).Error; err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	if token.ExpiresAt.Valid && token.ExpiresAt.Time.Before(time.Now()) {
+		return nil, auth.ErrInvalidToken
+	}
+	projectIDs, err := a.restrictedProjectIDs(token.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.DB.Model(&token).
+		UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return &auth.Principal{
+		UserID:        token.UserID,
+		AccessTokenID: token.ID,
+		ReadOnly:      token.ReadOnly,
+		ProjectIDs:    projectIDs,
+	}, nil
+}
+
+// restrictedProjectIDs returns the IDs of the projects the token is restricted to (nil = no restriction).
+// The restrictions are read from the join table, so projects which were deleted in the meantime
+// don't turn a restricted token into an unrestricted one
+func (a *accessTokenService) restrictedProjectIDs(tokenID uint) ([]uint, error) {
+	var restricted []uint
+	if err := a.DB.Table("access_token_projects").
+		Where("access_token_id = ?", tokenID).
+		Pluck("project_id", &restricted).Error; err != nil {
+		return nil, err
+	}
+	if len(restricted) == 0 {
+		return nil, nil
+	}
+	var valid []uint
+	if err := a.DB.Model(&model.Project{}).
+		Where("id IN ?", restricted).
+		Pluck("id", &valid).Error; err != nil {
+		return nil, err
+	}
+	// all projects of the token were deleted
+	if len(valid) == 0 {
+		return nil, auth.ErrInvalidToken
+	}
+	return valid, nil
+}
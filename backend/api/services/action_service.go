@@ -14,17 +14,20 @@ type ActionService interface {
 	FindActionsByTag(tagID uint) ([]model.Action, error)
 	FindActionsByPriority(priorityID uint) ([]model.Action, error)
 	FindActionsByProjectAndUser(projectID uint, userID string, openOnly bool) ([]model.Action, error)
+	// CreateAction creates the action. The mutations of actions are audited as performed by the actor
 	CreateAction(title, description string, dueDate sql.NullTime, priorityID, projectID uint, creatorID string) (*model.Action, error)
-	DeleteAction(actionID uint) error
-	EditAction(actionID uint, title, description string, dueDate sql.NullTime, priorityID uint) error
-	LinkTopic(actionID, topicID uint) error
-	UnlinkTopic(actionID, topicID uint) error
-	LinkUser(actionID uint, userID string) error
-	UnlinkUser(actionID uint, userID string) error
-	LinkTag(actionID, tagID uint) error
-	UnlinkTag(actionID, tagID uint) error
-	CloseAction(actionID uint) error
-	OpenAction(actionID uint) error
+	DeleteAction(actionID uint, actorID string) error
+	EditAction(actionID uint, title, description string, dueDate sql.NullTime, priorityID uint, actorID string) error
+	LinkTopic(actionID, topicID uint, actorID string) error
+	UnlinkTopic(actionID, topicID uint, actorID string) error
+	LinkUser(actionID uint, userID, actorID string) error
+	UnlinkUser(actionID uint, userID, actorID string) error
+	LinkTag(actionID, tagID uint, actorID string) error
+	UnlinkTag(actionID, tagID uint, actorID string) error
+	LinkFile(actionID, fileID uint, actorID string) error
+	UnlinkFile(actionID, fileID uint, actorID string) error
+	CloseAction(actionID uint, actorID string) error
+	OpenAction(actionID uint, actorID string) error
 }
 
 type actionService struct {
@@ -43,7 +46,8 @@ func (a *actionService) preload() *gorm.DB {
 	return a.DB.Preload("Topics").
 		Preload("AssignedUsers").
 		Preload("Priority").
-		Preload("Tags")
+		Preload("Tags").
+		Preload("Files")
 }
 
 func (a *actionService) FindAction(id uint) (*model.Action, error) {
@@ -129,21 +133,37 @@ func (a *actionService) CreateAction(title, description string, dueDate sql.Null
 		ProjectID:   projectID,
 		CreatorID:   creatorID,
 	}
-	if err := a.DB.Create(&action).Error; err != nil {
+	if err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&action).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, creatorID, model.AuditEntityAction, action.ID, model.AuditActionCreate, nil, &action)
+	}); err != nil {
 		return nil, err
 	}
 	return &action, nil
 }
 
-func (a *actionService) DeleteAction(id uint) error {
-	return a.DB.Delete(&model.Action{
-		Model: gorm.Model{
-			ID: id,
-		},
-	}).Error
+func (a *actionService) DeleteAction(id uint, actorID string) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		var action model.Action
+		if err := tx.First(&action, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&action).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityAction, id, model.AuditActionDelete, &action, nil)
+	})
 }
 
-func (a *actionService) EditAction(id uint, title, description string, dueDate sql.NullTime, priorityID uint) error {
+func (a *actionService) EditAction(
+	id uint,
+	title, description string,
+	dueDate sql.NullTime,
+	priorityID uint,
+	actorID string,
+) error {
 	// check if priority exists
 	var priorityIDUpdate interface{} = nil
 	if priorityID != 0 {
@@ -156,112 +176,83 @@ func (a *actionService) EditAction(id uint, title, description string, dueDate s
 	if dueDate.Valid {
 		dueDateUpdate = dueDate
 	}
-	return a.DB.Updates(&model.Action{
-		Model: gorm.Model{
-			ID: id,
-		},
-		Title:       title,
-		Description: description,
-	}).
-		Update("PriorityID", priorityIDUpdate).
-		Update("DueDate", dueDateUpdate).
-		Error
+	return auditChanges[model.Action](a.DB, actorID, model.AuditEntityAction, id, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Updates(&model.Action{
+				Model: gorm.Model{
+					ID: id,
+				},
+				Title:       title,
+				Description: description,
+			}).
+				Update("PriorityID", priorityIDUpdate).
+				Update("DueDate", dueDateUpdate).
+				Error
+		})
 }
 
-func (a *actionService) LinkTopic(actionID, topicID uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).Association("Topics").Append(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-	})
+func (a *actionService) LinkTopic(actionID, topicID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionLink,
+		"Topics", &model.Topic{Model: gorm.Model{ID: topicID}}, "topic_id", topicID)
 }
 
-func (a *actionService) UnlinkTopic(actionID, topicID uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).
-		Association("Topics").
-		Delete(&model.Topic{
-			Model: gorm.Model{
-				ID: topicID,
-			},
-		})
+func (a *actionService) UnlinkTopic(actionID, topicID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionUnlink,
+		"Topics", &model.Topic{Model: gorm.Model{ID: topicID}}, "topic_id", topicID)
 }
 
-func (a *actionService) LinkUser(actionID uint, userID string) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).
-		Association("AssignedUsers").
-		Append(&model.User{
-			ID: userID,
-		})
+func (a *actionService) LinkUser(actionID uint, userID, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionLink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
 }
 
-func (a *actionService) UnlinkUser(actionID uint, userID string) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).
-		Association("AssignedUsers").
-		Delete(&model.User{
-			ID: userID,
-		})
+func (a *actionService) UnlinkUser(actionID uint, userID, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionUnlink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
 }
 
-func (a *actionService) LinkTag(actionID, tagID uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).
-		Association("Tags").
-		Append(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
-		})
+func (a *actionService) LinkTag(actionID, tagID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionLink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
 }
 
-func (a *actionService) UnlinkTag(actionID, tagID uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: actionID,
-		},
-	}).
-		Association("Tags").
-		Delete(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
-		})
+func (a *actionService) UnlinkTag(actionID, tagID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionUnlink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
 }
 
-func (a *actionService) CloseAction(id uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: id,
-		},
-	}).
-		Update("ClosedAt", time.Now()).
-		Error
+func (a *actionService) LinkFile(actionID, fileID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionLink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
 }
 
-func (a *actionService) OpenAction(id uint) error {
-	return a.DB.Model(&model.Action{
-		Model: gorm.Model{
-			ID: id,
-		},
-	}).
-		Update("ClosedAt", nil).
-		Error
+func (a *actionService) UnlinkFile(actionID, fileID uint, actorID string) error {
+	return auditLink[model.Action](a.DB, actorID, model.AuditEntityAction, actionID, model.AuditActionUnlink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
+}
+
+func (a *actionService) CloseAction(id uint, actorID string) error {
+	return auditChanges[model.Action](a.DB, actorID, model.AuditEntityAction, id, model.AuditActionClose,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Action{
+				Model: gorm.Model{
+					ID: id,
+				},
+			}).
+				Update("ClosedAt", time.Now()).
+				Error
+		})
+}
+
+func (a *actionService) OpenAction(id uint, actorID string) error {
+	return auditChanges[model.Action](a.DB, actorID, model.AuditEntityAction, id, model.AuditActionOpen,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Action{
+				Model: gorm.Model{
+					ID: id,
+				},
+			}).
+				Update("ClosedAt", nil).
+				Error
+		})
 }
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"reflect"
+	"time"
+)
+
+const auditPageSize = 50
+
+// AuditFilter restricts the audit events returned by FindEvents. Empty fields are ignored
+type AuditFilter struct {
+	ActorID    string
+	UserID     string
+	EntityType model.AuditEntityType
+	EntityID   uint
+	Action     model.AuditAction
+	Since      time.Time
+	Until      time.Time
+}
+
+type AuditService interface {
+	// FindEvents returns a page of the audit events of the project.
+	// Services record the mutations they perform within the transaction of the mutation
+	FindEvents(projectID uint, filter AuditFilter, page int) ([]model.AuditEvent, int64, error)
+	PageSize() int
+}
+
+type auditService struct {
+	DB *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{
+		DB: db,
+	}
+}
+
+// auditIgnoredFields are changed by every mutation and therefore not included in the diff
+var auditIgnoredFields = map[string]bool{
+	"UpdatedAt":  true,
+	"updated_at": true,
+}
+
+// auditFields converts an entity to a map of its JSON fields.
+// Lists and nested entities (associations) are skipped since they are audited separately
+func auditFields(entity any) (map[string]any, error) {
+	res := make(map[string]any)
+	if entity == nil {
+		return res, nil
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if auditIgnoredFields[k] {
+			continue
+		}
+		switch t := v.(type) {
+		case []any:
+			continue
+		case map[string]any:
+			if _, ok := t["ID"]; ok {
+				continue
+			}
+			if _, ok := t["id"]; ok {
+				continue
+			}
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+// diff returns all fields which differ between before and after
+func diff(before, after any) (model.AuditChanges, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(model.AuditChanges)
+	for k, b := range beforeFields {
+		a := afterFields[k]
+		if !reflect.DeepEqual(b, a) {
+			changes[k] = model.AuditChange{Before: b, After: a}
+		}
+	}
+	for k, a := range afterFields {
+		if _, ok := beforeFields[k]; !ok {
+			changes[k] = model.AuditChange{After: a}
+		}
+	}
+	return changes, nil
+}
+
+// recordAudit stores a mutation of an entity in the audit log using the transaction of the mutation,
+// so the mutation is rolled back if it cannot be audited
+func recordAudit(tx *gorm.DB, event model.AuditEvent, before, after any) error {
+	changes, err := diff(before, after)
+	if err != nil {
+		return err
+	}
+	event.Changes = changes
+	return tx.Create(&event).Error
+}
+
+// pluckProjectID returns the project of the (possibly deleted) entity of the model with the given ID
+func pluckProjectID(tx *gorm.DB, entity any, id uint) (uint, error) {
+	var ids []uint
+	if err := tx.Model(entity).
+		Unscoped().
+		Where("id = ?", id).
+		Pluck("project_id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return ids[0], nil
+}
+
+// auditProjectID returns the ID of the project an audited entity belongs to
+func auditProjectID(tx *gorm.DB, entity any) (uint, error) {
+	switch e := entity.(type) {
+	case *model.Project:
+		return e.ID, nil
+	case *model.Topic:
+		return pluckProjectID(tx, &model.Meeting{}, e.MeetingID)
+	case *model.Comment:
+		switch {
+		case e.ProjectID != nil:
+			return *e.ProjectID, nil
+		case e.TopicID != nil:
+			var topic model.Topic
+			if err := tx.Unscoped().Select("meeting_id").First(&topic, *e.TopicID).Error; err != nil {
+				return 0, err
+			}
+			return pluckProjectID(tx, &model.Meeting{}, topic.MeetingID)
+		case e.MeetingID != nil:
+			return pluckProjectID(tx, &model.Meeting{}, *e.MeetingID)
+		case e.ActionID != nil:
+			return pluckProjectID(tx, &model.Action{}, *e.ActionID)
+		case e.ProjectFileID != nil:
+			return pluckProjectID(tx, &model.ProjectFile{}, *e.ProjectFileID)
+		}
+		return 0, ErrCommentInvalid
+	case *model.Meeting:
+		return e.ProjectID, nil
+	case *model.Action:
+		return e.ProjectID, nil
+	case *model.Tag:
+		return e.ProjectID, nil
+	case *model.Priority:
+		return e.ProjectID, nil
+	case *model.ProjectFile:
+		return e.ProjectID, nil
+	case *model.ProjectFolder:
+		return e.ProjectID, nil
+	case *model.ProjectInvite:
+		return e.ProjectID, nil
+	case *model.MeetingSeries:
+		return e.ProjectID, nil
+	case *model.MeetingTemplate:
+		return e.ProjectID, nil
+	case *model.RetentionPolicy:
+		return e.ProjectID, nil
+	}
+	return 0, fmt.Errorf("cannot audit entity of type %T", entity)
+}
+
+// auditEntity records the mutation of an entity performed by the actor using the transaction of the mutation.
+// before is nil for created and after is nil for deleted entities
+func auditEntity(
+	tx *gorm.DB,
+	actorID string,
+	entityType model.AuditEntityType,
+	entityID uint,
+	action model.AuditAction,
+	before, after any,
+) error {
+	entity := after
+	if entity == nil {
+		entity = before
+	}
+	projectID, err := auditProjectID(tx, entity)
+	if err != nil {
+		return err
+	}
+	return recordAudit(tx, model.AuditEvent{
+		ProjectID:  projectID,
+		ActorID:    actorID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+	}, before, after)
+}
+
+// auditChanges runs fn in a transaction and records the changes fn made to the entity as performed
+// by the actor. The entity is read within the transaction before and after fn
+func auditChanges[T any](
+	db *gorm.DB,
+	actorID string,
+	entityType model.AuditEntityType,
+	entityID uint,
+	action model.AuditAction,
+	fn func(tx *gorm.DB) error,
+) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		before, after := new(T), new(T)
+		if err := tx.First(before, entityID).Error; err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := tx.First(after, entityID).Error; err != nil {
+			return err
+		}
+		projectID, err := auditProjectID(tx, before)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, model.AuditEvent{
+			ProjectID:  projectID,
+			ActorID:    actorID,
+			EntityType: entityType,
+			EntityID:   entityID,
+			Action:     action,
+		}, before, after)
+	})
+}
+
+// auditLink appends the target to the association of the entity (or deletes it if the action is
+// AuditActionUnlink) in a transaction and records the change as performed by the actor.
+// key and value identify the target in the audit log, e.g. "tag_id" and the ID of the tag
+func auditLink[T any](
+	db *gorm.DB,
+	actorID string,
+	entityType model.AuditEntityType,
+	entityID uint,
+	action model.AuditAction,
+	association string,
+	target any,
+	key string,
+	value any,
+) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		entity := new(T)
+		if err := tx.First(entity, entityID).Error; err != nil {
+			return err
+		}
+		link := map[string]any{key: value}
+		var before, after any
+		if action == model.AuditActionUnlink {
+			if err := tx.Model(entity).Association(association).Delete(target); err != nil {
+				return err
+			}
+			before = link
+		} else {
+			if err := tx.Model(entity).Association(association).Append(target); err != nil {
+				return err
+			}
+			after = link
+		}
+		projectID, err := auditProjectID(tx, entity)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, model.AuditEvent{
+			ProjectID:  projectID,
+			ActorID:    actorID,
+			EntityType: entityType,
+			EntityID:   entityID,
+			Action:     action,
+		}, before, after)
+	})
+}
+
+func (a *auditService) FindEvents(projectID uint, filter AuditFilter, page int) ([]model.AuditEvent, int64, error) {
+	query := a.DB.Model(&model.AuditEvent{}).Where(&model.AuditEvent{
+		ProjectID:  projectID,
+		ActorID:    filter.ActorID,
+		UserID:     filter.UserID,
+		EntityType: filter.EntityType,
+		EntityID:   filter.EntityID,
+		Action:     filter.Action,
+	})
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at <= ?", filter.Until)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var events []model.AuditEvent
+	if err := query.Order("created_at DESC, id DESC").
+		Offset((page - 1) * auditPageSize).
+		Limit(auditPageSize).
+		Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (a *auditService) PageSize() int {
+	return auditPageSize
+}
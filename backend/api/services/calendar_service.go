@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/ical"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	// CalendarFeedTokenPrefix is the prefix of the tokens of calendar feeds
+	CalendarFeedTokenPrefix = "pcal_"
+	// calendarFeedHistory is the time span of past meetings which are included in calendar feeds
+	calendarFeedHistory = 90 * 24 * time.Hour
+)
+
+var ErrCalendarFeedNotFound = errors.New("calendar feed not found")
+
+// CalendarService manages the iCalendar feeds of users
+type CalendarService interface {
+	// CreateFeed creates a new feed and returns the feed model and the plain token.
+	// The plain token is only returned once and cannot be recovered
+	CreateFeed(userID, name string, projectID *uint, includeActions bool) (*model.CalendarFeed, string, error)
+	FindFeedsByUser(userID string) ([]model.CalendarFeed, error)
+	// RotateFeed replaces the token of the feed, so the previous feed URL no longer works
+	RotateFeed(userID string, feedID uint) (*model.CalendarFeed, string, error)
+	DeleteFeed(userID string, feedID uint) error
+	// Calendar returns the calendar of the feed with the plain token
+	Calendar(token string) (*ical.Calendar, error)
+}
+
+type calendarService struct {
+	DB        *gorm.DB
+	projSrv   ProjectService
+	seriesSrv SeriesService
+	// baseURL is the URL of the frontend which is used to link meetings and actions (optional)
+	baseURL string
+}
+
+func NewCalendarService(db *gorm.DB, projSrv ProjectService, seriesSrv SeriesService, baseURL string) CalendarService {
+	return &calendarService{
+		DB:        db,
+		projSrv:   projSrv,
+		seriesSrv: seriesSrv,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// newFeedToken returns a new plain feed token and its hash
+func newFeedToken() (string, string, error) {
+	secret, err := randomHexString(40)
+	if err != nil {
+		return "", "", err
+	}
+	plain := CalendarFeedTokenPrefix + secret
+	return plain, hashAccessToken(plain), nil
+}
+
+func (c *calendarService) CreateFeed(
+	userID, name string,
+	projectID *uint,
+	includeActions bool,
+) (*model.CalendarFeed, string, error) {
+	plain, hash, err := newFeedToken()
+	if err != nil {
+		return nil, "", err
+	}
+	feed := model.CalendarFeed{
+		Name:           name,
+		UserID:         userID,
+		ProjectID:      projectID,
+		IncludeActions: includeActions,
+		TokenHash:      hash,
+		Prefix:         plain[:len(CalendarFeedTokenPrefix)+6],
+	}
+	if err = c.DB.Create(&feed).Error; err != nil {
+		return nil, "", err
+	}
+	return &feed, plain, nil
+}
+
+func (c *calendarService) FindFeedsByUser(userID string) (res []model.CalendarFeed, err error) {
+	err = c.DB.Where("user_id = ?", userID).Find(&res).Error
+	return
+}
+
+func (c *calendarService) findFeed(userID string, feedID uint) (*model.CalendarFeed, error) {
+	var feeds []model.CalendarFeed
+	if err := c.DB.Where("id = ? AND user_id = ?", feedID, userID).
+		Limit(1).
+		Find(&feeds).Error; err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 {
+		return nil, ErrCalendarFeedNotFound
+	}
+	return &feeds[0], nil
+}
+
+func (c *calendarService) RotateFeed(userID string, feedID uint) (*model.CalendarFeed, string, error) {
+	feed, err := c.findFeed(userID, feedID)
+	if err != nil {
+		return nil, "", err
+	}
+	plain, hash, err := newFeedToken()
+	if err != nil {
+		return nil, "", err
+	}
+	feed.TokenHash = hash
+	feed.Prefix = plain[:len(CalendarFeedTokenPrefix)+6]
+	if err = c.DB.Model(feed).Updates(map[string]any{
+		"token_hash": feed.TokenHash,
+		"prefix":     feed.Prefix,
+	}).Error; err != nil {
+		return nil, "", err
+	}
+	return feed, plain, nil
+}
+
+func (c *calendarService) DeleteFeed(userID string, feedID uint) error {
+	res := c.DB.Where("id = ? AND user_id = ?", feedID, userID).Delete(&model.CalendarFeed{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected <= 0 {
+		return ErrCalendarFeedNotFound
+	}
+	return nil
+}
+
+// feedProjects returns the projects of the feed the user (still) has access to
+func (c *calendarService) feedProjects(feed *model.CalendarFeed) ([]model.Project, error) {
+	if feed.ProjectID != nil {
+		project, err := c.projSrv.FindProject(*feed.ProjectID, "Members")
+		if err != nil || !util.Can(project, feed.UserID, util.PermissionRead) {
+			return nil, ErrCalendarFeedNotFound
+		}
+		return []model.Project{*project}, nil
+	}
+	owned, err := c.projSrv.FindProjectsByOwner(feed.UserID)
+	if err != nil {
+		return nil, err
+	}
+	member, err := c.projSrv.FindProjectsByUserAccess(feed.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return append(owned, member...), nil
+}
+
+// link returns the URL of the path in the frontend (empty if no frontend URL is configured)
+func (c *calendarService) link(path string) string {
+	if c.baseURL == "" {
+		return ""
+	}
+	return c.baseURL + path
+}
+
+func attendeesOf(users []model.User) []ical.Attendee {
+	res := make([]ical.Attendee, len(users))
+	for i, u := range users {
+		res[i] = ical.Attendee{
+			Name:  u.UserName,
+			Email: u.Email,
+		}
+	}
+	return res
+}
+
+func (c *calendarService) Calendar(token string) (*ical.Calendar, error) {
+	var feeds []model.CalendarFeed
+	if err := c.DB.Where("token_hash = ?", hashAccessToken(token)).
+		Limit(1).
+		Find(&feeds).Error; err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 {
+		return nil, ErrCalendarFeedNotFound
+	}
+	feed := &feeds[0]
+	if err := c.DB.Model(feed).
+		UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	projects, err := c.feedProjects(feed)
+	if err != nil {
+		return nil, err
+	}
+	cal := &ical.Calendar{
+		ProdID: "-//perplex//calendar feed//EN",
+		Name:   "perplex",
+	}
+	if feed.ProjectID != nil {
+		cal.Name = "perplex: " + projects[0].Name
+	}
+	if len(projects) == 0 {
+		return cal, nil
+	}
+	projectIDs := make([]uint, len(projects))
+	for i, p := range projects {
+		projectIDs[i] = p.ID
+		// create the upcoming occurrences of recurring meetings
+		if err = c.seriesSrv.Materialize(p.ID, time.Now().Add(SeriesHorizon)); err != nil {
+			return nil, err
+		}
+	}
+
+	var meetings []model.Meeting
+	if err = c.DB.Preload("AssignedUsers").
+		Where("project_id IN ? AND start_date > ?", projectIDs, time.Now().Add(-calendarFeedHistory)).
+		Order("start_date").
+		Find(&meetings).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range meetings {
+		cal.Events = append(cal.Events, ical.Event{
+			UID:          fmt.Sprintf("meeting-%d@perplex", m.ID),
+			Summary:      m.Name,
+			Description:  m.Description,
+			Start:        m.StartDate,
+			End:          m.EndDate,
+			URL:          c.link(fmt.Sprintf("/project/%d/meeting/%d", m.ProjectID, m.ID)),
+			Attendees:    attendeesOf(m.AssignedUsers),
+			Created:      m.CreatedAt,
+			LastModified: m.UpdatedAt,
+		})
+	}
+
+	if !feed.IncludeActions {
+		return cal, nil
+	}
+	query := c.DB.Preload("AssignedUsers").
+		Where("project_id IN ? AND due_date IS NOT NULL", projectIDs)
+	// the personal feed only contains the actions assigned to the user
+	if feed.ProjectID == nil {
+		query = query.Where("id IN (?)", c.DB.Table("action_user_assignments").
+			Select("action_id").
+			Where("user_id = ?", feed.UserID))
+	}
+	var actions []model.Action
+	if err = query.Order("due_date").Find(&actions).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		if !a.DueDate.Valid {
+			continue
+		}
+		todo := ical.Todo{
+			UID:          fmt.Sprintf("action-%d@perplex", a.ID),
+			Summary:      a.Title,
+			Description:  a.Description,
+			Due:          a.DueDate.Time,
+			URL:          c.link(fmt.Sprintf("/project/%d/action/%d", a.ProjectID, a.ID)),
+			Attendees:    attendeesOf(a.AssignedUsers),
+			Created:      a.CreatedAt,
+			LastModified: a.UpdatedAt,
+		}
+		if a.ClosedAt.Valid {
+			todo.Completed = a.ClosedAt.Time
+		}
+		cal.Todos = append(cal.Todos, todo)
+	}
+	return cal, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultClamdTimeout is the default time a scan of a single file may take
+	defaultClamdTimeout = 2 * time.Minute
+	// clamdChunkSize is the size of the chunks streamed to clamd
+	clamdChunkSize = 64 * 1024
+)
+
+var ErrInvalidClamdAddress = errors.New("invalid clamd address (use tcp://host:port or unix:///path)")
+
+// clamdScanner scans content using the INSTREAM command of a ClamAV daemon
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd listening on address,
+// e.g. "tcp://localhost:3310" or "unix:///var/run/clamav/clamd.ctl"
+func NewClamdScanner(address string, timeout time.Duration) (Scanner, error) {
+	if addr, ok := strings.CutPrefix(address, "tcp://"); ok {
+		return &clamdScanner{network: "tcp", address: addr, timeout: timeout}, nil
+	}
+	if addr, ok := strings.CutPrefix(address, "unix://"); ok {
+		return &clamdScanner{network: "unix", address: addr, timeout: timeout}, nil
+	}
+	return nil, ErrInvalidClamdAddress
+}
+
+func (c *clamdScanner) Scan(body io.Reader) (string, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	// commands prefixed with "z" are terminated by a null byte
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	// the content is sent in chunks, each prefixed with its length (4 bytes, network byte order).
+	// A chunk with length 0 marks the end of the stream
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err = conn.Write(buf[:4+n]); err != nil {
+				// clamd closes the connection if the stream exceeds its size limit,
+				// the reason is sent as response
+				break
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	if err == nil {
+		if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+			return "", err
+		}
+	}
+	response, readErr := bufio.NewReader(conn).ReadString(0)
+	response = strings.TrimRight(response, "\x00\n")
+	if response == "" {
+		if err != nil {
+			return "", err
+		}
+		return "", readErr
+	}
+	return parseClamdResponse(response)
+}
+
+// parseClamdResponse parses the response to an INSTREAM command:
+// "stream: OK", "stream: <signature> FOUND" or "<reason> ERROR"
+func parseClamdResponse(response string) (string, error) {
+	result := strings.TrimPrefix(response, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", response)
+}
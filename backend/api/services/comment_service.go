@@ -10,13 +10,16 @@ var ErrCommentIsSolution = errors.New("cannot delete solution")
 var ErrCommentInvalid = errors.New("invalid comment")
 
 type CommentService interface {
+	// AddComment creates the comment. The mutations of comments are audited as performed by the actor
 	AddComment(authorID string, content string, extend func(comment *model.Comment)) (*model.Comment, error)
 	GetComment(commentID uint) (*model.Comment, error)
 	FindComments(query func(comment *model.Comment)) ([]*model.Comment, error)
-	EditComment(commentID uint, newContent string) error
-	DeleteComment(commentID uint) error
-	MarkCommentSolution(commentID uint) error
-	UnmarkCommentSolution(commentID uint) error
+	EditComment(commentID uint, newContent, actorID string) error
+	DeleteComment(commentID uint, actorID string) error
+	MarkCommentSolution(commentID uint, actorID string) error
+	UnmarkCommentSolution(commentID uint, actorID string) error
+	LinkFile(commentID, fileID uint, actorID string) error
+	UnlinkFile(commentID, fileID uint, actorID string) error
 }
 
 type commentService struct {
@@ -37,12 +40,17 @@ func (c *commentService) AddComment(authorID string, content string, extend func
 		Content:  content,
 	}
 	extend(res)
-	err = c.DB.Create(res).Error
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(res).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, authorID, model.AuditEntityComment, res.ID, model.AuditActionCreate, nil, res)
+	})
 	return
 }
 
 func (c *commentService) GetComment(commentID uint) (res *model.Comment, err error) {
-	err = c.DB.First(&res, &model.Comment{
+	err = c.DB.Preload("Files").First(&res, &model.Comment{
 		Model: gorm.Model{
 			ID: commentID,
 		},
@@ -53,20 +61,23 @@ func (c *commentService) GetComment(commentID uint) (res *model.Comment, err err
 func (c *commentService) FindComments(query func(comment *model.Comment)) (res []*model.Comment, err error) {
 	q := new(model.Comment)
 	query(q)
-	err = c.DB.Find(&res, q).Error
+	err = c.DB.Preload("Files").Find(&res, q).Error
 	return
 }
 
-func (c *commentService) EditComment(commentID uint, newContent string) error {
-	return c.DB.Updates(&model.Comment{
-		Model: gorm.Model{
-			ID: commentID,
-		},
-		Content: newContent,
-	}).Error
+func (c *commentService) EditComment(commentID uint, newContent, actorID string) error {
+	return auditChanges[model.Comment](c.DB, actorID, model.AuditEntityComment, commentID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Updates(&model.Comment{
+				Model: gorm.Model{
+					ID: commentID,
+				},
+				Content: newContent,
+			}).Error
+		})
 }
 
-func (c *commentService) DeleteComment(commentID uint) error {
+func (c *commentService) DeleteComment(commentID uint, actorID string) error {
 	// check if comment is solution
 	var comment model.Comment
 	if err := c.DB.First(&comment, &model.Comment{
@@ -87,14 +98,15 @@ func (c *commentService) DeleteComment(commentID uint) error {
 			return ErrCommentIsSolution
 		}
 	}
-	return c.DB.Delete(&model.Comment{
-		Model: gorm.Model{
-			ID: commentID,
-		},
-	}).Error
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&comment).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityComment, commentID, model.AuditActionDelete, &comment, nil)
+	})
 }
 
-func (c *commentService) toggleCommentSolution(commentID uint, status bool) error {
+func (c *commentService) toggleCommentSolution(commentID uint, status bool, actorID string) error {
 	// find comment
 	comment, err := c.GetComment(commentID)
 	if err != nil {
@@ -112,13 +124,23 @@ func (c *commentService) toggleCommentSolution(commentID uint, status bool) erro
 	if !status {
 		newSolutionComment = 0 // no solution comment
 	}
-	return c.topicService.SetSolution(topic.ID, newSolutionComment)
+	return c.topicService.SetSolution(topic.ID, newSolutionComment, actorID)
+}
+
+func (c *commentService) MarkCommentSolution(commentID uint, actorID string) error {
+	return c.toggleCommentSolution(commentID, true, actorID)
+}
+
+func (c *commentService) UnmarkCommentSolution(commentID uint, actorID string) error {
+	return c.toggleCommentSolution(commentID, false, actorID)
 }
 
-func (c *commentService) MarkCommentSolution(commentID uint) error {
-	return c.toggleCommentSolution(commentID, true)
+func (c *commentService) LinkFile(commentID, fileID uint, actorID string) error {
+	return auditLink[model.Comment](c.DB, actorID, model.AuditEntityComment, commentID, model.AuditActionLink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
 }
 
-func (c *commentService) UnmarkCommentSolution(commentID uint) error {
-	return c.toggleCommentSolution(commentID, false)
+func (c *commentService) UnlinkFile(commentID, fileID uint, actorID string) error {
+	return auditLink[model.Comment](c.DB, actorID, model.AuditEntityComment, commentID, model.AuditActionUnlink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
 }
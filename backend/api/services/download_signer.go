@@ -0,0 +1,52 @@
+package services
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DownloadSigner signs URLs of downloads which are streamed through the API (STORAGE_PROXY_DOWNLOADS).
+// Like presigned URLs of the storage, signed download URLs can be opened without authentication
+type DownloadSigner interface {
+	// Sign returns the query of a signed URL which downloads the object with the file name and content type
+	Sign(key, name, contentType string, expires time.Duration) url.Values
+	// Verify checks if the signature of a download URL is valid and not expired
+	Verify(key, name, contentType, expires, signature string) error
+}
+
+type downloadSigner struct {
+	secret []byte
+}
+
+// NewDownloadSigner creates a signer which signs download URLs with STORAGE_PROXY_SECRET.
+// If no secret is specified, a random secret is generated which invalidates all signed URLs on restart
+func NewDownloadSigner() (DownloadSigner, error) {
+	var secret []byte
+	if s, ok := os.LookupEnv("STORAGE_PROXY_SECRET"); ok {
+		secret = []byte(s)
+	} else {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &downloadSigner{secret}, nil
+}
+
+func (d *downloadSigner) Sign(key, name, contentType string, expires time.Duration) url.Values {
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	return url.Values{
+		"name":      {name},
+		"type":      {contentType},
+		"expires":   {expiresAt},
+		"signature": {signURL(d.secret, http.MethodGet, key, name, contentType, expiresAt)},
+	}
+}
+
+func (d *downloadSigner) Verify(key, name, contentType, expires, signature string) error {
+	return verifyURL(d.secret, signature, http.MethodGet, expires, key, name, contentType)
+}
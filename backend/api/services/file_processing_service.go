@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"github.com/darmiel/perplex/pkg/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	// sniffLength is the number of bytes used to detect the content type
+	sniffLength = 512
+	// ThumbnailSize is the maximum width and height of a thumbnail
+	ThumbnailSize = 256
+	// ThumbnailContentType is the content type of all generated thumbnails
+	ThumbnailContentType = "image/png"
+	// maxThumbnailSourceSize is the maximum size of an image a thumbnail is generated for
+	maxThumbnailSourceSize = 32 << 20
+	// maxThumbnailSourcePixels is the maximum number of pixels of an image a thumbnail is generated for.
+	// Decoded images need up to 4 bytes per pixel (64 MB)
+	maxThumbnailSourcePixels = 16_000_000
+	// thumbnailWorkers is the number of thumbnails which are generated concurrently
+	thumbnailWorkers = 2
+	// thumbnailQueueSize is the number of files which can wait for their thumbnail
+	thumbnailQueueSize = 256
+	// thumbnailSamples is the number of samples per axis which are averaged for a thumbnail pixel
+	thumbnailSamples = 4
+)
+
+var (
+	ErrImageTooLarge      = errors.New("image too large for thumbnail")
+	ErrThumbnailQueueFull = errors.New("too many pending thumbnails")
+)
+
+// DetectContentType detects the content type of the content of r using its first bytes.
+// The returned reader must be used instead of r since the first bytes were already consumed
+func DetectContentType(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, err
+	}
+	head = head[:n]
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// hasThumbnailSupport returns true if a thumbnail can be generated for files of the content type
+func hasThumbnailSupport(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// FileProcessingService detects the content type of stored project files and generates thumbnails for images
+type FileProcessingService interface {
+	// ProcessFile detects and saves the content type of the file. If the file is an image, its thumbnail
+	// is generated in the background and the thumbnail key is saved to the file afterwards
+	ProcessFile(file *model.ProjectFile) error
+}
+
+type fileProcessingService struct {
+	DB      *gorm.DB
+	storage StorageService
+	logger  *zap.SugaredLogger
+	// thumbnails contains the files whose thumbnails are generated by the workers
+	thumbnails chan model.ProjectFile
+}
+
+func NewFileProcessingService(db *gorm.DB, storage StorageService, logger *zap.SugaredLogger) FileProcessingService {
+	f := &fileProcessingService{
+		DB:         db,
+		storage:    storage,
+		logger:     logger,
+		thumbnails: make(chan model.ProjectFile, thumbnailQueueSize),
+	}
+	// decoding images needs a lot of memory, so only a few thumbnails are generated at the same time
+	for i := 0; i < thumbnailWorkers; i++ {
+		go f.thumbnailWorker()
+	}
+	return f
+}
+
+func (f *fileProcessingService) ProcessFile(file *model.ProjectFile) error {
+	body, _, err := f.storage.Get(file.ObjectKey)
+	if err != nil {
+		return err
+	}
+	contentType, _, err := DetectContentType(body)
+	_ = body.Close()
+	if err != nil {
+		return err
+	}
+	file.ContentType = contentType
+	// the thumbnail of a previous version is replaced when the new thumbnail is generated
+	file.ThumbnailKey = ""
+	if err = f.DB.Model(file).Updates(map[string]any{
+		"content_type":  file.ContentType,
+		"thumbnail_key": file.ThumbnailKey,
+	}).Error; err != nil {
+		return err
+	}
+	// malware is not decoded to generate a thumbnail
+	if !hasThumbnailSupport(contentType) || file.Size > maxThumbnailSourceSize ||
+		file.ScanStatus == model.ScanStatusInfected {
+		return nil
+	}
+	select {
+	case f.thumbnails <- *file:
+		return nil
+	default:
+		return ErrThumbnailQueueFull
+	}
+}
+
+func (f *fileProcessingService) thumbnailWorker() {
+	for file := range f.thumbnails {
+		if err := f.generateThumbnail(&file); err != nil {
+			f.logger.Warnf("cannot generate thumbnail of file %d: %v", file.ID, err)
+		}
+	}
+}
+
+// generateThumbnail stores the thumbnail of the image file and saves its key to the file
+func (f *fileProcessingService) generateThumbnail(file *model.ProjectFile) error {
+	body, _, err := f.storage.Get(file.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err = f.storeThumbnail(file, body); err != nil {
+		return err
+	}
+	// the file could have been replaced by a new version in the meantime
+	return f.DB.Model(&model.ProjectFile{}).
+		Where("id = ? AND object_key = ?", file.ID, file.ObjectKey).
+		UpdateColumn("thumbnail_key", file.ThumbnailKey).Error
+}
+
+// ThumbnailKey returns the key of the thumbnail of an object.
+// Files with identical content share the object and therefore the thumbnail
+func ThumbnailKey(objectKey string) string {
+	return objectKey + ".thumbnail.png"
+}
+
+// storeThumbnail generates a thumbnail of the image and stores it next to the file
+func (f *fileProcessingService) storeThumbnail(file *model.ProjectFile, r io.Reader) error {
+	var buf bytes.Buffer
+	// decode the header first to reject images which would need too much memory
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return ErrImageTooLarge
+	}
+	img, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err = png.Encode(&out, thumbnail(img, ThumbnailSize)); err != nil {
+		return err
+	}
+	key := ThumbnailKey(file.ObjectKey)
+	if err = f.storage.Put(key, &out, int64(out.Len()), PutOptions{
+		ContentType: ThumbnailContentType,
+	}); err != nil {
+		return err
+	}
+	file.ThumbnailKey = key
+	return nil
+}
+
+// thumbnail scales the image down to fit into size x size while keeping the aspect ratio.
+// Each pixel is the average of a grid of samples of the covered source area
+func thumbnail(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+	dstWidth, dstHeight := size, size
+	if width > height {
+		dstHeight = height * size / width
+	} else {
+		dstWidth = width * size / height
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var r, g, b, a uint32
+			for sy := 0; sy < thumbnailSamples; sy++ {
+				for sx := 0; sx < thumbnailSamples; sx++ {
+					srcX := bounds.Min.X + ((x*thumbnailSamples+sx)*width)/(dstWidth*thumbnailSamples)
+					srcY := bounds.Min.Y + ((y*thumbnailSamples+sy)*height)/(dstHeight*thumbnailSamples)
+					pr, pg, pb, pa := src.At(srcX, srcY).RGBA()
+					r, g, b, a = r+pr, g+pg, b+pb, a+pa
+				}
+			}
+			const n = thumbnailSamples * thumbnailSamples
+			// RGBA returns alpha-premultiplied 16-bit values
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
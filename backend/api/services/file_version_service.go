@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrVersionIsCurrent = errors.New("version is already the current version")
+
+// FileVersionService manages the previous versions of project files
+type FileVersionService interface {
+	// AddVersion makes the object the current version of the file.
+	// The previous version is kept in the version history. The upload is audited as performed by the creator
+	AddVersion(fileID uint, object *model.StoredObject, scan ScanResult, creatorID string) (*model.ProjectFile, error)
+	// FindVersions returns the previous versions of the file (newest first)
+	FindVersions(fileID uint) ([]model.ProjectFileVersion, error)
+	FindVersion(fileID uint, version int) (*model.ProjectFileVersion, error)
+	// RestoreVersion makes a previous version the current version of the file with a new version number.
+	// The replaced version is kept in the version history. The restore is audited as performed by the user
+	RestoreVersion(fileID uint, version int, userID string) (*model.ProjectFile, error)
+	// DeleteVersions deletes all previous versions of the file and returns them,
+	// so their objects can be deleted from the storage
+	DeleteVersions(fileID uint) ([]model.ProjectFileVersion, error)
+}
+
+type fileVersionService struct {
+	DB *gorm.DB
+}
+
+func NewFileVersionService(db *gorm.DB) FileVersionService {
+	return &fileVersionService{
+		DB: db,
+	}
+}
+
+// archiveCurrentVersion stores the current version of the file in the version history
+func archiveCurrentVersion(tx *gorm.DB, file *model.ProjectFile) error {
+	creatorID := file.VersionCreatorID
+	if creatorID == "" {
+		creatorID = file.CreatorID
+	}
+	version := model.ProjectFileVersion{
+		FileID:        file.ID,
+		ProjectID:     file.ProjectID,
+		Version:       file.Version,
+		ObjectKey:     file.ObjectKey,
+		Size:          file.Size,
+		Hash:          file.Hash,
+		ContentType:   file.ContentType,
+		ThumbnailKey:  file.ThumbnailKey,
+		ScanStatus:    file.ScanStatus,
+		ScanSignature: file.ScanSignature,
+		CreatorID:     creatorID,
+	}
+	// keep the upload time of the version
+	version.CreatedAt = file.UpdatedAt
+	return tx.Create(&version).Error
+}
+
+// latestVersion returns the highest version number of the file
+func latestVersion(tx *gorm.DB, file *model.ProjectFile) (int, error) {
+	var latest *int
+	if err := tx.Model(&model.ProjectFileVersion{}).
+		Where("file_id = ?", file.ID).
+		Select("max(version)").
+		Scan(&latest).Error; err != nil {
+		return 0, err
+	}
+	if latest == nil || *latest < file.Version {
+		return file.Version, nil
+	}
+	return *latest, nil
+}
+
+// addFileVersion replaces the current version of the file with the scanned object inside a transaction.
+// The new version is audited as performed by the creator
+func addFileVersion(tx *gorm.DB, fileID uint, object *model.StoredObject, scan ScanResult, creatorID string) (*model.ProjectFile, error) {
+	// the file is locked so concurrent uploads cannot archive the same version
+	// or assign the same version number
+	var file model.ProjectFile
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&file, fileID).Error; err != nil {
+		return nil, err
+	}
+	if err := archiveCurrentVersion(tx, &file); err != nil {
+		return nil, err
+	}
+	latest, err := latestVersion(tx, &file)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]any{
+		"version": file.Version,
+		"size":    file.Size,
+	}
+	// the content type and thumbnail are detected again for the new version
+	if err = tx.Model(&file).Updates(map[string]any{
+		"version":            latest + 1,
+		"version_creator_id": creatorID,
+		"object_key":         object.ObjectKey,
+		"size":               object.Size,
+		"hash":               object.Hash,
+		"scan_status":        scan.Status,
+		"scan_signature":     scan.Signature,
+		"content_type":       "",
+		"thumbnail_key":      "",
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err = recordAudit(tx, model.AuditEvent{
+		ProjectID:  file.ProjectID,
+		ActorID:    creatorID,
+		EntityType: model.AuditEntityFile,
+		EntityID:   file.ID,
+		Action:     model.AuditActionUpdate,
+	}, before, map[string]any{
+		"version": file.Version,
+		"size":    file.Size,
+	}); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (f *fileVersionService) AddVersion(fileID uint, object *model.StoredObject, scan ScanResult, creatorID string) (res *model.ProjectFile, err error) {
+	err = f.DB.Transaction(func(tx *gorm.DB) error {
+		res, err = addFileVersion(tx, fileID, object, scan, creatorID)
+		return err
+	})
+	return
+}
+
+func (f *fileVersionService) FindVersions(fileID uint) ([]model.ProjectFileVersion, error) {
+	var versions []model.ProjectFileVersion
+	if err := f.DB.Where("file_id = ?", fileID).
+		Preload("Creator").
+		Order("version DESC").
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (f *fileVersionService) FindVersion(fileID uint, version int) (*model.ProjectFileVersion, error) {
+	var res model.ProjectFileVersion
+	if err := f.DB.Where("file_id = ? AND version = ?", fileID, version).
+		First(&res).Error; err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (f *fileVersionService) RestoreVersion(fileID uint, version int, userID string) (res *model.ProjectFile, err error) {
+	err = f.DB.Transaction(func(tx *gorm.DB) error {
+		var file model.ProjectFile
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&file, fileID).Error; err != nil {
+			return err
+		}
+		if file.Version == version {
+			return ErrVersionIsCurrent
+		}
+		var restored model.ProjectFileVersion
+		if err := tx.Where("file_id = ? AND version = ?", fileID, version).
+			First(&restored).Error; err != nil {
+			return err
+		}
+		if err := archiveCurrentVersion(tx, &file); err != nil {
+			return err
+		}
+		latest, err := latestVersion(tx, &file)
+		if err != nil {
+			return err
+		}
+		before := map[string]any{
+			"version": file.Version,
+		}
+		// the restored version is moved out of the history, so every object belongs to exactly one version
+		if err = tx.Unscoped().Delete(&restored).Error; err != nil {
+			return err
+		}
+		if err = tx.Model(&file).Updates(map[string]any{
+			"version":            latest + 1,
+			"version_creator_id": userID,
+			"object_key":         restored.ObjectKey,
+			"size":               restored.Size,
+			"hash":               restored.Hash,
+			"scan_status":        restored.ScanStatus,
+			"scan_signature":     restored.ScanSignature,
+			"content_type":       restored.ContentType,
+			"thumbnail_key":      restored.ThumbnailKey,
+		}).Error; err != nil {
+			return err
+		}
+		res = &file
+		return recordAudit(tx, model.AuditEvent{
+			ProjectID:  file.ProjectID,
+			ActorID:    userID,
+			EntityType: model.AuditEntityFile,
+			EntityID:   file.ID,
+			Action:     model.AuditActionUpdate,
+		}, before, map[string]any{
+			"version":       file.Version,
+			"restored_from": version,
+		})
+	})
+	return
+}
+
+func (f *fileVersionService) DeleteVersions(fileID uint) ([]model.ProjectFileVersion, error) {
+	var versions []model.ProjectFileVersion
+	if err := f.DB.Where("file_id = ?", fileID).
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	if err := f.DB.Unscoped().
+		Where("file_id = ?", fileID).
+		Delete(&model.ProjectFileVersion{}).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFolderNotFound  = errors.New("folder not found")
+	ErrFolderNotEmpty  = errors.New("folder is not empty")
+	ErrFolderNameTaken = errors.New("a folder with this name already exists")
+	ErrFolderCycle     = errors.New("folder cannot be moved into itself or one of its subfolders")
+)
+
+// FolderInfo is a folder with the aggregated size of all files in the folder and its subfolders
+type FolderInfo struct {
+	model.ProjectFolder
+	// Size is the size of all files (including previous versions) in the folder and its subfolders
+	Size uint64 `json:"size"`
+	// FileCount is the number of files in the folder and its subfolders
+	FileCount int `json:"file_count"`
+}
+
+// FolderContents are the files and subfolders of a folder
+type FolderContents struct {
+	// Folder is the listed folder (nil for the root folder)
+	Folder *model.ProjectFolder `json:"folder"`
+	// Path contains all parent folders of the listed folder, starting at the root folder
+	Path []model.ProjectFolder `json:"path"`
+	// Size is the size of all files (including previous versions) in the folder and its subfolders
+	Size uint64 `json:"size"`
+	// FileCount is the number of files in the folder and its subfolders
+	FileCount int                 `json:"file_count"`
+	Folders   []FolderInfo        `json:"folders"`
+	Files     []model.ProjectFile `json:"files"`
+}
+
+// FolderService manages the folder hierarchy of project files.
+// A nil folder ID refers to the root folder of the project.
+// The mutations of folders and files are audited as performed by the actor (or creator)
+type FolderService interface {
+	CreateFolder(projectID uint, parentID *uint, name, creatorID string) (*model.ProjectFolder, error)
+	FindFolder(projectID uint, folderID uint) (*model.ProjectFolder, error)
+	RenameFolder(folder *model.ProjectFolder, name, actorID string) error
+	MoveFolder(folder *model.ProjectFolder, parentID *uint, actorID string) error
+	// DeleteFolder deletes the folder. Only empty folders can be deleted
+	DeleteFolder(folder *model.ProjectFolder, actorID string) error
+	MoveFile(file *model.ProjectFile, folderID *uint, actorID string) error
+	// ListContents returns the files and subfolders of the folder with their aggregated sizes
+	ListContents(projectID uint, folderID *uint) (*FolderContents, error)
+}
+
+type folderService struct {
+	DB *gorm.DB
+}
+
+func NewFolderService(db *gorm.DB) FolderService {
+	return &folderService{
+		DB: db,
+	}
+}
+
+// inFolder restricts the query to rows whose column references the folder
+func inFolder(tx *gorm.DB, column string, folderID *uint) *gorm.DB {
+	if folderID == nil {
+		return tx.Where(column + " IS NULL")
+	}
+	return tx.Where(column+" = ?", *folderID)
+}
+
+// findFolder returns the folder of the project or ErrFolderNotFound
+func findFolder(tx *gorm.DB, projectID uint, folderID uint) (*model.ProjectFolder, error) {
+	var folders []model.ProjectFolder
+	if err := tx.Where("id = ? AND project_id = ?", folderID, projectID).
+		Limit(1).
+		Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, ErrFolderNotFound
+	}
+	return &folders[0], nil
+}
+
+// checkParent checks if the parent folder exists in the project and does not contain
+// another folder with the same name. The project is locked until the end of the transaction,
+// so concurrent mutations cannot create the same name or a cycle.
+// The unique index does not apply to folders in the root folder since their parent is NULL
+func checkParent(tx *gorm.DB, projectID uint, parentID *uint, name string, folderID uint) error {
+	if err := lockProject(tx, projectID); err != nil {
+		return err
+	}
+	if parentID != nil {
+		if _, err := findFolder(tx, projectID, *parentID); err != nil {
+			return err
+		}
+	}
+	var count int64
+	if err := inFolder(tx.Model(&model.ProjectFolder{}), "parent_id", parentID).
+		Where("project_id = ? AND name = ? AND id <> ?", projectID, name, folderID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrFolderNameTaken
+	}
+	return nil
+}
+
+func (f *folderService) CreateFolder(projectID uint, parentID *uint, name, creatorID string) (res *model.ProjectFolder, err error) {
+	err = f.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkParent(tx, projectID, parentID, name, 0); err != nil {
+			return err
+		}
+		res = &model.ProjectFolder{
+			Name:      name,
+			ProjectID: projectID,
+			ParentID:  parentID,
+			CreatorID: creatorID,
+		}
+		if err := tx.Create(res).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, creatorID, model.AuditEntityFolder, res.ID, model.AuditActionCreate, nil, res)
+	})
+	return
+}
+
+func (f *folderService) FindFolder(projectID uint, folderID uint) (*model.ProjectFolder, error) {
+	return findFolder(f.DB, projectID, folderID)
+}
+
+func (f *folderService) RenameFolder(folder *model.ProjectFolder, name, actorID string) error {
+	return f.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkParent(tx, folder.ProjectID, folder.ParentID, name, folder.ID); err != nil {
+			return err
+		}
+		before := *folder
+		if err := tx.Model(folder).Update("name", name).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityFolder, folder.ID, model.AuditActionUpdate, &before, folder)
+	})
+}
+
+func (f *folderService) MoveFolder(folder *model.ProjectFolder, parentID *uint, actorID string) error {
+	return f.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkParent(tx, folder.ProjectID, parentID, folder.Name, folder.ID); err != nil {
+			return err
+		}
+		// the new parent must not be the folder itself or one of its subfolders
+		for current := parentID; current != nil; {
+			if *current == folder.ID {
+				return ErrFolderCycle
+			}
+			parent, err := findFolder(tx, folder.ProjectID, *current)
+			if err != nil {
+				return err
+			}
+			current = parent.ParentID
+		}
+		before := *folder
+		if err := tx.Model(folder).Update("parent_id", parentID).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityFolder, folder.ID, model.AuditActionUpdate, &before, folder)
+	})
+}
+
+func (f *folderService) DeleteFolder(folder *model.ProjectFolder, actorID string) error {
+	return f.DB.Transaction(func(tx *gorm.DB) error {
+		var folders, files int64
+		if err := tx.Model(&model.ProjectFolder{}).
+			Where("parent_id = ?", folder.ID).
+			Count(&folders).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.ProjectFile{}).
+			Where("folder_id = ?", folder.ID).
+			Count(&files).Error; err != nil {
+			return err
+		}
+		if folders > 0 || files > 0 {
+			return ErrFolderNotEmpty
+		}
+		if err := tx.Delete(folder).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityFolder, folder.ID, model.AuditActionDelete, folder, nil)
+	})
+}
+
+func (f *folderService) MoveFile(file *model.ProjectFile, folderID *uint, actorID string) error {
+	return f.DB.Transaction(func(tx *gorm.DB) error {
+		if folderID != nil {
+			if _, err := findFolder(tx, file.ProjectID, *folderID); err != nil {
+				return err
+			}
+		}
+		before := *file
+		// moving a file does not change its content, so updated_at is not touched
+		if err := tx.Model(file).UpdateColumn("folder_id", folderID).Error; err != nil {
+			return err
+		}
+		file.FolderID = folderID
+		return auditEntity(tx, actorID, model.AuditEntityFile, file.ID, model.AuditActionUpdate, &before, file)
+	})
+}
+
+type folderUsage struct {
+	FolderID  *uint
+	Size      uint64
+	FileCount int
+}
+
+// folderSizes returns the size and number of files of every folder of the project including
+// its subfolders. The root folder is stored under the ID 0
+func (f *folderService) folderSizes(projectID uint, folders []model.ProjectFolder) (map[uint]folderUsage, error) {
+	var files, versions []folderUsage
+	if err := f.DB.Model(&model.ProjectFile{}).
+		Where("project_id = ?", projectID).
+		Select("folder_id, sum(size) AS size, count(*) AS file_count").
+		Group("folder_id").
+		Scan(&files).Error; err != nil {
+		return nil, err
+	}
+	if err := f.DB.Model(&model.ProjectFileVersion{}).
+		Joins("JOIN project_files ON project_files.id = project_file_versions.file_id AND project_files.deleted_at IS NULL").
+		Where("project_file_versions.project_id = ?", projectID).
+		Select("project_files.folder_id AS folder_id, sum(project_file_versions.size) AS size").
+		Group("project_files.folder_id").
+		Scan(&versions).Error; err != nil {
+		return nil, err
+	}
+	direct := make(map[uint]folderUsage)
+	for _, usage := range append(files, versions...) {
+		var id uint
+		if usage.FolderID != nil {
+			id = *usage.FolderID
+		}
+		d := direct[id]
+		d.Size += usage.Size
+		d.FileCount += usage.FileCount
+		direct[id] = d
+	}
+	children := make(map[uint][]uint)
+	for _, folder := range folders {
+		var parent uint
+		if folder.ParentID != nil {
+			parent = *folder.ParentID
+		}
+		children[parent] = append(children[parent], folder.ID)
+	}
+	total := make(map[uint]folderUsage)
+	var aggregate func(id uint, depth int) folderUsage
+	aggregate = func(id uint, depth int) folderUsage {
+		usage := direct[id]
+		// the depth is limited in case the hierarchy contains a cycle
+		if depth <= len(folders) {
+			for _, child := range children[id] {
+				childUsage := aggregate(child, depth+1)
+				usage.Size += childUsage.Size
+				usage.FileCount += childUsage.FileCount
+			}
+		}
+		total[id] = usage
+		return usage
+	}
+	aggregate(0, 0)
+	return total, nil
+}
+
+func (f *folderService) ListContents(projectID uint, folderID *uint) (*FolderContents, error) {
+	res := &FolderContents{
+		Path:    []model.ProjectFolder{},
+		Folders: []FolderInfo{},
+		Files:   []model.ProjectFile{},
+	}
+	var folders []model.ProjectFolder
+	if err := f.DB.Where("project_id = ?", projectID).
+		Order("name").
+		Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.ProjectFolder, len(folders))
+	for _, folder := range folders {
+		byID[folder.ID] = folder
+	}
+	var current uint
+	if folderID != nil {
+		folder, ok := byID[*folderID]
+		if !ok {
+			return nil, ErrFolderNotFound
+		}
+		res.Folder = &folder
+		current = folder.ID
+		// walk up to the root folder
+		for parent := folder.ParentID; parent != nil && len(res.Path) < len(folders); {
+			p, ok := byID[*parent]
+			if !ok {
+				break
+			}
+			res.Path = append([]model.ProjectFolder{p}, res.Path...)
+			parent = p.ParentID
+		}
+	}
+	sizes, err := f.folderSizes(projectID, folders)
+	if err != nil {
+		return nil, err
+	}
+	res.Size = sizes[current].Size
+	res.FileCount = sizes[current].FileCount
+	for _, folder := range folders {
+		if (folderID == nil && folder.ParentID == nil) ||
+			(folderID != nil && folder.ParentID != nil && *folder.ParentID == *folderID) {
+			res.Folders = append(res.Folders, FolderInfo{
+				ProjectFolder: folder,
+				Size:          sizes[folder.ID].Size,
+				FileCount:     sizes[folder.ID].FileCount,
+			})
+		}
+	}
+	if err = inFolder(f.DB, "folder_id", folderID).
+		Where("project_id = ?", projectID).
+		Order("name").
+		Find(&res.Files).Error; err != nil {
+		return nil, err
+	}
+	return res, nil
+}
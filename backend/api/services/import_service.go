@@ -0,0 +1,294 @@
+package services
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/pkg/ical"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/rrule"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportAction is what an import does with an event
+type ImportAction string
+
+const (
+	ImportCreate ImportAction = "create"
+	ImportUpdate ImportAction = "update"
+	ImportSkip   ImportAction = "skip"
+)
+
+// ImportItem describes the import of a single event
+type ImportItem struct {
+	// UID is the UID of the event. Overridden occurrences of recurring events
+	// are identified by the UID and the original start ("<uid>#<recurrence-id>")
+	UID       string    `json:"uid"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	TimeZone  string    `json:"time_zone,omitempty"`
+	// RRule is the recurrence rule of recurring events, which are imported as meeting series
+	RRule  string       `json:"rrule,omitempty"`
+	Action ImportAction `json:"action"`
+	// Reason describes why the event is skipped
+	Reason string `json:"reason,omitempty"`
+	// UserIDs contains the project users matching the attendees
+	UserIDs []string `json:"user_ids"`
+	// UnmatchedAttendees contains the attendees which are not users of the project
+	UnmatchedAttendees []string `json:"unmatched_attendees"`
+	// MeetingID is the ID of the updated meeting (or the created meeting after the import)
+	MeetingID uint `json:"meeting_id,omitempty"`
+	// SeriesID is the ID of the updated meeting series (or the created series after the import)
+	SeriesID uint `json:"series_id,omitempty"`
+
+	event ical.Event
+}
+
+// ImportService imports meetings from iCalendar files. Imported meetings keep the UID of their event,
+// so importing the same file again updates the meetings instead of creating duplicates
+type ImportService interface {
+	// Preview returns what Import would do without changing anything
+	Preview(project *model.Project, cal *ical.Calendar) ([]ImportItem, error)
+	// Import creates or updates meetings (single events) and meeting series (recurring events).
+	// The changes are audited as performed by the creator
+	Import(project *model.Project, creatorID string, cal *ical.Calendar) ([]ImportItem, error)
+}
+
+type importService struct {
+	DB        *gorm.DB
+	projSrv   ProjectService
+	meetSrv   MeetingService
+	seriesSrv SeriesService
+}
+
+func NewImportService(
+	db *gorm.DB,
+	projSrv ProjectService,
+	meetSrv MeetingService,
+	seriesSrv SeriesService,
+) ImportService {
+	return &importService{
+		DB:        db,
+		projSrv:   projSrv,
+		meetSrv:   meetSrv,
+		seriesSrv: seriesSrv,
+	}
+}
+
+// importKey returns the UID of the meeting or series an event is imported to
+func importKey(e ical.Event) string {
+	if e.RecurrenceID.IsZero() {
+		return e.UID
+	}
+	return e.UID + "#" + ical.FormatTime(e.RecurrenceID)
+}
+
+// matchAttendees returns the IDs of the project users matching the attendees and the attendees
+// without a matching user. Attendees are only matched by their full email address, which is
+// only stored for users with a verified email address
+func matchAttendees(users []model.User, attendees []ical.Attendee) (ids []string, unmatched []string) {
+	ids, unmatched = []string{}, []string{}
+	seen := make(map[string]bool)
+	for _, a := range attendees {
+		var match *model.User
+		for i, u := range users {
+			if a.Email != "" && u.Email != "" && strings.EqualFold(u.Email, a.Email) {
+				match = &users[i]
+				break
+			}
+		}
+		if match == nil {
+			name := a.Name
+			if name == "" {
+				name = a.Email
+			}
+			unmatched = append(unmatched, name)
+			continue
+		}
+		if !seen[match.ID] {
+			seen[match.ID] = true
+			ids = append(ids, match.ID)
+		}
+	}
+	return
+}
+
+func (i *importService) plan(project *model.Project, cal *ical.Calendar) ([]ImportItem, error) {
+	p, err := i.projSrv.FindProject(project.ID, "Owner", "Users")
+	if err != nil {
+		return nil, err
+	}
+	users := append([]model.User{p.Owner}, p.Users...)
+
+	events := append([]ical.Event(nil), cal.Events...)
+	// recurring events are imported before their overridden occurrences
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].RecurrenceID.IsZero() && !events[b].RecurrenceID.IsZero()
+	})
+	res := make([]ImportItem, len(events))
+	for n, e := range events {
+		item := ImportItem{
+			UID:       importKey(e),
+			Name:      strings.TrimSpace(e.Summary),
+			StartDate: e.Start,
+			EndDate:   e.End,
+			TimeZone:  e.TimeZone,
+			RRule:     strings.TrimPrefix(e.RRule, "RRULE:"),
+			event:     e,
+		}
+		item.UserIDs, item.UnmatchedAttendees = matchAttendees(users, e.Attendees)
+		if item.Name == "" {
+			item.Name = "Imported Meeting"
+		}
+		if name := []rune(item.Name); len(name) > 128 {
+			item.Name = string(name[:128])
+		}
+		switch {
+		case e.UID == "":
+			item.Action, item.Reason = ImportSkip, "event has no UID"
+		case e.Cancelled:
+			item.Action, item.Reason = ImportSkip, "event is cancelled"
+		case e.End.Before(e.Start):
+			item.Action, item.Reason = ImportSkip, "event ends before it starts"
+		case item.RRule != "" && e.RecurrenceID.IsZero():
+			if _, err = rrule.Parse(item.RRule); err != nil {
+				item.Action, item.Reason = ImportSkip, err.Error()
+				break
+			}
+			item.Action = ImportCreate
+			series, err := i.seriesSrv.FindSeriesByICalUID(project.ID, item.UID)
+			if err == nil {
+				item.Action, item.SeriesID = ImportUpdate, series.ID
+			} else if !errors.Is(err, ErrSeriesNotFound) {
+				return nil, err
+			}
+		default:
+			item.RRule = ""
+			item.Action = ImportCreate
+			meeting, err := i.meetSrv.FindMeetingByICalUID(project.ID, item.UID)
+			if err == nil {
+				item.Action, item.MeetingID = ImportUpdate, meeting.ID
+			} else if !errors.Is(err, ErrNotMatches) {
+				return nil, err
+			}
+		}
+		res[n] = item
+	}
+	return res, nil
+}
+
+func (i *importService) Preview(project *model.Project, cal *ical.Calendar) ([]ImportItem, error) {
+	return i.plan(project, cal)
+}
+
+func (i *importService) Import(project *model.Project, creatorID string, cal *ical.Calendar) ([]ImportItem, error) {
+	var items []ImportItem
+	// either all events are imported or none
+	err := i.DB.Transaction(func(tx *gorm.DB) (err error) {
+		// the meetings and series are changed by services using the transaction.
+		// occurrences of series are still created only once because of their unique index
+		txSrv := &importService{
+			DB:        tx,
+			projSrv:   i.projSrv,
+			meetSrv:   NewMeetingService(tx),
+			seriesSrv: NewSeriesService(tx),
+		}
+		if items, err = txSrv.plan(project, cal); err != nil {
+			return err
+		}
+		for n := range items {
+			item := &items[n]
+			switch {
+			case item.Action == ImportSkip:
+				continue
+			case item.RRule != "":
+				err = txSrv.importSeries(project, creatorID, item)
+			default:
+				err = txSrv.importMeeting(project, creatorID, item)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (i *importService) importSeries(project *model.Project, creatorID string, item *ImportItem) error {
+	timeZone := item.TimeZone
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	series := &model.MeetingSeries{
+		ProjectID: project.ID,
+		CreatorID: creatorID,
+		ICalUID:   item.UID,
+	}
+	if item.Action == ImportUpdate {
+		var err error
+		if series, err = i.seriesSrv.FindSeries(project.ID, item.SeriesID); err != nil {
+			return err
+		}
+	}
+	series.Name = item.Name
+	series.Description = item.event.Description
+	series.StartDate = item.StartDate
+	series.EndDate = item.EndDate
+	series.TimeZone = timeZone
+	series.RRule = item.RRule
+	series.ExDates = item.event.ExDates
+	if item.Action == ImportUpdate {
+		if err := i.seriesSrv.UpdateSeries(series, creatorID); err != nil {
+			return err
+		}
+	} else if err := i.seriesSrv.CreateSeries(series); err != nil {
+		return err
+	}
+	item.SeriesID = series.ID
+	if err := i.seriesSrv.LinkUsers(series, item.UserIDs); err != nil {
+		return err
+	}
+	return i.seriesSrv.MaterializeSeries(series, time.Now().Add(SeriesHorizon))
+}
+
+func (i *importService) importMeeting(project *model.Project, creatorID string, item *ImportItem) error {
+	if item.Action == ImportUpdate {
+		if err := i.meetSrv.EditMeeting(item.MeetingID, item.Name, item.event.Description,
+			item.StartDate, item.EndDate, creatorID); err != nil {
+			return err
+		}
+	} else {
+		meeting, err := i.meetSrv.AddMeeting(project.ID, creatorID, item.Name, item.event.Description,
+			item.StartDate, item.EndDate)
+		if err != nil {
+			return err
+		}
+		if err = i.meetSrv.SetICalUID(meeting.ID, item.UID); err != nil {
+			return err
+		}
+		item.MeetingID = meeting.ID
+	}
+	for _, userID := range item.UserIDs {
+		if err := i.meetSrv.LinkUser(item.MeetingID, userID, creatorID); err != nil {
+			return err
+		}
+	}
+	// an overridden occurrence replaces the occurrence of the imported series
+	if !item.event.RecurrenceID.IsZero() {
+		series, err := i.seriesSrv.FindSeriesByICalUID(project.ID, item.event.UID)
+		if errors.Is(err, ErrSeriesNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return i.seriesSrv.ExcludeDate(series, item.event.RecurrenceID)
+	}
+	return nil
+}
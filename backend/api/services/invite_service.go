@@ -0,0 +1,226 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"strings"
+)
+
+var (
+	ErrInviteNotUsable    = errors.New("invite expired or already used")
+	ErrInviteWrongEmail   = errors.New("invite was issued for another email address")
+	ErrAlreadyProjectUser = errors.New("user already in project")
+)
+
+type InviteService interface {
+	// CreateInvite creates an invite. The creation is audited as performed by the creator
+	CreateInvite(projectID uint, creatorID string, role model.ProjectRole, email string, maxUses int, expiresAt sql.NullTime) (*model.ProjectInvite, error)
+	FindInvite(inviteID uint) (*model.ProjectInvite, error)
+	FindInviteByToken(token string) (*model.ProjectInvite, error)
+	FindInvitesByProject(projectID uint) ([]model.ProjectInvite, error)
+	DeleteInvite(inviteID uint, actorID string) error
+	// AcceptInvite adds the user to the project of the invite.
+	// Invites issued for an email address can only be accepted with the verified email address
+	// of the user (empty if the email address is not verified).
+	// Joining the project is audited as performed by the user
+	AcceptInvite(token, userID, verifiedEmail string) (*model.ProjectInvite, error)
+	// AcceptPendingEmailInvites accepts all usable invites issued for the verified email address of the user
+	AcceptPendingEmailInvites(userID, verifiedEmail string) ([]model.ProjectInvite, error)
+}
+
+type inviteService struct {
+	DB *gorm.DB
+}
+
+func NewInviteService(db *gorm.DB) InviteService {
+	return &inviteService{
+		DB: db,
+	}
+}
+
+// auditInvite records the mutation of an invite. The token is omitted since it is a secret
+func auditInvite(tx *gorm.DB, actorID string, action model.AuditAction, before, after *model.ProjectInvite) error {
+	fields := func(invite *model.ProjectInvite) any {
+		if invite == nil {
+			return nil
+		}
+		return map[string]any{
+			"role":       invite.Role,
+			"email":      invite.Email,
+			"max_uses":   invite.MaxUses,
+			"expires_at": invite.ExpiresAt,
+		}
+	}
+	projectID := after
+	if projectID == nil {
+		projectID = before
+	}
+	return recordAudit(tx, model.AuditEvent{
+		ProjectID:  projectID.ProjectID,
+		ActorID:    actorID,
+		EntityType: model.AuditEntityInvite,
+		EntityID:   projectID.ID,
+		Action:     action,
+	}, fields(before), fields(after))
+}
+
+func (i *inviteService) CreateInvite(
+	projectID uint,
+	creatorID string,
+	role model.ProjectRole,
+	email string,
+	maxUses int,
+	expiresAt sql.NullTime,
+) (*model.ProjectInvite, error) {
+	token, err := randomHexString(48)
+	if err != nil {
+		return nil, err
+	}
+	invite := model.ProjectInvite{
+		Token:     token,
+		ProjectID: projectID,
+		CreatorID: creatorID,
+		Role:      role,
+		Email:     strings.ToLower(email),
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+	}
+	if err = i.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&invite).Error; err != nil {
+			return err
+		}
+		return auditInvite(tx, creatorID, model.AuditActionCreate, nil, &invite)
+	}); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (i *inviteService) FindInvite(inviteID uint) (*model.ProjectInvite, error) {
+	var invite model.ProjectInvite
+	if err := i.DB.First(&invite, inviteID).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (i *inviteService) FindInviteByToken(token string) (*model.ProjectInvite, error) {
+	var invite model.ProjectInvite
+	if err := i.DB.Preload("Project").
+		Where("token = ?", token).
+		First(&invite).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (i *inviteService) FindInvitesByProject(projectID uint) ([]model.ProjectInvite, error) {
+	var invites []model.ProjectInvite
+	if err := i.DB.Where(&model.ProjectInvite{
+		ProjectID: projectID,
+	}).Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+func (i *inviteService) DeleteInvite(inviteID uint, actorID string) error {
+	return i.DB.Transaction(func(tx *gorm.DB) error {
+		var invite model.ProjectInvite
+		if err := tx.First(&invite, inviteID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&invite).Error; err != nil {
+			return err
+		}
+		return auditInvite(tx, actorID, model.AuditActionDelete, &invite, nil)
+	})
+}
+
+// accept adds the user to the project of the invite and increments the usage counter.
+// The user joining the project is audited as performed by the user
+func (i *inviteService) accept(tx *gorm.DB, invite *model.ProjectInvite, userID string) error {
+	if !invite.IsUsable() {
+		return ErrInviteNotUsable
+	}
+	var project model.Project
+	if err := tx.Preload("Members").First(&project, invite.ProjectID).Error; err != nil {
+		return err
+	}
+	if project.OwnerID == userID {
+		return ErrAlreadyProjectUser
+	}
+	for _, m := range project.Members {
+		if m.UserID == userID {
+			return ErrAlreadyProjectUser
+		}
+	}
+	// the usage counter is checked and incremented in a single statement,
+	// so concurrent accepts cannot exceed the maximum number of uses
+	result := tx.Model(&model.ProjectInvite{}).
+		Where("id = ? AND (max_uses <= 0 OR uses < max_uses)", invite.ID).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotUsable
+	}
+	invite.Uses++
+	if err := tx.Create(&model.ProjectMember{
+		ProjectID: invite.ProjectID,
+		UserID:    userID,
+		Role:      invite.Role,
+	}).Error; err != nil {
+		return err
+	}
+	return auditMember(tx, invite.ProjectID, userID, userID, model.AuditActionCreate,
+		nil, map[string]any{"role": invite.Role, "invite_id": invite.ID})
+}
+
+func (i *inviteService) AcceptInvite(token, userID, verifiedEmail string) (res *model.ProjectInvite, err error) {
+	err = i.DB.Transaction(func(tx *gorm.DB) error {
+		var invite model.ProjectInvite
+		if err := tx.Preload("Project").
+			Where("token = ?", token).
+			First(&invite).Error; err != nil {
+			return err
+		}
+		if invite.Email != "" && invite.Email != strings.ToLower(verifiedEmail) {
+			return ErrInviteWrongEmail
+		}
+		if err := i.accept(tx, &invite, userID); err != nil {
+			return err
+		}
+		res = &invite
+		return nil
+	})
+	return
+}
+
+func (i *inviteService) AcceptPendingEmailInvites(userID, verifiedEmail string) (res []model.ProjectInvite, err error) {
+	if verifiedEmail == "" {
+		return nil, nil
+	}
+	var invites []model.ProjectInvite
+	if err = i.DB.Preload("Project").
+		Where("email = ?", strings.ToLower(verifiedEmail)).
+		Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	for _, invite := range invites {
+		if err = i.DB.Transaction(func(tx *gorm.DB) error {
+			return i.accept(tx, &invite, userID)
+		}); err != nil {
+			// expired invites and projects the user is already in are skipped
+			if errors.Is(err, ErrInviteNotUsable) || errors.Is(err, ErrAlreadyProjectUser) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, invite)
+	}
+	return res, nil
+}
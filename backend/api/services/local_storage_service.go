@@ -0,0 +1,277 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signature expired")
+	ErrInvalidObjectKey = errors.New("invalid object key")
+)
+
+// LocalStorage is a StorageService which stores files on the local disk.
+// Download and upload URLs are signed and must be verified by the API before serving or storing the file
+type LocalStorage interface {
+	StorageService
+	// VerifyDownloadSignature checks if the signature of a download URL is valid and not expired
+	VerifyDownloadSignature(key, expires, signature string) error
+	// VerifyUploadSignature checks if the signature of an upload URL is valid and not expired
+	VerifyUploadSignature(key, expires, size, signature string) error
+}
+
+type localStorage struct {
+	basePath string
+	baseURL  string
+	secret   []byte
+}
+
+// NewLocalStorage creates a storage driver which stores files in STORAGE_LOCAL_PATH (default: data/files).
+// Download and upload URLs are created relative to STORAGE_LOCAL_URL (default: http://localhost:8080/storage)
+// and signed with STORAGE_LOCAL_SECRET. If no secret is specified, a random secret is generated
+// which invalidates all signed URLs on restart
+func NewLocalStorage() (StorageService, error) {
+	basePath, ok := os.LookupEnv("STORAGE_LOCAL_PATH")
+	if !ok {
+		basePath = "data/files"
+	}
+	baseURL, ok := os.LookupEnv("STORAGE_LOCAL_URL")
+	if !ok {
+		baseURL = "http://localhost:8080/storage"
+	}
+	var secret []byte
+	if s, ok := os.LookupEnv("STORAGE_LOCAL_SECRET"); ok {
+		secret = []byte(s)
+	} else {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(basePath, 0o750); err != nil {
+		return nil, err
+	}
+	return &localStorage{
+		basePath: basePath,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		secret:   secret,
+	}, nil
+}
+
+// path returns the path of the object on the disk and makes sure it is inside the base path
+func (l *localStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", ErrInvalidObjectKey
+	}
+	return filepath.Join(l.basePath, filepath.FromSlash(clean)), nil
+}
+
+func (l *localStorage) Put(key string, body io.Reader, _ int64, _ PutOptions) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+	// write to a temporary file first so incomplete uploads are never visible
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, body); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *localStorage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, ErrObjectNotFound
+		}
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, fileObjectInfo(stat), nil
+}
+
+func (l *localStorage) GetRange(key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	f, info, err := l.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file := f.(*os.File)
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(file, length), file}, info, nil
+}
+
+// limitedReadCloser closes the underlying file of a limited reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// fileObjectInfo returns the object info of a stored file.
+// The ETag is derived from the modification time and size of the file
+func fileObjectInfo(stat os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+		ETag:         fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+func (l *localStorage) Stat(key string) (*ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return fileObjectInfo(stat), nil
+}
+
+func (l *localStorage) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *localStorage) Walk(prefix string, fn func(key string, info *ObjectInfo) error) error {
+	return filepath.WalkDir(l.basePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// temporary files of running uploads are not objects
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.basePath, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(key, fileObjectInfo(stat))
+	})
+}
+
+// sign creates the signature of a URL for the given method and parameters
+func (l *localStorage) sign(method string, params ...string) string {
+	return signURL(l.secret, method, params...)
+}
+
+// verify checks the signature of a URL and the expiry date
+func (l *localStorage) verify(signature, method, expires string, params ...string) error {
+	return verifyURL(l.secret, signature, method, expires, params...)
+}
+
+// signURL creates the signature of a URL for the given method and parameters
+func signURL(secret []byte, method string, params ...string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method))
+	for _, p := range params {
+		mac.Write([]byte("\n" + p))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyURL checks the signature of a URL and the expiry date
+func verifyURL(secret []byte, signature, method, expires string, params ...string) error {
+	if !hmac.Equal([]byte(signURL(secret, method, append(params, expires)...)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func (l *localStorage) DownloadURL(key string, expires time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {l.sign(http.MethodGet, key, expiresAt)},
+	}
+	return fmt.Sprintf("%s/%s?%s", l.baseURL, key, query.Encode()), nil
+}
+
+func (l *localStorage) UploadURL(key, _ string, size int64, expires time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	sizeStr := strconv.FormatInt(size, 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"size":      {sizeStr},
+		"signature": {l.sign(http.MethodPut, key, sizeStr, expiresAt)},
+	}
+	return fmt.Sprintf("%s/%s?%s", l.baseURL, key, query.Encode()), nil
+}
+
+func (l *localStorage) VerifyDownloadSignature(key, expires, signature string) error {
+	return l.verify(signature, http.MethodGet, expires, key)
+}
+
+func (l *localStorage) VerifyUploadSignature(key, expires, size, signature string) error {
+	return l.verify(signature, http.MethodPut, expires, key, size)
+}
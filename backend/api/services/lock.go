@@ -0,0 +1,23 @@
+package services
+
+import (
+	"gorm.io/gorm"
+)
+
+// withAdvisoryLock runs fn if the advisory lock with the given ID can be acquired and releases the lock afterwards.
+// ok is false if the lock is held by another instance. The lock is only supported by PostgreSQL,
+// other databases (SQLite) are used by a single instance and run fn without a lock
+func withAdvisoryLock(db *gorm.DB, lockID int64, fn func() error) (ok bool, err error) {
+	if db.Dialector.Name() != "postgres" {
+		return true, fn()
+	}
+	// session locks must be released by the connection which acquired them
+	err = db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockID).Scan(&ok).Error; err != nil || !ok {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockID)
+		return fn()
+	})
+	return
+}
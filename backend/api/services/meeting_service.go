@@ -1,23 +1,57 @@
 package services
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/lexorank"
 	"github.com/darmiel/perplex/pkg/model"
 	"gorm.io/gorm"
 	"time"
 )
 
+var ErrFollowUpIsMeeting = errors.New("a meeting cannot be its own follow-up meeting")
+
+// CarryOverMode defines what happens to the open topics of a concluded meeting
+type CarryOverMode string
+
+const (
+	// CarryOverMove moves the open topics to the follow-up meeting
+	CarryOverMove CarryOverMode = "move"
+	// CarryOverCopy copies the open topics to the follow-up meeting and keeps them in the concluded meeting
+	CarryOverCopy CarryOverMode = "copy"
+)
+
+// CarriedTopic is an open topic which was carried over to a follow-up meeting
+type CarriedTopic struct {
+	// Original is the topic before it was carried over
+	Original model.Topic `json:"original"`
+	// Topic is the topic in the follow-up meeting (the moved topic or the copy)
+	Topic model.Topic `json:"topic"`
+}
+
 type MeetingService interface {
+	// AddMeeting creates the meeting. The mutations of meetings are audited as performed by the creator (or actor)
 	AddMeeting(projectID uint, creatorUserID, name, description string, startDate, endDate time.Time) (*model.Meeting, error)
 	GetMeeting(meetingID uint) (*model.Meeting, error)
 	FindMeetingsForProject(projectID uint) ([]*model.Meeting, error)
-	DeleteMeeting(meetingID uint) error
-	EditMeeting(meetingID uint, newName, newDescription string, newStartDate, endEndDate time.Time) error
+	DeleteMeeting(meetingID uint, actorID string) error
+	EditMeeting(meetingID uint, newName, newDescription string, newStartDate, endEndDate time.Time, actorID string) error
 	Extend(meeting *model.Meeting, preload ...string) error
-	LinkUser(meetingID uint, userID string) error
-	UnlinkUser(meetingID uint, userID string) error
-	LinkTag(meetingID, tagID uint) error
-	UnlinkTag(meetingID, tagID uint) error
-	SetReady(meetingID uint, ready bool) error
+	LinkUser(meetingID uint, userID, actorID string) error
+	UnlinkUser(meetingID uint, userID, actorID string) error
+	LinkTag(meetingID, tagID uint, actorID string) error
+	UnlinkTag(meetingID, tagID uint, actorID string) error
+	LinkFile(meetingID, fileID uint, actorID string) error
+	UnlinkFile(meetingID, fileID uint, actorID string) error
+	SetReady(meetingID uint, ready bool, actorID string) error
+	// FindMeetingByICalUID returns the meeting imported from the iCalendar event with the UID
+	FindMeetingByICalUID(projectID uint, uid string) (*model.Meeting, error)
+	SetICalUID(meetingID uint, uid string) error
+	// ConcludeMeeting concludes the meeting and carries over its open topics (with comments, tags,
+	// assigned users and linked actions) to the follow-up meeting, which is created if it has no ID yet.
+	// The changes are audited as performed by the actor
+	ConcludeMeeting(meeting, followUp *model.Meeting, mode CarryOverMode, actorID string) ([]CarriedTopic, error)
 }
 
 type meetingService struct {
@@ -32,7 +66,8 @@ func NewMeetingService(db *gorm.DB) MeetingService {
 
 func (m *meetingService) preload() *gorm.DB {
 	return m.DB.Preload("AssignedUsers").
-		Preload("Tags")
+		Preload("Tags").
+		Preload("Files")
 }
 
 func (m *meetingService) AddMeeting(projectID uint, creatorUserID, name, description string, startDate, endDate time.Time) (resp *model.Meeting, err error) {
@@ -44,7 +79,12 @@ func (m *meetingService) AddMeeting(projectID uint, creatorUserID, name, descrip
 		ProjectID:   projectID,
 		CreatorID:   creatorUserID,
 	}
-	err = m.DB.Create(resp).Error
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(resp).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, creatorUserID, model.AuditEntityMeeting, resp.ID, model.AuditActionCreate, nil, resp)
+	})
 	return
 }
 
@@ -63,28 +103,41 @@ func (m *meetingService) FindMeetingsForProject(projectID uint) (resp []*model.M
 	return
 }
 
-func (m *meetingService) DeleteMeeting(meetingID uint) error {
-	res := m.DB.Delete(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
+func (m *meetingService) DeleteMeeting(meetingID uint, actorID string) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var meeting model.Meeting
+		if err := tx.First(&meeting, meetingID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotMatches
+			}
+			return err
+		}
+		res := tx.Delete(&meeting)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected <= 0 {
+			return ErrNotMatches
+		}
+		return auditEntity(tx, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionDelete, &meeting, nil)
 	})
-	if res.Error != nil {
-		return res.Error
-	}
-	if res.RowsAffected <= 0 {
-		return ErrNotMatches
-	}
-	return nil
 }
 
-func (m *meetingService) EditMeeting(meetingID uint, newName, newDescription string, newStartDate, newEndDate time.Time) error {
-	return m.DB.Where("id = ?", meetingID).Updates(&model.Meeting{
-		Name:        newName,
-		Description: newDescription,
-		StartDate:   newStartDate,
-		EndDate:     newEndDate,
-	}).Error
+func (m *meetingService) EditMeeting(
+	meetingID uint,
+	newName, newDescription string,
+	newStartDate, newEndDate time.Time,
+	actorID string,
+) error {
+	return auditChanges[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Where("id = ?", meetingID).Updates(&model.Meeting{
+				Name:        newName,
+				Description: newDescription,
+				StartDate:   newStartDate,
+				EndDate:     newEndDate,
+			}).Error
+		})
 }
 
 func (m *meetingService) Extend(meeting *model.Meeting, preload ...string) error {
@@ -95,62 +148,247 @@ func (m *meetingService) Extend(meeting *model.Meeting, preload ...string) error
 	return q.First(meeting).Error
 }
 
-func (m *meetingService) LinkUser(meetingID uint, userID string) error {
-	return m.DB.Model(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
-	}).
-		Association("AssignedUsers").
-		Append(&model.User{
-			ID: userID,
-		})
+func (m *meetingService) LinkUser(meetingID uint, userID, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionLink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
 }
 
-func (m *meetingService) UnlinkUser(meetingID uint, userID string) error {
-	return m.DB.Model(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
-	}).
-		Association("AssignedUsers").
-		Delete(&model.User{
-			ID: userID,
-		})
+func (m *meetingService) UnlinkUser(meetingID uint, userID, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionUnlink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
 }
 
-func (m *meetingService) LinkTag(meetingID, tagID uint) error {
-	return m.DB.Model(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
-	}).
-		Association("Tags").
-		Append(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
-		})
+func (m *meetingService) LinkTag(meetingID, tagID uint, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionLink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
 }
 
-func (m *meetingService) UnlinkTag(meetingID, tagID uint) error {
-	return m.DB.Model(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
-	}).
-		Association("Tags").
-		Delete(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
+func (m *meetingService) UnlinkTag(meetingID, tagID uint, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionUnlink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
+}
+
+func (m *meetingService) LinkFile(meetingID, fileID uint, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionLink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
+}
+
+func (m *meetingService) UnlinkFile(meetingID, fileID uint, actorID string) error {
+	return auditLink[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionUnlink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
+}
+
+func (m *meetingService) SetReady(meetingID uint, ready bool, actorID string) error {
+	return auditChanges[model.Meeting](m.DB, actorID, model.AuditEntityMeeting, meetingID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Meeting{
+				Model: gorm.Model{
+					ID: meetingID,
+				},
+			}).Update("IsReady", ready).Error
 		})
 }
 
-func (m *meetingService) SetReady(meetingID uint, ready bool) error {
-	return m.DB.Model(&model.Meeting{
-		Model: gorm.Model{
-			ID: meetingID,
-		},
-	}).Update("IsReady", ready).Error
+func (m *meetingService) FindMeetingByICalUID(projectID uint, uid string) (*model.Meeting, error) {
+	var meetings []model.Meeting
+	if err := m.DB.Where("project_id = ? AND ical_uid = ?", projectID, uid).
+		Limit(1).
+		Find(&meetings).Error; err != nil {
+		return nil, err
+	}
+	if len(meetings) == 0 {
+		return nil, ErrNotMatches
+	}
+	return &meetings[0], nil
+}
+
+func (m *meetingService) SetICalUID(meetingID uint, uid string) error {
+	return m.DB.Model(&model.Meeting{}).
+		Where("id = ?", meetingID).
+		Update("ical_uid", uid).Error
+}
+
+// topicAssociations are the join tables of topics which are copied with the topic
+var topicAssociations = []struct {
+	table, column string
+}{
+	{"user_topic_assignments", "user_id"},
+	{"topic_tag_assignments", "tag_id"},
+	{"topic_file_attachments", "project_file_id"},
+	{"topic_user_subscriptions", "user_id"},
+	{"action_topic_assignments", "action_id"},
+	{"topic_action_assignments", "action_id"},
+}
+
+// appendRanks returns n ascending ranks which sort after all topics of the meeting
+func appendRanks(tx *gorm.DB, meetingID uint, n int) ([]lexorank.Rank, error) {
+	var count int64
+	if err := tx.Model(&model.Topic{}).
+		Where("meeting_id = ?", meetingID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	var bottom []lexorank.Rank
+	if err := tx.Model(&model.Topic{}).
+		Where("meeting_id = ?", meetingID).
+		Order("lexo_rank DESC").
+		Limit(1).
+		Pluck("lexo_rank", &bottom).Error; err != nil {
+		return nil, err
+	}
+	// same ranks as topics added one after another
+	ranks := make([]lexorank.Rank, n)
+	for i := range ranks {
+		ranks[i] = lexorank.GetAlphabetForIndex(count + int64(i))
+	}
+	// topics which were reordered may already sort after these ranks
+	if n > 0 && len(bottom) > 0 && ranks[0] <= bottom[0] {
+		for i := range ranks {
+			ranks[i] = bottom[0] + lexorank.GetAlphabetForIndex(int64(i))
+		}
+	}
+	return ranks, nil
+}
+
+// copyTopic copies the topic with its comments and associations to the follow-up meeting
+func copyTopic(tx *gorm.DB, topic model.Topic, followUpID uint, rank lexorank.Rank) (*model.Topic, error) {
+	carriedOverFrom := topic.MeetingID
+	copied := &model.Topic{
+		Title:             topic.Title,
+		Description:       topic.Description,
+		CreatorID:         topic.CreatorID,
+		ForceSolution:     topic.ForceSolution,
+		MeetingID:         followUpID,
+		PriorityID:        topic.PriorityID,
+		LexoRank:          rank,
+		CarriedOverFromID: &carriedOverFrom,
+	}
+	if err := tx.Create(copied).Error; err != nil {
+		return nil, err
+	}
+	for _, assoc := range topicAssociations {
+		if err := tx.Exec(fmt.Sprintf(
+			"INSERT INTO %s (topic_id, %s) SELECT ?, %s FROM %s WHERE topic_id = ?",
+			assoc.table, assoc.column, assoc.column, assoc.table,
+		), copied.ID, topic.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+	var comments []model.Comment
+	if err := tx.Where("topic_id = ?", topic.ID).
+		Order("id").
+		Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		originalID := comment.ID
+		comment.ID = 0
+		comment.TopicID = &copied.ID
+		if err := tx.Omit("Author", "Files").Create(&comment).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Exec(
+			"INSERT INTO comment_file_attachments (comment_id, project_file_id) "+
+				"SELECT ?, project_file_id FROM comment_file_attachments WHERE comment_id = ?",
+			comment.ID, originalID,
+		).Error; err != nil {
+			return nil, err
+		}
+		if topic.SolutionID == originalID {
+			copied.SolutionID = comment.ID
+		}
+	}
+	if copied.SolutionID != 0 {
+		if err := tx.Model(copied).UpdateColumn("solution_id", copied.SolutionID).Error; err != nil {
+			return nil, err
+		}
+	}
+	// leave a reference to the copy on the original topic
+	return copied, tx.Model(&topic).UpdateColumn("carried_over_to_id", copied.ID).Error
+}
+
+func (m *meetingService) ConcludeMeeting(
+	meeting, followUp *model.Meeting,
+	mode CarryOverMode,
+	actorID string,
+) (res []CarriedTopic, err error) {
+	if followUp.ID == meeting.ID {
+		return nil, ErrFollowUpIsMeeting
+	}
+	audit := func(tx *gorm.DB, entityType model.AuditEntityType, entityID uint, action model.AuditAction, before, after any) error {
+		return recordAudit(tx, model.AuditEvent{
+			ProjectID:  meeting.ProjectID,
+			ActorID:    actorID,
+			EntityType: entityType,
+			EntityID:   entityID,
+			Action:     action,
+		}, before, after)
+	}
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		if followUp.ID == 0 {
+			if err := tx.Create(followUp).Error; err != nil {
+				return err
+			}
+			if err := audit(tx, model.AuditEntityMeeting, followUp.ID, model.AuditActionCreate, nil, followUp); err != nil {
+				return err
+			}
+		}
+		q := tx.Where("meeting_id = ? AND closed_at IS NULL", meeting.ID)
+		if mode == CarryOverCopy {
+			// topics which were already copied by a previous conclusion
+			q = q.Where("carried_over_to_id IS NULL")
+		}
+		var topics []model.Topic
+		if err := q.Order("lexo_rank").Find(&topics).Error; err != nil {
+			return err
+		}
+		ranks, err := appendRanks(tx, followUp.ID, len(topics))
+		if err != nil {
+			return err
+		}
+		carriedOverFrom := meeting.ID
+		res = make([]CarriedTopic, len(topics))
+		for i, topic := range topics {
+			res[i].Original = topic
+			if mode == CarryOverCopy {
+				copied, err := copyTopic(tx, topic, followUp.ID, ranks[i])
+				if err != nil {
+					return err
+				}
+				res[i].Topic = *copied
+				if err = audit(tx, model.AuditEntityTopic, copied.ID, model.AuditActionCreate, nil, copied); err != nil {
+					return err
+				}
+				continue
+			}
+			moved := topic
+			moved.MeetingID = followUp.ID
+			moved.LexoRank = ranks[i]
+			moved.CarriedOverFromID = &carriedOverFrom
+			if err := tx.Model(&moved).UpdateColumns(map[string]any{
+				"meeting_id":           moved.MeetingID,
+				"lexo_rank":            moved.LexoRank,
+				"carried_over_from_id": moved.CarriedOverFromID,
+			}).Error; err != nil {
+				return err
+			}
+			res[i].Topic = moved
+			if err = audit(tx, model.AuditEntityTopic, moved.ID, model.AuditActionUpdate, topic, moved); err != nil {
+				return err
+			}
+		}
+		// leave a reference to the follow-up meeting on the concluded meeting
+		before := *meeting
+		followUpID := followUp.ID
+		meeting.ConcludedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		meeting.FollowUpID = &followUpID
+		if err = tx.Model(meeting).UpdateColumns(map[string]any{
+			"concluded_at": meeting.ConcludedAt,
+			"follow_up_id": followUpID,
+		}).Error; err != nil {
+			return err
+		}
+		return audit(tx, model.AuditEntityMeeting, meeting.ID, model.AuditActionUpdate, before, meeting)
+	})
+	return
 }
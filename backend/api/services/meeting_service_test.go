@@ -0,0 +1,166 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/darmiel/perplex/pkg/lexorank"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+)
+
+func newTestMeetingService(t *testing.T) (*gorm.DB, MeetingService) {
+	t.Helper()
+	db := newTestDB(t,
+		new(model.User),
+		new(model.Comment),
+		new(model.Topic),
+		new(model.Meeting),
+		new(model.Priority),
+		new(model.Action),
+		new(model.Tag),
+		new(model.ProjectFile),
+		new(model.AuditEvent),
+	)
+	return db, NewMeetingService(db)
+}
+
+// addTopics adds topics with the given titles and ranks to the meeting. Titles ending with "!" are closed
+func addTopics(t *testing.T, db *gorm.DB, meetingID uint, topics map[string]lexorank.Rank) {
+	t.Helper()
+	for title, rank := range topics {
+		topic := model.Topic{Title: title, MeetingID: meetingID, CreatorID: "u1", LexoRank: rank}
+		if title[len(title)-1] == '!' {
+			topic.ClosedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		if err := db.Create(&topic).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// topicTitles returns the titles of the topics of the meeting in the order of their rank
+func topicTitles(t *testing.T, db *gorm.DB, meetingID uint) []string {
+	t.Helper()
+	var titles []string
+	if err := db.Model(&model.Topic{}).
+		Where("meeting_id = ?", meetingID).
+		Order("lexo_rank").
+		Pluck("title", &titles).Error; err != nil {
+		t.Fatal(err)
+	}
+	return titles
+}
+
+func TestConcludeMeetingOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		mode CarryOverMode
+		// existing are the topics of the follow-up meeting before the conclusion
+		existing map[string]lexorank.Rank
+		// wantFollowUp are the topics of the follow-up meeting after the conclusion
+		wantFollowUp []string
+		// wantConcluded are the topics left in the concluded meeting
+		wantConcluded []string
+	}{
+		{
+			name:          "move to a new meeting",
+			mode:          CarryOverMove,
+			wantFollowUp:  []string{"first", "second", "third"},
+			wantConcluded: []string{"closed!"},
+		},
+		{
+			name:          "copy to a new meeting",
+			mode:          CarryOverCopy,
+			wantFollowUp:  []string{"first", "second", "third"},
+			wantConcluded: []string{"first", "closed!", "second", "third"},
+		},
+		{
+			name:          "move after existing topics",
+			mode:          CarryOverMove,
+			existing:      map[string]lexorank.Rank{"existing 1": "aaaa", "existing 2": "aaab"},
+			wantFollowUp:  []string{"existing 1", "existing 2", "first", "second", "third"},
+			wantConcluded: []string{"closed!"},
+		},
+		{
+			// reordered topics can sort after the ranks of appended topics
+			name:          "move after reordered topics",
+			mode:          CarryOverMove,
+			existing:      map[string]lexorank.Rank{"existing 1": "aaaa", "moved to bottom": "zzzz"},
+			wantFollowUp:  []string{"existing 1", "moved to bottom", "first", "second", "third"},
+			wantConcluded: []string{"closed!"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, srv := newTestMeetingService(t)
+			now := time.Now()
+			meeting, err := srv.AddMeeting(1, "u1", "meeting", "", now, now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			addTopics(t, db, meeting.ID, map[string]lexorank.Rank{
+				"first":   "aaaa",
+				"closed!": "aaab",
+				"second":  "aaac",
+				"third":   "aaad",
+			})
+			followUp := &model.Meeting{Name: "follow-up", ProjectID: 1, CreatorID: "u1", StartDate: now, EndDate: now}
+			if len(tt.existing) > 0 {
+				if followUp, err = srv.AddMeeting(1, "u1", "follow-up", "", now, now); err != nil {
+					t.Fatal(err)
+				}
+				addTopics(t, db, followUp.ID, tt.existing)
+			}
+			carried, err := srv.ConcludeMeeting(meeting, followUp, tt.mode, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(carried) != 3 {
+				t.Fatalf("expected 3 carried topics, got %d", len(carried))
+			}
+			if got := topicTitles(t, db, followUp.ID); !reflect.DeepEqual(got, tt.wantFollowUp) {
+				t.Errorf("expected follow-up topics %v, got %v", tt.wantFollowUp, got)
+			}
+			if got := topicTitles(t, db, meeting.ID); !reflect.DeepEqual(got, tt.wantConcluded) {
+				t.Errorf("expected concluded topics %v, got %v", tt.wantConcluded, got)
+			}
+			var concluded model.Meeting
+			if err = db.First(&concluded, meeting.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if !concluded.ConcludedAt.Valid || concluded.FollowUpID == nil || *concluded.FollowUpID != followUp.ID {
+				t.Errorf("meeting is not concluded with follow-up %d: %+v", followUp.ID, concluded)
+			}
+		})
+	}
+}
+
+func TestConcludeMeetingCopiesOnce(t *testing.T) {
+	db, srv := newTestMeetingService(t)
+	now := time.Now()
+	meeting, err := srv.AddMeeting(1, "u1", "meeting", "", now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTopics(t, db, meeting.ID, map[string]lexorank.Rank{"open": "aaaa"})
+	followUp, err := srv.AddMeeting(1, "u1", "follow-up", "", now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = srv.ConcludeMeeting(meeting, followUp, CarryOverCopy, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// topics which were already copied are not copied again
+	if got := topicTitles(t, db, followUp.ID); !reflect.DeepEqual(got, []string{"open"}) {
+		t.Fatalf("expected a single copy, got %v", got)
+	}
+	if _, err = srv.ConcludeMeeting(meeting, meeting, CarryOverMove, "u1"); !errors.Is(err, ErrFollowUpIsMeeting) {
+		t.Fatalf("expected %v, got %v", ErrFollowUpIsMeeting, err)
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"io"
+)
+
+// ObjectService stores the content of project files deduplicated by their SHA-256 hash.
+// Files with identical content share a single object which is only deleted when
+// the last file or file version referencing it is deleted
+type ObjectService interface {
+	// Store uploads the content to the storage while hashing it and adds a reference to the object.
+	// If an object with the same content already exists, the uploaded copy is deleted
+	// and the existing object is returned
+	Store(body io.Reader, size int64, opts PutOptions) (*model.StoredObject, error)
+	// Adopt copies an object which was uploaded directly to the storage to a new key like Store
+	// and deletes the uploaded object. The uploader may still be able to write to the uploaded key,
+	// so only the copied content is hashed and shared
+	Adopt(key string, size int64, opts PutOptions) (*model.StoredObject, error)
+	// Release removes a reference to the object and deletes the object (and its thumbnail)
+	// if it is no longer referenced
+	Release(key string) error
+}
+
+type objectService struct {
+	DB      *gorm.DB
+	storage StorageService
+}
+
+func NewObjectService(db *gorm.DB, storage StorageService) ObjectService {
+	return &objectService{
+		DB:      db,
+		storage: storage,
+	}
+}
+
+// addReference adds a reference to the object with the given hash. If there is no such object yet,
+// the object stored under key is registered. shared is true if an existing object was referenced
+func addReference(tx *gorm.DB, key, hash string, size int64) (res *model.StoredObject, shared bool, err error) {
+	var existing []model.StoredObject
+	if err = tx.Where("hash = ?", hash).
+		Limit(1).
+		Find(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	if len(existing) > 0 {
+		// the object could have been released concurrently
+		result := tx.Model(&existing[0]).
+			Where("ref_count > 0").
+			UpdateColumn("ref_count", gorm.Expr("ref_count + 1"))
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return &existing[0], true, nil
+		}
+	}
+	res = &model.StoredObject{
+		ObjectKey: key,
+		Hash:      hash,
+		Size:      size,
+		RefCount:  1,
+	}
+	if len(existing) > 0 {
+		// the unreferenced object may be deleted from the storage at any time,
+		// so the row is revived with the uploaded object instead
+		result := tx.Model(&model.StoredObject{}).
+			Where("hash = ? AND ref_count <= 0", hash).
+			UpdateColumns(map[string]any{
+				"object_key": key,
+				"size":       size,
+				"ref_count":  1,
+			})
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return res, false, nil
+		}
+	}
+	if err = tx.Create(res).Error; err != nil {
+		return nil, false, err
+	}
+	return res, false, nil
+}
+
+// releaseReference removes a reference to the object. last is true if the object is no longer referenced
+// and can be deleted from the storage. Objects which were stored before deduplication are not
+// reference counted and are always released
+func releaseReference(tx *gorm.DB, key string) (last bool, err error) {
+	// the row is locked by the update, so concurrent releases cannot both miss the last reference
+	result := tx.Model(&model.StoredObject{}).
+		Where("object_key = ?", key).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return true, nil
+	}
+	var obj model.StoredObject
+	if err = tx.Where("object_key = ?", key).First(&obj).Error; err != nil {
+		return false, err
+	}
+	if obj.RefCount > 0 {
+		return false, nil
+	}
+	return true, tx.Delete(&obj).Error
+}
+
+// forgetObject removes the object from the deduplication without deleting it from the storage,
+// e.g. if the object is missing in the storage and must not be shared with new uploads
+func forgetObject(tx *gorm.DB, key string) error {
+	return tx.Where("object_key = ?", key).Delete(&model.StoredObject{}).Error
+}
+
+// register adds a reference to the object with the given hash and deletes the
+// uploaded object under key if an object with identical content already exists
+func (o *objectService) register(key, hash string, size int64) (*model.StoredObject, error) {
+	var (
+		res    *model.StoredObject
+		shared bool
+	)
+	if err := o.DB.Transaction(func(tx *gorm.DB) (err error) {
+		res, shared, err = addReference(tx, key, hash, size)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	// concurrent registrations of the same upload reference the same key
+	if shared && res.ObjectKey != key {
+		// if the duplicate cannot be deleted, it is removed as orphaned object by the next reconciliation
+		_ = o.storage.Delete(key)
+	}
+	return res, nil
+}
+
+func (o *objectService) Store(body io.Reader, size int64, opts PutOptions) (*model.StoredObject, error) {
+	key, err := NewObjectKey()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if err = o.storage.Put(key, io.TeeReader(body, hash), size, opts); err != nil {
+		return nil, err
+	}
+	res, err := o.register(key, hex.EncodeToString(hash.Sum(nil)), size)
+	if err != nil {
+		_ = o.storage.Delete(key)
+		return nil, err
+	}
+	return res, nil
+}
+
+func (o *objectService) Adopt(key string, size int64, opts PutOptions) (*model.StoredObject, error) {
+	body, _, err := o.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := o.Store(io.LimitReader(body, size), size, opts)
+	_ = body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// if the uploaded object cannot be deleted, it is removed as orphaned object by the next reconciliation
+	_ = o.storage.Delete(key)
+	return res, nil
+}
+
+func (o *objectService) Release(key string) error {
+	var last bool
+	if err := o.DB.Transaction(func(tx *gorm.DB) (err error) {
+		last, err = releaseReference(tx, key)
+		return
+	}); err != nil {
+		return err
+	}
+	if !last {
+		return nil
+	}
+	if err := o.storage.Delete(key); err != nil {
+		return err
+	}
+	return o.storage.Delete(ThumbnailKey(key))
+}
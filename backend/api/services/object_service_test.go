@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB opens an in-memory database with the tables of the models
+func newTestDB(t *testing.T, models ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection opens a new in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestObjectService(t *testing.T) (*gorm.DB, StorageService, ObjectService) {
+	t.Helper()
+	db := newTestDB(t, new(model.StoredObject))
+	t.Setenv("STORAGE_LOCAL_PATH", t.TempDir())
+	storage, err := NewLocalStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, storage, NewObjectService(db, storage)
+}
+
+func storeString(t *testing.T, srv ObjectService, content string) *model.StoredObject {
+	t.Helper()
+	obj, err := srv.Store(strings.NewReader(content), int64(len(content)), PutOptions{})
+	if err != nil {
+		t.Fatalf("cannot store object: %v", err)
+	}
+	return obj
+}
+
+func readString(t *testing.T, storage StorageService, key string) string {
+	t.Helper()
+	body, _, err := storage.Get(key)
+	if err != nil {
+		t.Fatalf("cannot read object %s: %v", key, err)
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestObjectServiceDeduplicates(t *testing.T) {
+	db, storage, srv := newTestObjectService(t)
+	first := storeString(t, srv, "hello")
+	second := storeString(t, srv, "hello")
+	if first.ObjectKey != second.ObjectKey {
+		t.Fatalf("expected shared object, got %s and %s", first.ObjectKey, second.ObjectKey)
+	}
+	var obj model.StoredObject
+	if err := db.First(&obj, "object_key = ?", first.ObjectKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	if obj.RefCount != 2 {
+		t.Fatalf("expected 2 references, got %d", obj.RefCount)
+	}
+	if err := srv.Release(first.ObjectKey); err != nil {
+		t.Fatal(err)
+	}
+	// the object is still referenced by the second file
+	if got := readString(t, storage, first.ObjectKey); got != "hello" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
+
+func TestObjectServiceReuploadAfterRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		// release removes the last reference of the object
+		release func(t *testing.T, db *gorm.DB, srv ObjectService, key string)
+	}{
+		{
+			name: "released",
+			release: func(t *testing.T, _ *gorm.DB, srv ObjectService, key string) {
+				if err := srv.Release(key); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// e.g. a release which was interrupted before the row was deleted
+			name: "unreferenced row left behind",
+			release: func(t *testing.T, db *gorm.DB, _ ObjectService, key string) {
+				if err := db.Model(&model.StoredObject{}).
+					Where("object_key = ?", key).
+					UpdateColumn("ref_count", 0).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, storage, srv := newTestObjectService(t)
+			first := storeString(t, srv, "hello")
+			tt.release(t, db, srv, first.ObjectKey)
+
+			second := storeString(t, srv, "hello")
+			if got := readString(t, storage, second.ObjectKey); got != "hello" {
+				t.Fatalf("unexpected content %q", got)
+			}
+			var objects []model.StoredObject
+			if err := db.Find(&objects).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || objects[0].ObjectKey != second.ObjectKey || objects[0].RefCount != 1 {
+				t.Fatalf("expected a single object %s with one reference, got %+v", second.ObjectKey, objects)
+			}
+			// later uploads share the revived object
+			third := storeString(t, srv, "hello")
+			if third.ObjectKey != second.ObjectKey {
+				t.Fatalf("expected shared object %s, got %s", second.ObjectKey, third.ObjectKey)
+			}
+		})
+	}
+}
+
+func TestObjectServiceAdoptCopiesUpload(t *testing.T) {
+	_, storage, srv := newTestObjectService(t)
+	uploadKey, err := NewObjectKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Put(uploadKey, strings.NewReader("hello"), 5, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := srv.Adopt(uploadKey, 5, PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ObjectKey == uploadKey {
+		t.Fatal("expected the upload to be copied to a new key")
+	}
+	if _, err = storage.Stat(uploadKey); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected the uploaded object to be deleted, got %v", err)
+	}
+	// writes to the upload key don't change the adopted object
+	if err = storage.Put(uploadKey, strings.NewReader("world"), 5, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, storage, obj.ObjectKey); got != "hello" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
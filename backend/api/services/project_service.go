@@ -8,7 +8,8 @@ import (
 )
 
 var (
-	ErrNotMatches = errors.New("no matches found")
+	ErrNotMatches        = errors.New("no matches found")
+	ErrNoPendingTransfer = errors.New("no pending ownership transfer for this user")
 )
 
 type ProjectService interface {
@@ -16,28 +17,47 @@ type ProjectService interface {
 	FindProjectOwnedBy(projectID int, ownerID string) (*model.Project, error)
 	FindProjectsByOwner(userID string) ([]model.Project, error)
 	FindProjectsByUserAccess(userID string) ([]model.Project, error)
-	CreateProject(name, description, ownerID string) (*model.Project, error)
-	DeleteProject(id uint) error
-	AddUser(projectID uint, userID string) error
-	RemoveUser(projectID uint, userID string) error
-	EditProject(id uint, name, description string) error
+	// CreateProject creates a project with the given maximum file size and file quota.
+	// The creation is audited as performed by the owner
+	CreateProject(name, description, ownerID string, maxFileSize, fileQuota int64) (*model.Project, error)
+	// DeleteProject deletes the project. The mutations of projects, their members, tags,
+	// priorities and files are audited as performed by the actor
+	DeleteProject(id uint, actorID string) error
+	AddUser(projectID uint, userID string, role model.ProjectRole, actorID string) error
+	RemoveUser(projectID uint, userID, actorID string) error
+	SetUserRole(projectID uint, userID string, role model.ProjectRole, actorID string) error
+	SetPendingOwner(projectID uint, userID *string, actorID string) error
+	// TransferOwnership makes the pending owner the new owner of the project.
+	// The transfer is audited as performed by the new owner
+	TransferOwnership(projectID uint, newOwnerID string) error
+	EditProject(id uint, name, description, actorID string) error
 	Extend(project *model.Project, preload ...string) error
 	FindTag(tagID uint) (*model.Tag, error)
 	FindTagsByProject(projectID uint) ([]model.Tag, error)
-	CreateTag(title, color string, projectID uint) (*model.Tag, error)
-	DeleteTag(tagID uint) error
-	EditTag(tagID uint, title, color string) error
+	CreateTag(title, color string, projectID uint, actorID string) (*model.Tag, error)
+	DeleteTag(tagID uint, actorID string) error
+	EditTag(tagID uint, title, color, actorID string) error
 	FindPriority(priorityID uint) (*model.Priority, error)
 	FindPrioritiesByProject(projectID uint) ([]model.Priority, error)
-	CreatePriority(title, color string, weight int, projectID uint) (*model.Priority, error)
-	DeletePriority(priorityID uint) error
-	EditPriority(priorityID uint, title, color string, weight int) error
-	CreateFile(projectID uint, file model.ProjectFile) error
+	CreatePriority(title, color string, weight int, projectID uint, actorID string) (*model.Priority, error)
+	DeletePriority(priorityID uint, actorID string) error
+	EditPriority(priorityID uint, title, color string, weight int, actorID string) error
+	// CreateFile creates the file in the project. The creation is audited as performed by the creator of the file
+	CreateFile(projectID uint, file *model.ProjectFile) error
 	FindFile(projectID uint, fileID uint) (*model.ProjectFile, error)
 	FindFiles(projectID uint) ([]model.ProjectFile, error)
-	DeleteFile(projectID uint, fileID uint) error
+	// FindFilesByIDs returns the files of the project with the given IDs
+	FindFilesByIDs(projectID uint, fileIDs []uint) ([]model.ProjectFile, error)
+	// FindMeetingFiles returns the files attached to the meeting of the project
+	FindMeetingFiles(projectID uint, meetingID uint) ([]model.ProjectFile, error)
+	DeleteFile(projectID uint, fileID uint, actorID string) error
 	GetTotalProjectFileSize(projectID uint) (*uint64, error)
 	UpdateFileAccess(fileID uint) error
+	// UpdateObjectScan stores the result of a malware scan of the object in all files and versions
+	// with the object, since files with identical content share their object.
+	// An unscanned result (e.g. if the scanner is not reachable) does not replace the result of an earlier scan.
+	// The changed files are audited as performed by the actor
+	UpdateObjectScan(objectKey string, scan ScanResult, actorID string) error
 }
 
 type projectService struct {
@@ -73,13 +93,20 @@ func (p *projectService) FindProjectOwnedBy(projectID int, ownerID string) (res
 	return projects[0], nil
 }
 
-func (p *projectService) CreateProject(name, description, ownerID string) (res *model.Project, err error) {
+func (p *projectService) CreateProject(name, description, ownerID string, maxFileSize, fileQuota int64) (res *model.Project, err error) {
 	res = &model.Project{
-		Name:        name,
-		Description: description,
-		OwnerID:     ownerID,
+		Name:                 name,
+		Description:          description,
+		OwnerID:              ownerID,
+		MaxProjectFileSize:   maxFileSize,
+		ProjectFileSizeQuota: fileQuota,
 	}
-	err = p.DB.Create(res).Error
+	err = p.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(res).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, ownerID, model.AuditEntityProject, res.ID, model.AuditActionCreate, nil, res)
+	})
 	return
 }
 
@@ -102,26 +129,34 @@ func (p *projectService) FindProjectsByUserAccess(userID string) ([]model.Projec
 	return user[0].UserProjects, nil
 }
 
-func (p *projectService) DeleteProject(id uint) error {
-	res := p.DB.Delete(&model.Project{
-		Model: gorm.Model{
-			ID: id,
-		},
+func (p *projectService) DeleteProject(id uint, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var project model.Project
+		if err := tx.First(&project, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotMatches
+			}
+			return err
+		}
+		res := tx.Delete(&project)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected <= 0 {
+			return ErrNotMatches
+		}
+		return auditEntity(tx, actorID, model.AuditEntityProject, id, model.AuditActionDelete, &project, nil)
 	})
-	if res.Error != nil {
-		return res.Error
-	}
-	if res.RowsAffected <= 0 {
-		return ErrNotMatches
-	}
-	return nil
 }
 
-func (p *projectService) EditProject(id uint, name, description string) error {
-	return p.DB.Where("id = ?", id).Updates(&model.Project{
-		Name:        name,
-		Description: description,
-	}).Error
+func (p *projectService) EditProject(id uint, name, description, actorID string) error {
+	return auditChanges[model.Project](p.DB, actorID, model.AuditEntityProject, id, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Where("id = ?", id).Updates(&model.Project{
+				Name:        name,
+				Description: description,
+			}).Error
+		})
 }
 
 func (p *projectService) Extend(project *model.Project, preload ...string) error {
@@ -132,32 +167,152 @@ func (p *projectService) Extend(project *model.Project, preload ...string) error
 	return q.First(project).Error
 }
 
-func (p *projectService) AddUser(projectID uint, userID string) error {
-	var project model.Project
-	if err := p.DB.Preload("Users").First(&project, projectID).Error; err != nil {
-		return err
-	}
-	var user model.User
-	if err := p.DB.First(&user, &model.User{
-		ID: userID,
-	}).Error; err != nil {
-		return err
-	}
-	return p.DB.Model(&project).Association("Users").Append(&user)
+// auditMember records a change of the members of the project.
+// The changed member is stored as the affected user of the event
+func auditMember(
+	tx *gorm.DB,
+	projectID uint,
+	userID, actorID string,
+	action model.AuditAction,
+	before, after any,
+) error {
+	return recordAudit(tx, model.AuditEvent{
+		ProjectID:  projectID,
+		ActorID:    actorID,
+		EntityType: model.AuditEntityMember,
+		EntityID:   projectID,
+		UserID:     userID,
+		Action:     action,
+	}, before, after)
 }
 
-func (p *projectService) RemoveUser(projectID uint, userID string) error {
-	var project model.Project
-	if err := p.DB.Preload("Users").First(&project, projectID).Error; err != nil {
-		return err
+// memberRole returns the role of the member of the project
+func memberRole(tx *gorm.DB, projectID uint, userID string) (model.ProjectRole, error) {
+	var member model.ProjectMember
+	if err := tx.Where("project_id = ? AND user_id = ?", projectID, userID).
+		First(&member).Error; err != nil {
+		return "", err
 	}
-	var user model.User
-	if err := p.DB.First(&user, &model.User{
-		ID: userID,
-	}).Error; err != nil {
-		return err
-	}
-	return p.DB.Model(&project).Association("Users").Delete(&user)
+	return member.Role, nil
+}
+
+func (p *projectService) AddUser(projectID uint, userID string, role model.ProjectRole, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var project model.Project
+		if err := tx.First(&project, projectID).Error; err != nil {
+			return err
+		}
+		var user model.User
+		if err := tx.First(&user, &model.User{
+			ID: userID,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&model.ProjectMember{
+			ProjectID: project.ID,
+			UserID:    user.ID,
+			Role:      role,
+		}).Error; err != nil {
+			return err
+		}
+		return auditMember(tx, projectID, userID, actorID, model.AuditActionCreate,
+			nil, map[string]any{"role": role})
+	})
+}
+
+func (p *projectService) RemoveUser(projectID uint, userID, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var project model.Project
+		if err := tx.Preload("Users").First(&project, projectID).Error; err != nil {
+			return err
+		}
+		var user model.User
+		if err := tx.First(&user, &model.User{
+			ID: userID,
+		}).Error; err != nil {
+			return err
+		}
+		role, err := memberRole(tx, projectID, userID)
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(&project).Association("Users").Delete(&user); err != nil {
+			return err
+		}
+		if err = auditMember(tx, projectID, userID, actorID, model.AuditActionDelete,
+			map[string]any{"role": role}, nil); err != nil {
+			return err
+		}
+		// users which are no longer in the project cannot become the owner
+		if project.PendingOwnerID != nil && *project.PendingOwnerID == userID {
+			return NewProjectService(tx).SetPendingOwner(projectID, nil, actorID)
+		}
+		return nil
+	})
+}
+
+func (p *projectService) SetUserRole(projectID uint, userID string, role model.ProjectRole, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := memberRole(tx, projectID, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotMatches
+			}
+			return err
+		}
+		if err = tx.Model(&model.ProjectMember{}).
+			Where("project_id = ? AND user_id = ?", projectID, userID).
+			Update("role", role).Error; err != nil {
+			return err
+		}
+		return auditMember(tx, projectID, userID, actorID, model.AuditActionUpdate,
+			map[string]any{"role": before}, map[string]any{"role": role})
+	})
+}
+
+func (p *projectService) SetPendingOwner(projectID uint, userID *string, actorID string) error {
+	return auditChanges[model.Project](p.DB, actorID, model.AuditEntityProject, projectID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Project{}).
+				Where("id = ?", projectID).
+				Update("pending_owner_id", userID).
+				Error
+		})
+}
+
+// TransferOwnership makes the pending owner the new owner of the project.
+// The previous owner stays in the project as an admin
+func (p *projectService) TransferOwnership(projectID uint, newOwnerID string) error {
+	return auditChanges[model.Project](p.DB, newOwnerID, model.AuditEntityProject, projectID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			var project model.Project
+			if err := tx.First(&project, projectID).Error; err != nil {
+				return err
+			}
+			if project.PendingOwnerID == nil || *project.PendingOwnerID != newOwnerID {
+				return ErrNoPendingTransfer
+			}
+			// the new owner is no longer a member, the old owner becomes one
+			res := tx.Where("project_id = ? AND user_id = ?", projectID, newOwnerID).
+				Delete(&model.ProjectMember{})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected <= 0 {
+				return ErrNoPendingTransfer
+			}
+			if err := tx.Create(&model.ProjectMember{
+				ProjectID: projectID,
+				UserID:    project.OwnerID,
+				Role:      model.RoleAdmin,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&project).Updates(map[string]any{
+				"owner_id":         newOwnerID,
+				"pending_owner_id": nil,
+			}).Error
+		})
 }
 
 // Tags
@@ -180,35 +335,48 @@ func (p *projectService) FindTagsByProject(projectID uint) ([]model.Tag, error)
 	return tags, nil
 }
 
-func (p *projectService) CreateTag(title, color string, projectID uint) (*model.Tag, error) {
+func (p *projectService) CreateTag(title, color string, projectID uint, actorID string) (*model.Tag, error) {
 	tag := model.Tag{
 		Title:     title,
 		Color:     color,
 		ProjectID: projectID,
 	}
-	if err := p.DB.Create(&tag).Error; err != nil {
+	if err := p.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tag).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityTag, tag.ID, model.AuditActionCreate, nil, &tag)
+	}); err != nil {
 		return nil, err
 	}
 	return &tag, nil
 }
 
-func (p *projectService) DeleteTag(tagID uint) error {
-	// delete tag | TODO: delete all relations with this tag
-	return p.DB.Delete(&model.Tag{
-		Model: gorm.Model{
-			ID: tagID,
-		},
-	}).Error
+func (p *projectService) DeleteTag(tagID uint, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var tag model.Tag
+		if err := tx.First(&tag, tagID).Error; err != nil {
+			return err
+		}
+		// delete tag | TODO: delete all relations with this tag
+		if err := tx.Delete(&tag).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityTag, tagID, model.AuditActionDelete, &tag, nil)
+	})
 }
 
-func (p *projectService) EditTag(tagID uint, title, color string) error {
-	return p.DB.Updates(&model.Tag{
-		Model: gorm.Model{
-			ID: tagID,
-		},
-		Title: title,
-		Color: color,
-	}).Error
+func (p *projectService) EditTag(tagID uint, title, color, actorID string) error {
+	return auditChanges[model.Tag](p.DB, actorID, model.AuditEntityTag, tagID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Updates(&model.Tag{
+				Model: gorm.Model{
+					ID: tagID,
+				},
+				Title: title,
+				Color: color,
+			}).Error
+		})
 }
 
 // Priorities
@@ -231,44 +399,62 @@ func (p *projectService) FindPrioritiesByProject(projectID uint) ([]model.Priori
 	return priorities, nil
 }
 
-func (p *projectService) CreatePriority(title, color string, weight int, projectID uint) (*model.Priority, error) {
+func (p *projectService) CreatePriority(title, color string, weight int, projectID uint, actorID string) (*model.Priority, error) {
 	priority := model.Priority{
 		Title:     title,
 		Color:     color,
 		Weight:    weight,
 		ProjectID: projectID,
 	}
-	if err := p.DB.Create(&priority).Error; err != nil {
+	if err := p.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&priority).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityPriority, priority.ID, model.AuditActionCreate, nil, &priority)
+	}); err != nil {
 		return nil, err
 	}
 	return &priority, nil
 }
 
-func (p *projectService) DeletePriority(priorityID uint) error {
-	// find all actions with this tag and remove it
-	return p.DB.Delete(&model.Priority{
-		Model: gorm.Model{
-			ID: priorityID,
-		},
-	}).Error
+func (p *projectService) DeletePriority(priorityID uint, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var priority model.Priority
+		if err := tx.First(&priority, priorityID).Error; err != nil {
+			return err
+		}
+		// find all actions with this tag and remove it
+		if err := tx.Delete(&priority).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityPriority, priorityID, model.AuditActionDelete, &priority, nil)
+	})
 }
 
-func (p *projectService) EditPriority(priorityID uint, title, color string, weight int) error {
-	return p.DB.Updates(&model.Priority{
-		Model: gorm.Model{
-			ID: priorityID,
-		},
-		Title:  title,
-		Weight: weight,
-		Color:  color,
-	}).Error
+func (p *projectService) EditPriority(priorityID uint, title, color string, weight int, actorID string) error {
+	return auditChanges[model.Priority](p.DB, actorID, model.AuditEntityPriority, priorityID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Updates(&model.Priority{
+				Model: gorm.Model{
+					ID: priorityID,
+				},
+				Title:  title,
+				Weight: weight,
+				Color:  color,
+			}).Error
+		})
 }
 
 // Files
 
-func (p *projectService) CreateFile(projectID uint, file model.ProjectFile) error {
+func (p *projectService) CreateFile(projectID uint, file *model.ProjectFile) error {
 	file.ProjectID = projectID
-	return p.DB.Create(&file).Error
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, file.CreatorID, model.AuditEntityFile, file.ID, model.AuditActionCreate, nil, file)
+	})
 }
 
 func (p *projectService) FindFile(projectID uint, fileID uint) (*model.ProjectFile, error) {
@@ -294,31 +480,130 @@ func (p *projectService) FindFiles(projectID uint) ([]model.ProjectFile, error)
 	return files, nil
 }
 
-func (p *projectService) DeleteFile(projectID uint, fileID uint) error {
-	return p.DB.Delete(&model.ProjectFile{
-		Model: gorm.Model{
-			ID: fileID,
-		},
-		ProjectID: projectID,
-	}).Error
+func (p *projectService) FindFilesByIDs(projectID uint, fileIDs []uint) ([]model.ProjectFile, error) {
+	var files []model.ProjectFile
+	if err := p.DB.Where("project_id = ? AND id IN ?", projectID, fileIDs).
+		Order("id").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (p *projectService) FindMeetingFiles(projectID uint, meetingID uint) ([]model.ProjectFile, error) {
+	var files []model.ProjectFile
+	if err := p.DB.Joins("JOIN meeting_file_attachments ON meeting_file_attachments.project_file_id = project_files.id").
+		Joins("JOIN meetings ON meetings.id = meeting_file_attachments.meeting_id AND meetings.deleted_at IS NULL").
+		Where("meetings.id = ? AND meetings.project_id = ? AND project_files.project_id = ?", meetingID, projectID, projectID).
+		Order("project_files.id").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// fileAttachmentTables contains the join tables of the entities files can be attached to
+var fileAttachmentTables = []string{
+	"topic_file_attachments",
+	"action_file_attachments",
+	"meeting_file_attachments",
+	"comment_file_attachments",
+}
+
+// detachFile removes the file from all topics, actions, meetings and comments
+func detachFile(tx *gorm.DB, fileID uint) error {
+	for _, table := range fileAttachmentTables {
+		if err := tx.Table(table).
+			Where("project_file_id = ?", fileID).
+			Delete(nil).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *projectService) DeleteFile(projectID uint, fileID uint, actorID string) error {
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var file model.ProjectFile
+		if err := tx.Where("id = ? AND project_id = ?", fileID, projectID).First(&file).Error; err != nil {
+			return err
+		}
+		if err := detachFile(tx, fileID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&file).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityFile, fileID, model.AuditActionDelete, &file, nil)
+	})
 }
 
 func (p *projectService) GetTotalProjectFileSize(projectID uint) (*uint64, error) {
-	var size *uint64
-	if err := p.DB.Model(&model.ProjectFile{}).
-		Where("project_id = ?", projectID).
-		Select("sum(size)").
-		Scan(&size).Error; err != nil {
+	// previous versions of files count towards the total size
+	size, err := storedSize(p.DB, projectID)
+	if err != nil {
 		return nil, err
 	}
-	return size, nil
+	return &size, nil
 }
 
 func (p *projectService) UpdateFileAccess(fileID uint) error {
-	// set last accessed date to now and increment access count
+	// set last accessed date to now and increment access count.
+	// accessing a file does not change the file, so updated_at is not touched
 	return p.DB.Model(&model.ProjectFile{}).
 		Where("id = ?", fileID).
-		Update("access_count", gorm.Expr("access_count + 1")).
-		Update("last_accessed", time.Now()).
+		UpdateColumns(map[string]any{
+			"access_count":     gorm.Expr("access_count + 1"),
+			"last_accessed_at": time.Now(),
+		}).
 		Error
 }
+
+func (p *projectService) UpdateObjectScan(objectKey string, scan ScanResult, actorID string) error {
+	columns := map[string]any{
+		"scan_status":    scan.Status,
+		"scan_signature": scan.Signature,
+	}
+	// scanned rows keep their result if the object could not be scanned
+	scope := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("object_key = ?", objectKey)
+		if scan.Status == model.ScanStatusUnscanned {
+			tx = tx.Where("scan_status = ?", model.ScanStatusUnscanned)
+		}
+		return tx
+	}
+	return p.DB.Transaction(func(tx *gorm.DB) error {
+		var files []model.ProjectFile
+		if err := tx.Scopes(scope).Find(&files).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.ProjectFile{}).
+			Scopes(scope).
+			UpdateColumns(columns).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.ProjectFileVersion{}).
+			Scopes(scope).
+			UpdateColumns(columns).Error; err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.ScanStatus == scan.Status && file.ScanSignature == scan.Signature {
+				continue
+			}
+			if err := recordAudit(tx, model.AuditEvent{
+				ProjectID:  file.ProjectID,
+				ActorID:    actorID,
+				EntityType: model.AuditEntityFile,
+				EntityID:   file.ID,
+				Action:     model.AuditActionUpdate,
+			}, map[string]any{
+				"scan_status":    file.ScanStatus,
+				"scan_signature": file.ScanSignature,
+			}, columns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
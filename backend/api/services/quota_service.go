@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoUserQuotaLeft    = errors.New("no file quota of the user left")
+	ErrQuotaAboveLimit    = errors.New("quota exceeds the limit of the instance")
+	ErrInvalidQuotaConfig = errors.New("invalid quota configuration")
+)
+
+const (
+	// defaultMaxFileSize is the default maximum size of a single file (100 MiB)
+	defaultMaxFileSize = 100 << 20
+	// defaultProjectQuota is the default quota of a project (1 GiB)
+	defaultProjectQuota = 1 << 30
+)
+
+// QuotaConfig is the instance wide quota configuration. Negative values are unlimited
+type QuotaConfig struct {
+	// DefaultMaxFileSize is the maximum file size of new projects
+	DefaultMaxFileSize int64 `json:"default_max_file_size"`
+	// DefaultProjectQuota is the quota of new projects
+	DefaultProjectQuota int64 `json:"default_project_quota"`
+	// DefaultUserQuota is the quota of users across all projects if no quota is set for the user
+	DefaultUserQuota int64 `json:"default_user_quota"`
+	// MaxFileSizeLimit is the highest maximum file size which can be set for a project
+	MaxFileSizeLimit int64 `json:"max_file_size_limit"`
+	// ProjectQuotaLimit is the highest quota which can be set for a project
+	ProjectQuotaLimit int64 `json:"project_quota_limit"`
+}
+
+// NewQuotaConfig reads the quota configuration from QUOTA_DEFAULT_MAX_FILE_SIZE (default 100 MiB),
+// QUOTA_DEFAULT_PROJECT (default 1 GiB), QUOTA_DEFAULT_USER (default unlimited), QUOTA_MAX_FILE_SIZE_LIMIT
+// and QUOTA_PROJECT_LIMIT (all sizes in bytes, -1 means unlimited). The limits default to the project defaults,
+// so projects can only be given more space by the operator of the instance
+func NewQuotaConfig() (QuotaConfig, error) {
+	cfg := QuotaConfig{
+		DefaultMaxFileSize:  defaultMaxFileSize,
+		DefaultProjectQuota: defaultProjectQuota,
+		DefaultUserQuota:    -1,
+	}
+	if err := readQuotaEnv(map[string]*int64{
+		"QUOTA_DEFAULT_MAX_FILE_SIZE": &cfg.DefaultMaxFileSize,
+		"QUOTA_DEFAULT_PROJECT":       &cfg.DefaultProjectQuota,
+		"QUOTA_DEFAULT_USER":          &cfg.DefaultUserQuota,
+	}); err != nil {
+		return cfg, err
+	}
+	// the limits default to the (configured) defaults of new projects
+	cfg.MaxFileSizeLimit = cfg.DefaultMaxFileSize
+	cfg.ProjectQuotaLimit = cfg.DefaultProjectQuota
+	if err := readQuotaEnv(map[string]*int64{
+		"QUOTA_MAX_FILE_SIZE_LIMIT": &cfg.MaxFileSizeLimit,
+		"QUOTA_PROJECT_LIMIT":       &cfg.ProjectQuotaLimit,
+	}); err != nil {
+		return cfg, err
+	}
+	// the defaults of new projects must be allowed by the limits
+	if !withinLimit(cfg.DefaultMaxFileSize, cfg.MaxFileSizeLimit) ||
+		!withinLimit(cfg.DefaultProjectQuota, cfg.ProjectQuotaLimit) {
+		return cfg, fmt.Errorf("%w: project defaults exceed the limits", ErrInvalidQuotaConfig)
+	}
+	return cfg, nil
+}
+
+// readQuotaEnv reads the sizes from the environment variables which are set
+func readQuotaEnv(vars map[string]*int64) error {
+	for env, value := range vars {
+		raw, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidQuotaConfig, env, err)
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+// withinLimit checks if the (possibly unlimited) value does not exceed the (possibly unlimited) limit
+func withinLimit(value, limit int64) bool {
+	if limit < 0 {
+		return true
+	}
+	return value >= 0 && value <= limit
+}
+
+// UserQuotaInfo is the quota of a user across all projects
+type UserQuotaInfo struct {
+	// Quota is the quota of the user (negative if unlimited)
+	Quota int64 `json:"quota"`
+	// Used is the size of all files, previous versions and pending uploads of the user
+	Used uint64 `json:"used"`
+	// Remaining is the size the user can still upload (negative if unlimited)
+	Remaining int64 `json:"remaining"`
+}
+
+// QuotaService manages the file quotas of projects and users
+type QuotaService interface {
+	Config() QuotaConfig
+	// SetProjectQuota changes the maximum file size and quota of the project.
+	// The values must not exceed the limits of the instance. The change is audited as performed by the actor
+	SetProjectQuota(projectID uint, maxFileSize, quota int64, actorID string) error
+	// OverrideProjectQuota changes the maximum file size and quota of the project regardless of the limits
+	// of the instance. It is only used by the operator of the instance, so the change is audited without an actor
+	OverrideProjectQuota(projectID uint, maxFileSize, quota int64) error
+	// ProjectQuota returns the maximum file size and quota of the project
+	ProjectQuota(projectID uint) (maxFileSize, quota int64, err error)
+	// UserQuota returns the quota of the user across all projects (negative if unlimited)
+	UserQuota(userID string) (int64, error)
+	// SetUserQuota sets the quota of the user. If quota is nil, the default quota of the instance is used
+	SetUserQuota(userID string, quota *int64) error
+	UserQuotaInfo(userID string) (*UserQuotaInfo, error)
+}
+
+type quotaService struct {
+	DB     *gorm.DB
+	config QuotaConfig
+}
+
+func NewQuotaService(db *gorm.DB, config QuotaConfig) QuotaService {
+	return &quotaService{
+		DB:     db,
+		config: config,
+	}
+}
+
+// storedSizeByUser returns the size of all files and previous versions uploaded by the user.
+// Identical content is only counted once
+func storedSizeByUser(tx *gorm.DB, userID string) (uint64, error) {
+	var size *uint64
+	// the current version of a file was uploaded by the version creator if the file has multiple versions
+	if err := tx.Raw(`SELECT sum(size) FROM (
+		SELECT object_key, size FROM project_files WHERE deleted_at IS NULL
+			AND (version_creator_id = ? OR ((version_creator_id = '' OR version_creator_id IS NULL) AND creator_id = ?))
+		UNION
+		SELECT object_key, size FROM project_file_versions WHERE creator_id = ? AND deleted_at IS NULL
+	) AS objects`, userID, userID, userID).
+		Scan(&size).Error; err != nil {
+		return 0, err
+	}
+	if size == nil {
+		return 0, nil
+	}
+	return *size, nil
+}
+
+// usedUserQuota returns the size of all files (including previous versions), active upload reservations
+// and already received bytes of resumable uploads of the user
+func usedUserQuota(tx *gorm.DB, userID string) (uint64, error) {
+	total, err := storedSizeByUser(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	var reserved, partial *uint64
+	if err := tx.Model(&model.ProjectFileUpload{}).
+		Where("creator_id = ? AND expires_at > ?", userID, time.Now()).
+		Select("sum(size)").
+		Scan(&reserved).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&model.ProjectFileTusUpload{}).
+		Where("creator_id = ? AND expires_at > ?", userID, time.Now()).
+		Select("sum(upload_offset)").
+		Scan(&partial).Error; err != nil {
+		return 0, err
+	}
+	for _, size := range []*uint64{reserved, partial} {
+		if size != nil {
+			total += *size
+		}
+	}
+	return total, nil
+}
+
+// checkUserQuotaLeft checks if the user has enough quota left for the given amount of bytes
+func checkUserQuotaLeft(tx *gorm.DB, quotaSrv QuotaService, userID string, size int64) error {
+	quota, err := quotaSrv.UserQuota(userID)
+	if err != nil {
+		return err
+	}
+	if quota < 0 {
+		return nil
+	}
+	used, err := usedUserQuota(tx, userID)
+	if err != nil {
+		return err
+	}
+	if used+uint64(size) > uint64(quota) {
+		return ErrNoUserQuotaLeft
+	}
+	return nil
+}
+
+func (q *quotaService) Config() QuotaConfig {
+	return q.config
+}
+
+func (q *quotaService) SetProjectQuota(projectID uint, maxFileSize, quota int64, actorID string) error {
+	if !withinLimit(maxFileSize, q.config.MaxFileSizeLimit) || !withinLimit(quota, q.config.ProjectQuotaLimit) {
+		return ErrQuotaAboveLimit
+	}
+	return q.setProjectQuota(projectID, maxFileSize, quota, actorID)
+}
+
+func (q *quotaService) OverrideProjectQuota(projectID uint, maxFileSize, quota int64) error {
+	return q.setProjectQuota(projectID, maxFileSize, quota, "")
+}
+
+func (q *quotaService) setProjectQuota(projectID uint, maxFileSize, quota int64, actorID string) error {
+	return auditChanges[model.Project](q.DB, actorID, model.AuditEntityProject, projectID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Project{}).
+				Where("id = ?", projectID).
+				Updates(map[string]any{
+					"max_project_file_size":   maxFileSize,
+					"project_file_size_quota": quota,
+				}).Error
+		})
+}
+
+func (q *quotaService) ProjectQuota(projectID uint) (maxFileSize, quota int64, err error) {
+	var project model.Project
+	if err = q.DB.Select("max_project_file_size", "project_file_size_quota").
+		First(&project, projectID).Error; err != nil {
+		return 0, 0, err
+	}
+	return project.MaxProjectFileSize, project.ProjectFileSizeQuota, nil
+}
+
+func (q *quotaService) UserQuota(userID string) (int64, error) {
+	var users []model.User
+	if err := q.DB.Where("id = ?", userID).
+		Limit(1).
+		Find(&users).Error; err != nil {
+		return 0, err
+	}
+	if len(users) == 0 || users[0].FileSizeQuota == nil {
+		return q.config.DefaultUserQuota, nil
+	}
+	return *users[0].FileSizeQuota, nil
+}
+
+func (q *quotaService) SetUserQuota(userID string, quota *int64) error {
+	result := q.DB.Model(&model.User{}).
+		Where("id = ?", userID).
+		Update("file_size_quota", quota)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (q *quotaService) UserQuotaInfo(userID string) (*UserQuotaInfo, error) {
+	quota, err := q.UserQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	used, err := usedUserQuota(q.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &UserQuotaInfo{
+		Quota:     quota,
+		Used:      used,
+		Remaining: -1,
+	}
+	if quota >= 0 {
+		res.Remaining = quota - int64(used)
+		if res.Remaining < 0 {
+			res.Remaining = 0
+		}
+	}
+	return res, nil
+}
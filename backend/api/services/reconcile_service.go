@@ -0,0 +1,288 @@
+package services
+
+import (
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// uploadPrefix is the prefix of all object keys of project files
+const uploadPrefix = "uploads/"
+
+// ReconcileOptions configures a reconciliation run
+type ReconcileOptions struct {
+	// DryRun only reports inconsistencies without changing the storage or database
+	DryRun bool
+	// MinAge is the minimum age of objects and rows which are considered.
+	// Younger objects could belong to uploads which are still in progress
+	MinAge time.Duration
+}
+
+// ReconcileReport contains the inconsistencies found by a reconciliation run
+type ReconcileReport struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// ScannedObjects is the number of objects found in the storage
+	ScannedObjects int `json:"scanned_objects"`
+	// OrphanedObjects are keys of objects which are not referenced by any row
+	OrphanedObjects []string `json:"orphaned_objects"`
+	// OrphanedSize is the total size of all orphaned objects in bytes
+	OrphanedSize int64 `json:"orphaned_size"`
+	// DanglingFiles are IDs of files whose object does not exist
+	DanglingFiles []uint `json:"dangling_files"`
+	// DanglingVersions are IDs of file versions whose object does not exist
+	DanglingVersions []uint `json:"dangling_versions"`
+	// DeletedProjectFiles are IDs of files which belong to deleted projects
+	DeletedProjectFiles []uint `json:"deleted_project_files"`
+	// Errors are errors which occurred while removing inconsistencies
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ReconcileService finds and removes inconsistencies between the stored objects and the project files
+type ReconcileService interface {
+	Reconcile(opts ReconcileOptions) (*ReconcileReport, error)
+}
+
+type reconcileService struct {
+	DB      *gorm.DB
+	storage StorageService
+}
+
+func NewReconcileService(db *gorm.DB, storage StorageService) ReconcileService {
+	return &reconcileService{
+		DB:      db,
+		storage: storage,
+	}
+}
+
+// deletedProjects returns a sub query for the IDs of all deleted projects
+func (r *reconcileService) deletedProjects() *gorm.DB {
+	return r.DB.Unscoped().
+		Model(&model.Project{}).
+		Where("deleted_at IS NOT NULL").
+		Select("id")
+}
+
+// knownKeys returns all object keys which are referenced by files of active projects,
+// their versions and upload reservations
+func (r *reconcileService) knownKeys() (map[string]struct{}, error) {
+	known := make(map[string]struct{})
+	add := func(keys ...string) {
+		for _, key := range keys {
+			if key != "" {
+				known[key] = struct{}{}
+			}
+		}
+	}
+	var files []model.ProjectFile
+	if err := r.DB.Where("project_id NOT IN (?)", r.deletedProjects()).
+		Select("id", "object_key", "thumbnail_key").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	fileIDs := make(map[uint]struct{}, len(files))
+	for _, f := range files {
+		add(f.ObjectKey, f.ThumbnailKey)
+		fileIDs[f.ID] = struct{}{}
+	}
+	var versions []model.ProjectFileVersion
+	if err := r.DB.Select("file_id", "object_key", "thumbnail_key").
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if _, ok := fileIDs[v.FileID]; ok {
+			add(v.ObjectKey, v.ThumbnailKey)
+		}
+	}
+	var uploads []model.ProjectFileUpload
+	if err := r.DB.Select("object_key").
+		Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range uploads {
+		add(u.ObjectKey)
+	}
+	return known, nil
+}
+
+// oldestUpload returns the creation time of the oldest upload reservation or tus upload (zero if there are none).
+// Completing an upload stores the object before the file is created, so objects younger than a
+// pending upload may belong to a file which is not committed yet
+func (r *reconcileService) oldestUpload() (time.Time, error) {
+	var oldest time.Time
+	for _, m := range []any{&model.ProjectFileUpload{}, &model.ProjectFileTusUpload{}} {
+		var uploads []struct {
+			CreatedAt time.Time
+		}
+		if err := r.DB.Unscoped().
+			Model(m).
+			Select("created_at").
+			Order("created_at").
+			Limit(1).
+			Find(&uploads).Error; err != nil {
+			return time.Time{}, err
+		}
+		if len(uploads) > 0 && (oldest.IsZero() || uploads[0].CreatedAt.Before(oldest)) {
+			oldest = uploads[0].CreatedAt
+		}
+	}
+	return oldest, nil
+}
+
+// deleteFileRows deletes the file, all of its versions and attachments from the database.
+// The references to their objects are released, unreferenced objects are removed as orphaned objects
+func (r *reconcileService) deleteFileRows(fileID uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var file model.ProjectFile
+		if err := tx.First(&file, fileID).Error; err != nil {
+			return err
+		}
+		var versions []model.ProjectFileVersion
+		if err := tx.Where("file_id = ?", fileID).
+			Find(&versions).Error; err != nil {
+			return err
+		}
+		if err := detachFile(tx, fileID); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().
+			Where("file_id = ?", fileID).
+			Delete(&model.ProjectFileVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&file).Error; err != nil {
+			return err
+		}
+		keys := []string{file.ObjectKey}
+		for _, v := range versions {
+			keys = append(keys, v.ObjectKey)
+		}
+		for _, key := range keys {
+			if _, err := releaseReference(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *reconcileService) Reconcile(opts ReconcileOptions) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		DryRun:              opts.DryRun,
+		StartedAt:           time.Now(),
+		OrphanedObjects:     []string{},
+		DanglingFiles:       []uint{},
+		DanglingVersions:    []uint{},
+		DeletedProjectFiles: []uint{},
+	}
+	cutoff := report.StartedAt.Add(-opts.MinAge)
+	addError := func(err error) {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	// the pending uploads are checked before the known keys are loaded: uploads which are completed
+	// in the meantime are referenced by the known keys, objects of all other uploads are younger than the cutoff
+	oldest, err := r.oldestUpload()
+	if err != nil {
+		return nil, err
+	}
+	if !oldest.IsZero() && oldest.Before(cutoff) {
+		cutoff = oldest
+	}
+
+	known, err := r.knownKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// objects which are not referenced by any row
+	existing := make(map[string]struct{})
+	if err = r.storage.Walk(uploadPrefix, func(key string, info *ObjectInfo) error {
+		report.ScannedObjects++
+		existing[key] = struct{}{}
+		if _, ok := known[key]; ok || info.LastModified.After(cutoff) {
+			return nil
+		}
+		report.OrphanedObjects = append(report.OrphanedObjects, key)
+		report.OrphanedSize += info.Size
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		for _, key := range report.OrphanedObjects {
+			if err = r.storage.Delete(key); err != nil {
+				addError(err)
+				continue
+			}
+			// deleted objects must not be shared with new uploads
+			if err = forgetObject(r.DB, key); err != nil {
+				addError(err)
+			}
+		}
+	}
+
+	// files of deleted projects. Their objects are reported as orphaned objects
+	var deletedProjectFiles []model.ProjectFile
+	if err = r.DB.Where("project_id IN (?)", r.deletedProjects()).
+		Select("id").
+		Find(&deletedProjectFiles).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range deletedProjectFiles {
+		report.DeletedProjectFiles = append(report.DeletedProjectFiles, f.ID)
+		if !opts.DryRun {
+			if err = r.deleteFileRows(f.ID); err != nil {
+				addError(err)
+			}
+		}
+	}
+
+	// rows which point to missing objects
+	var files []model.ProjectFile
+	if err = r.DB.Where("project_id NOT IN (?) AND updated_at < ?", r.deletedProjects(), cutoff).
+		Select("id", "object_key").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, ok := existing[f.ObjectKey]; ok {
+			continue
+		}
+		report.DanglingFiles = append(report.DanglingFiles, f.ID)
+		if !opts.DryRun {
+			if err = r.deleteFileRows(f.ID); err != nil {
+				addError(err)
+			}
+			// missing objects must not be shared with new uploads
+			if err = forgetObject(r.DB, f.ObjectKey); err != nil {
+				addError(err)
+			}
+		}
+	}
+	var versions []model.ProjectFileVersion
+	if err = r.DB.Where("created_at < ?", cutoff).
+		Select("id", "object_key").
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if _, ok := existing[v.ObjectKey]; ok {
+			continue
+		}
+		report.DanglingVersions = append(report.DanglingVersions, v.ID)
+		if !opts.DryRun {
+			if err = r.DB.Unscoped().Delete(&model.ProjectFileVersion{}, v.ID).Error; err != nil {
+				addError(err)
+			}
+			if err = forgetObject(r.DB, v.ObjectKey); err != nil {
+				addError(err)
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
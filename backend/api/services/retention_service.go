@@ -0,0 +1,431 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	ErrRetentionNoCondition    = errors.New("retention policy requires at least one condition")
+	ErrArchiveNotSupported     = errors.New("the storage driver does not support archiving")
+	ErrRetentionPolicyNotFound = errors.New("retention policy not found")
+	ErrRetentionRunning        = errors.New("retention policies are evaluated by another instance")
+)
+
+// retentionLockID is the ID of the advisory lock which prevents that multiple instances
+// evaluate the retention policies at the same time
+const retentionLockID int64 = 0x7065_7270_0001
+
+// ScheduledCleanup is a file which is scheduled for cleanup by a retention policy
+type ScheduledCleanup struct {
+	model.ProjectFile
+	// Action is the action which will be applied to the file
+	Action model.RetentionAction `json:"action"`
+	// PolicyName is the name of the policy the file is scheduled by
+	PolicyName string `json:"policy_name"`
+}
+
+// RetentionRunResult summarizes an evaluation of all retention policies
+type RetentionRunResult struct {
+	// Scheduled is the number of files which were scheduled for cleanup (and their uploaders notified)
+	Scheduled int
+	// Unscheduled is the number of files which no longer match their policy
+	Unscheduled int
+	// Deleted is the number of deleted files
+	Deleted int
+	// Archived is the number of archived files
+	Archived int
+	// Errors contains errors which did not stop the evaluation
+	Errors []string
+}
+
+// RetentionService manages retention policies and applies them to project files.
+// Matching files are scheduled for cleanup and their uploaders are notified.
+// The action is applied after the notice period if the file still matches the policy
+type RetentionService interface {
+	// CreatePolicy creates the policy. The mutations of policies are audited as performed by the actor
+	CreatePolicy(policy *model.RetentionPolicy, actorID string) error
+	FindPolicies(projectID uint) ([]model.RetentionPolicy, error)
+	FindPolicy(projectID uint, policyID uint) (*model.RetentionPolicy, error)
+	// UpdatePolicy saves the policy. Files scheduled by the policy are evaluated again
+	UpdatePolicy(policy *model.RetentionPolicy, actorID string) error
+	DeletePolicy(policy *model.RetentionPolicy, actorID string) error
+	// ScheduledCleanups returns the files of the project which are scheduled for cleanup (soonest first)
+	ScheduledCleanups(projectID uint) ([]ScheduledCleanup, error)
+	// Run evaluates all enabled retention policies. It returns ErrRetentionRunning
+	// if the policies are evaluated by another instance at the moment
+	Run() (*RetentionRunResult, error)
+}
+
+type retentionService struct {
+	DB         *gorm.DB
+	storage    StorageService
+	objectSrv  ObjectService
+	versionSrv FileVersionService
+	projectSrv ProjectService
+	userSrv    UserService
+	logger     *zap.SugaredLogger
+}
+
+func NewRetentionService(
+	db *gorm.DB,
+	storage StorageService,
+	objectSrv ObjectService,
+	versionSrv FileVersionService,
+	projectSrv ProjectService,
+	userSrv UserService,
+	logger *zap.SugaredLogger,
+) RetentionService {
+	return &retentionService{
+		DB:         db,
+		storage:    storage,
+		objectSrv:  objectSrv,
+		versionSrv: versionSrv,
+		projectSrv: projectSrv,
+		userSrv:    userSrv,
+		logger:     logger,
+	}
+}
+
+func (r *retentionService) validatePolicy(policy *model.RetentionPolicy) error {
+	if policy.MaxIdleDays <= 0 && policy.MeetingAgeDays <= 0 {
+		return ErrRetentionNoCondition
+	}
+	if policy.Action == model.RetentionActionArchive {
+		if _, ok := r.storage.(ArchiveStorage); !ok {
+			return ErrArchiveNotSupported
+		}
+	}
+	return nil
+}
+
+// unschedule removes the files scheduled by the policy from the cleanup
+func unschedule(tx *gorm.DB, policyID uint) error {
+	return tx.Model(&model.ProjectFile{}).
+		Where("cleanup_policy_id = ?", policyID).
+		UpdateColumns(map[string]any{
+			"cleanup_at":        nil,
+			"cleanup_policy_id": nil,
+		}).Error
+}
+
+func (r *retentionService) CreatePolicy(policy *model.RetentionPolicy, actorID string) error {
+	if err := r.validatePolicy(policy); err != nil {
+		return err
+	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(policy).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityRetentionPolicy, policy.ID, model.AuditActionCreate, nil, policy)
+	})
+}
+
+func (r *retentionService) FindPolicies(projectID uint) (res []model.RetentionPolicy, err error) {
+	err = r.DB.Where("project_id = ?", projectID).
+		Order("id").
+		Find(&res).Error
+	return
+}
+
+func (r *retentionService) FindPolicy(projectID uint, policyID uint) (*model.RetentionPolicy, error) {
+	var policies []model.RetentionPolicy
+	if err := r.DB.Where("id = ? AND project_id = ?", policyID, projectID).
+		Limit(1).
+		Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, ErrRetentionPolicyNotFound
+	}
+	return &policies[0], nil
+}
+
+func (r *retentionService) UpdatePolicy(policy *model.RetentionPolicy, actorID string) error {
+	if err := r.validatePolicy(policy); err != nil {
+		return err
+	}
+	return auditChanges[model.RetentionPolicy](r.DB, actorID, model.AuditEntityRetentionPolicy, policy.ID,
+		model.AuditActionUpdate, func(tx *gorm.DB) error {
+			// the changed conditions are evaluated with a new notice period
+			if err := unschedule(tx, policy.ID); err != nil {
+				return err
+			}
+			return tx.Save(policy).Error
+		})
+}
+
+func (r *retentionService) DeletePolicy(policy *model.RetentionPolicy, actorID string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := unschedule(tx, policy.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(policy).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityRetentionPolicy, policy.ID, model.AuditActionDelete, policy, nil)
+	})
+}
+
+func (r *retentionService) ScheduledCleanups(projectID uint) (res []ScheduledCleanup, err error) {
+	var files []model.ProjectFile
+	if err = r.DB.Where("project_id = ? AND cleanup_at IS NOT NULL", projectID).
+		Order("cleanup_at, id").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	policies, err := r.FindPolicies(projectID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.RetentionPolicy, len(policies))
+	for _, policy := range policies {
+		byID[policy.ID] = policy
+	}
+	res = make([]ScheduledCleanup, 0, len(files))
+	for _, file := range files {
+		if file.CleanupPolicyID == nil {
+			continue
+		}
+		policy, ok := byID[*file.CleanupPolicyID]
+		if !ok {
+			continue
+		}
+		res = append(res, ScheduledCleanup{
+			ProjectFile: file,
+			Action:      policy.Action,
+			PolicyName:  policy.Name,
+		})
+	}
+	return res, nil
+}
+
+// matching restricts the query to files of the project which match the policy at the given time
+func matching(tx *gorm.DB, policy *model.RetentionPolicy, at time.Time) *gorm.DB {
+	q := tx.Where("project_files.project_id = ?", policy.ProjectID)
+	if policy.Action == model.RetentionActionArchive {
+		q = q.Where("project_files.archived_at IS NULL")
+	}
+	if policy.MaxIdleDays > 0 {
+		cutoff := at.AddDate(0, 0, -policy.MaxIdleDays)
+		// files uploaded before accesses were tracked have no access date
+		q = q.Where("project_files.last_accessed_at < ? AND project_files.created_at < ?", cutoff, cutoff)
+	}
+	if policy.MeetingAgeDays > 0 {
+		cutoff := at.AddDate(0, 0, -policy.MeetingAgeDays)
+		const attachedTo = `SELECT 1 FROM meeting_file_attachments
+			JOIN meetings ON meetings.id = meeting_file_attachments.meeting_id AND meetings.deleted_at IS NULL
+			WHERE meeting_file_attachments.project_file_id = project_files.id AND meetings.start_date `
+		q = q.Where("EXISTS ("+attachedTo+"< ?)", cutoff).
+			Where("NOT EXISTS ("+attachedTo+">= ?)", cutoff)
+	}
+	return q
+}
+
+func (r *retentionService) Run() (res *RetentionRunResult, err error) {
+	ok, err := withAdvisoryLock(r.DB, retentionLockID, func() (err error) {
+		res, err = r.run()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrRetentionRunning
+	}
+	return res, nil
+}
+
+func (r *retentionService) run() (*RetentionRunResult, error) {
+	res := &RetentionRunResult{}
+	// files scheduled by disabled or deleted policies are no longer cleaned up
+	result := r.DB.Model(&model.ProjectFile{}).
+		Where("cleanup_policy_id IS NOT NULL AND cleanup_policy_id NOT IN (?)",
+			r.DB.Model(&model.RetentionPolicy{}).Select("id").Where("enabled = ?", true)).
+		UpdateColumns(map[string]any{
+			"cleanup_at":        nil,
+			"cleanup_policy_id": nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res.Unscheduled += int(result.RowsAffected)
+
+	// files are deleted rather than archived if they match multiple policies
+	var policies []model.RetentionPolicy
+	if err := r.DB.Where("enabled = ?", true).
+		Order("action DESC, id").
+		Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		if err := r.runPolicy(&policies[i], res); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("policy %d: %v", policies[i].ID, err))
+		}
+	}
+	return res, nil
+}
+
+func (r *retentionService) runPolicy(policy *model.RetentionPolicy, res *RetentionRunResult) error {
+	now := time.Now()
+	noticeEnd := now.AddDate(0, 0, policy.NoticeDays)
+
+	// apply the action to files whose notice period is over and which still match the policy
+	var due []model.ProjectFile
+	if err := matching(r.DB, policy, now).
+		Where("cleanup_policy_id = ? AND cleanup_at <= ?", policy.ID, now).
+		Find(&due).Error; err != nil {
+		return err
+	}
+	for i := range due {
+		if err := r.apply(policy, &due[i]); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("file %d: %v", due[i].ID, err))
+			continue
+		}
+		if policy.Action == model.RetentionActionDelete {
+			res.Deleted++
+		} else {
+			res.Archived++
+		}
+	}
+
+	// files which were accessed (or attached to a new meeting) since they were scheduled are kept
+	result := r.DB.Model(&model.ProjectFile{}).
+		Where("cleanup_policy_id = ?", policy.ID).
+		Where("id NOT IN (?)", matching(r.DB.Model(&model.ProjectFile{}), policy, noticeEnd).Select("project_files.id")).
+		UpdateColumns(map[string]any{
+			"cleanup_at":        nil,
+			"cleanup_policy_id": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	res.Unscheduled += int(result.RowsAffected)
+
+	// files which will match the policy by the end of the notice period are scheduled
+	var upcoming []model.ProjectFile
+	if err := matching(r.DB, policy, noticeEnd).
+		Where("cleanup_at IS NULL").
+		Find(&upcoming).Error; err != nil {
+		return err
+	}
+	if len(upcoming) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(upcoming))
+	for i, file := range upcoming {
+		ids[i] = file.ID
+	}
+	if err := r.DB.Model(&model.ProjectFile{}).
+		Where("id IN ? AND cleanup_at IS NULL", ids).
+		UpdateColumns(map[string]any{
+			"cleanup_at":        noticeEnd,
+			"cleanup_policy_id": policy.ID,
+		}).Error; err != nil {
+		return err
+	}
+	res.Scheduled += len(upcoming)
+	r.notify(policy, upcoming, noticeEnd)
+	return nil
+}
+
+// notify sends a notification to the uploaders of the scheduled files (one per uploader)
+func (r *retentionService) notify(policy *model.RetentionPolicy, files []model.ProjectFile, cleanupAt time.Time) {
+	project, err := r.projectSrv.FindProject(policy.ProjectID)
+	if err != nil {
+		r.logger.Warnf("cannot find project %d of retention policy %d: %v", policy.ProjectID, policy.ID, err)
+		return
+	}
+	counts := make(map[string]int)
+	for _, file := range files {
+		counts[file.CreatorID]++
+	}
+	verb := "deleted"
+	if policy.Action == model.RetentionActionArchive {
+		verb = "archived"
+	}
+	for creatorID, count := range counts {
+		if err := r.userSrv.CreateNotification(
+			creatorID,
+			project.Name,
+			"files",
+			fmt.Sprintf("%d of your files will be %s on %s by the retention policy %q unless they are accessed",
+				count, verb, cleanupAt.Format("2006-01-02"), policy.Name),
+			fmt.Sprintf("/project/%d", project.ID),
+			"Go to Project"); err != nil {
+			r.logger.Warnf("cannot create notification for user %s: %v", creatorID, err)
+		}
+	}
+}
+
+// apply deletes or archives the file. The cleanup is audited in the transaction of the cleanup
+func (r *retentionService) apply(policy *model.RetentionPolicy, file *model.ProjectFile) error {
+	event := model.AuditEvent{
+		ProjectID:  file.ProjectID,
+		EntityType: model.AuditEntityFile,
+		EntityID:   file.ID,
+	}
+	if policy.Action == model.RetentionActionArchive {
+		return r.archive(file, event)
+	}
+	var versions []model.ProjectFileVersion
+	if err := r.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if versions, err = NewFileVersionService(tx).DeleteVersions(file.ID); err != nil {
+			return err
+		}
+		// the instance deletes the file, so the deletion has no actor
+		return NewProjectService(tx).DeleteFile(file.ProjectID, file.ID, "")
+	}); err != nil {
+		return err
+	}
+	// objects which cannot be deleted are removed by the storage reconciliation
+	for _, key := range append([]string{file.ObjectKey}, versionKeys(versions)...) {
+		if err := r.objectSrv.Release(key); err != nil {
+			r.logger.Warnf("cannot delete object %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func (r *retentionService) archive(file *model.ProjectFile, event model.AuditEvent) error {
+	versions, err := r.versionSrv.FindVersions(file.ID)
+	if err != nil {
+		return err
+	}
+	archiver := r.storage.(ArchiveStorage)
+	// objects are shared between files with identical content, so the other files are archived as well
+	for _, key := range append([]string{file.ObjectKey}, versionKeys(versions)...) {
+		if err = archiver.Archive(key); err != nil {
+			return err
+		}
+	}
+	before := *file
+	now := time.Now()
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		// archiving does not change the content, so updated_at is not touched
+		if err := tx.Model(file).UpdateColumns(map[string]any{
+			"archived_at":       now,
+			"cleanup_at":        nil,
+			"cleanup_policy_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+		file.ArchivedAt = &now
+		file.CleanupAt = nil
+		file.CleanupPolicyID = nil
+		event.Action = model.AuditActionUpdate
+		return recordAudit(tx, event, before, file)
+	})
+}
+
+func versionKeys(versions []model.ProjectFileVersion) []string {
+	keys := make([]string, len(versions))
+	for i, v := range versions {
+		keys[i] = v.ObjectKey
+	}
+	return keys
+}
@@ -6,27 +6,26 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"mime/multipart"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type S3Service interface {
-	UploadFile(userID string, projectID uint, file *multipart.FileHeader) (string, error)
-	DeleteFile(fileKey string) error
-	GetObjectRequest(fileKey string) (*request.Request, *s3.GetObjectOutput)
-}
-
-type s3Service struct {
+type s3Storage struct {
 	bucketID        string
 	svc             *s3.S3
 	uploader        *s3manager.Uploader
 	taggingDisabled bool
+	archiveClass    string
 }
 
 var (
@@ -36,7 +35,8 @@ var (
 	ErrNoSecretKeySpecified = errors.New("no secret key specified")
 )
 
-func NewS3Service() (S3Service, error) {
+// NewS3Storage creates a storage driver which stores files in an S3 bucket
+func NewS3Storage() (StorageService, error) {
 	region, ok := os.LookupEnv("AWS_REGION")
 	if !ok {
 		return nil, ErrRegionNotSpecified
@@ -55,8 +55,11 @@ func NewS3Service() (S3Service, error) {
 	}
 
 	_, taggingDisabled := os.LookupEnv("AWS_TAGGING_DISABLED")
-
-	fmt.Println("region:", region, "bucket:", bucket, "accessKey:", accessKey, "secretKey:", secretKey)
+	// archived objects must stay readable without a restore, so an instant retrieval class is the default
+	archiveClass, ok := os.LookupEnv("AWS_ARCHIVE_STORAGE_CLASS")
+	if !ok {
+		archiveClass = s3.StorageClassGlacierIr
+	}
 
 	config := aws.Config{
 		Region:      &region,
@@ -74,11 +77,12 @@ func NewS3Service() (S3Service, error) {
 
 	svc := s3.New(sess)
 
-	return &s3Service{
+	return &s3Storage{
 		bucketID:        bucket,
 		svc:             svc,
 		uploader:        s3manager.NewUploader(sess),
 		taggingDisabled: taggingDisabled,
+		archiveClass:    archiveClass,
 	}, nil
 }
 
@@ -93,52 +97,170 @@ func randomHexString(length int) (string, error) {
 	return hex.EncodeToString(randomBytes)[:length], nil
 }
 
-func (s *s3Service) UploadFile(userID string, projectID uint, file *multipart.FileHeader) (string, error) {
-	fileSrc, err := file.Open()
-	if err != nil {
-		return "", err
+func (s *s3Storage) Put(key string, body io.Reader, _ int64, opts PutOptions) error {
+	params := &s3manager.UploadInput{
+		Body:   body,
+		Bucket: aws.String(s.bucketID),
+		Key:    aws.String(key),
 	}
+	if opts.ContentType != "" {
+		params.ContentType = aws.String(opts.ContentType)
+	}
+	if !s.taggingDisabled && len(opts.Tags) > 0 {
+		tags := make(url.Values)
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		params.Tagging = aws.String(tags.Encode())
+	}
+	_, err := s.uploader.Upload(params)
+	return err
+}
 
-	fileName := file.Filename
-	fileType := http.DetectContentType([]byte(fileName))
+// isNotFound checks if the error returned by S3 indicates a missing object
+func isNotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey
+}
 
-	// create a random 64 byte string for the file key
-	randomFileKey, err := randomHexString(64)
+func (s *s3Storage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucketID),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return "", err
+		if isNotFound(err) {
+			return nil, nil, ErrObjectNotFound
+		}
+		return nil, nil, err
 	}
-	randomFileKey = "uploads/" + randomFileKey
-
-	fmt.Println("uploading file", fileName, "with key", randomFileKey, "to bucket", s.bucketID)
+	return out.Body, &ObjectInfo{
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+		ETag:         aws.StringValue(out.ETag),
+	}, nil
+}
 
-	params := &s3manager.UploadInput{
-		Body:        fileSrc,
-		Bucket:      aws.String(s.bucketID),
-		ContentType: aws.String(fileType),
-		Key:         aws.String(randomFileKey),
+func (s *s3Storage) GetRange(key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucketID),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, ErrObjectNotFound
+		}
+		return nil, nil, err
 	}
-	if !s.taggingDisabled {
-		params.Tagging = aws.String(fmt.Sprintf("ProjectID=%d&UserID=%s", projectID, userID))
+	// the size of the complete object is only contained in the Content-Range header
+	size := aws.Int64Value(out.ContentLength)
+	if contentRange := aws.StringValue(out.ContentRange); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if total, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				size = total
+			}
+		}
 	}
+	return out.Body, &ObjectInfo{
+		Size:         size,
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+		ETag:         aws.StringValue(out.ETag),
+	}, nil
+}
 
-	_, err = s.uploader.Upload(params)
+func (s *s3Storage) Stat(key string) (*ObjectInfo, error) {
+	out, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketID),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return "", err
+		if isNotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
 	}
-	return randomFileKey, nil
+	return &ObjectInfo{
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+		ETag:         aws.StringValue(out.ETag),
+	}, nil
 }
 
-func (s *s3Service) DeleteFile(fileKey string) error {
+func (s *s3Storage) Delete(key string) error {
 	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucketID),
-		Key:    aws.String(fileKey),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// Archive changes the storage class of the object by copying it onto itself
+func (s *s3Storage) Archive(key string) error {
+	_, err := s.svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucketID),
+		Key:               aws.String(key),
+		CopySource:        aws.String(url.PathEscape(s.bucketID + "/" + key)),
+		StorageClass:      aws.String(s.archiveClass),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+		TaggingDirective:  aws.String(s3.TaggingDirectiveCopy),
 	})
 	return err
 }
 
-func (s *s3Service) GetObjectRequest(fileKey string) (*request.Request, *s3.GetObjectOutput) {
-	return s.svc.GetObjectRequest(&s3.GetObjectInput{
+func (s *s3Storage) DownloadURL(key string, expires time.Duration) (string, error) {
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(s.bucketID),
-		Key:    aws.String(fileKey),
+		Key:    aws.String(key),
 	})
+	if req.Error != nil {
+		return "", req.Error
+	}
+	return req.Presign(expires)
+}
+
+func (s *s3Storage) UploadURL(key, contentType string, size int64, expires time.Duration) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucketID),
+		Key:           aws.String(key),
+		ContentLength: aws.Int64(size),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	req, _ := s.svc.PutObjectRequest(input)
+	if req.Error != nil {
+		return "", req.Error
+	}
+	return req.Presign(expires)
+}
+
+func (s *s3Storage) Walk(prefix string, fn func(key string, info *ObjectInfo) error) error {
+	var fnErr error
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketID),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			if fnErr = fn(aws.StringValue(obj.Key), &ObjectInfo{
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+				ETag:         aws.StringValue(obj.ETag),
+			}); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
 }
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/pkg/model"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrUnknownScanner = errors.New("unknown scanner")
+
+// Scanner inspects content for malware
+type Scanner interface {
+	// Scan returns the name of the malware found in the content
+	// or an empty string if the content is clean
+	Scan(body io.Reader) (string, error)
+}
+
+// ScanResult is the result of the scan of a stored object
+type ScanResult struct {
+	Status    model.ScanStatus
+	Signature string
+}
+
+// ScanService scans uploaded files before they are added to a project
+type ScanService interface {
+	// ScanObject scans the stored object. If no scanner is configured or the scanner fails,
+	// the object is reported as unscanned
+	ScanObject(key string) ScanResult
+	// Enforced returns true if only files which were scanned and found clean can be downloaded
+	Enforced() bool
+}
+
+type scanService struct {
+	scanner  Scanner
+	storage  StorageService
+	enforced bool
+	logger   *zap.SugaredLogger
+}
+
+// NewScanService creates the scanner specified by SCANNER. Supported scanners are "none" (default)
+// and "clamd" (configured by CLAMD_ADDRESS and CLAMD_TIMEOUT).
+// If SCANNER_ENFORCE is set, files can only be downloaded if they were found clean
+func NewScanService(storage StorageService, logger *zap.SugaredLogger) (ScanService, error) {
+	var scanner Scanner
+	switch os.Getenv("SCANNER") {
+	case "", "none":
+		// files are stored as unscanned
+	case "clamd":
+		address, ok := os.LookupEnv("CLAMD_ADDRESS")
+		if !ok {
+			address = "tcp://localhost:3310"
+		}
+		timeout := defaultClamdTimeout
+		if raw, ok := os.LookupEnv("CLAMD_TIMEOUT"); ok {
+			var err error
+			if timeout, err = time.ParseDuration(raw); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if scanner, err = NewClamdScanner(address, timeout); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownScanner
+	}
+	_, enforced := os.LookupEnv("SCANNER_ENFORCE")
+	return &scanService{
+		scanner:  scanner,
+		storage:  storage,
+		enforced: enforced,
+		logger:   logger,
+	}, nil
+}
+
+func (s *scanService) ScanObject(key string) ScanResult {
+	if s.scanner == nil {
+		return ScanResult{Status: model.ScanStatusUnscanned}
+	}
+	body, _, err := s.storage.Get(key)
+	if err != nil {
+		s.logger.Warnf("cannot read object %s for scanning: %v", key, err)
+		return ScanResult{Status: model.ScanStatusUnscanned}
+	}
+	defer body.Close()
+	signature, err := s.scanner.Scan(body)
+	if err != nil {
+		s.logger.Warnf("cannot scan object %s: %v", key, err)
+		return ScanResult{Status: model.ScanStatusUnscanned}
+	}
+	if signature != "" {
+		s.logger.Warnf("found %s in object %s", signature, key)
+		return ScanResult{Status: model.ScanStatusInfected, Signature: signature}
+	}
+	return ScanResult{Status: model.ScanStatusClean}
+}
+
+func (s *scanService) Enforced() bool {
+	return s.enforced
+}
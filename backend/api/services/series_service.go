@@ -0,0 +1,683 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/rrule"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SeriesHorizon is the time span (from now) for which occurrences of meeting series are created as meetings
+// when the meetings of a project are listed
+const SeriesHorizon = 28 * 24 * time.Hour
+
+// MaxMaterializedOccurrences is the maximum number of meetings created for a series in a single call.
+// The remaining occurrences are created by the following calls
+const MaxMaterializedOccurrences = 100
+
+var (
+	ErrSeriesNotFound  = errors.New("meeting series not found")
+	ErrInvalidTimeZone = errors.New("invalid time zone")
+	ErrNotAnOccurrence = errors.New("meeting is not an occurrence of a series")
+)
+
+// Occurrence is a single occurrence of a meeting series
+type Occurrence struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// MeetingID is the ID of the meeting of the occurrence (0 if the meeting was not created yet)
+	MeetingID uint `json:"meeting_id,omitempty"`
+}
+
+// SeriesService manages recurring meetings. Occurrences of a series are created as meetings on demand,
+// so topics, comments, etc. can be added to them like to any other meeting
+type SeriesService interface {
+	// CreateSeries validates the recurrence rule and time zone and creates the series.
+	// The mutations of series are audited as performed by the creator (or actor)
+	CreateSeries(series *model.MeetingSeries) error
+	FindSeries(projectID uint, seriesID uint) (*model.MeetingSeries, error)
+	FindSeriesForProject(projectID uint) ([]model.MeetingSeries, error)
+	// FindSeriesByICalUID returns the series imported from the iCalendar event with the UID
+	FindSeriesByICalUID(projectID uint, uid string) (*model.MeetingSeries, error)
+	// Occurrences returns the occurrences of the series which start in [from, to)
+	Occurrences(series *model.MeetingSeries, from, to time.Time) ([]Occurrence, error)
+	// Materialize creates meetings for all occurrences of the series of the project which start before until
+	Materialize(projectID uint, until time.Time) error
+	// MaterializeSeries creates meetings for all occurrences of the series which start before until
+	MaterializeSeries(series *model.MeetingSeries, until time.Time) error
+	// UpdateSeries saves the changed series. Meetings of upcoming occurrences are moved to the new
+	// occurrences on the same day, other upcoming meetings are removed. Edited occurrences are kept
+	UpdateSeries(series *model.MeetingSeries, actorID string) error
+	// SplitSeries ends the series before the occurrence of the meeting and starts a new series
+	// with the given values at the occurrence ("this and following")
+	SplitSeries(
+		meeting *model.Meeting,
+		name, description string,
+		start, end time.Time,
+		actorID string,
+	) (*model.MeetingSeries, error)
+	// EndSeries removes the occurrence of the meeting and all following occurrences from the series.
+	// If the meeting is the first occurrence, the series is deleted
+	EndSeries(meeting *model.Meeting, actorID string) error
+	// DeleteSeries deletes the series and the meetings of its upcoming occurrences
+	DeleteSeries(series *model.MeetingSeries, actorID string) error
+	// ExcludeOccurrence excludes the occurrence of the (deleted) meeting from its series
+	ExcludeOccurrence(meeting *model.Meeting) error
+	// ExcludeDate excludes the occurrence starting at t from the series and removes its meeting
+	ExcludeDate(series *model.MeetingSeries, t time.Time) error
+	// LinkUsers assigns the users to the series and its upcoming meetings
+	LinkUsers(series *model.MeetingSeries, userIDs []string) error
+	// MarkException marks the meeting as edited, so it is no longer updated with its series
+	MarkException(meetingID uint) error
+}
+
+type seriesService struct {
+	DB *gorm.DB
+	// mu serializes changes of series within this instance. Across instances, the unique index
+	// on the occurrence of meetings prevents that occurrences are created twice
+	mu sync.Mutex
+}
+
+func NewSeriesService(db *gorm.DB) SeriesService {
+	return &seriesService{
+		DB: db,
+	}
+}
+
+// parseSeries returns the recurrence rule and the first occurrence (in the time zone) of the series
+func parseSeries(series *model.MeetingSeries) (*rrule.Rule, time.Time, error) {
+	rule, err := rrule.Parse(series.RRule)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	loc, err := time.LoadLocation(series.TimeZone)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTimeZone, series.TimeZone)
+	}
+	return rule, series.StartDate.In(loc), nil
+}
+
+// expand returns the start times of the occurrences of the series in [from, to)
+func expand(series *model.MeetingSeries, from, to time.Time) ([]time.Time, error) {
+	rule, dtstart, err := parseSeries(series)
+	if err != nil {
+		return nil, err
+	}
+	return rule.Between(dtstart, from, to, series.ExDates), nil
+}
+
+// endBefore limits the recurrence rule of the series to occurrences before t
+// and returns the number of occurrences (including excluded ones) before t
+func endBefore(series *model.MeetingSeries, t time.Time) (int, error) {
+	rule, dtstart, err := parseSeries(series)
+	if err != nil {
+		return 0, err
+	}
+	before := len(rule.Between(dtstart, dtstart, t, nil))
+	rule.Count = 0
+	rule.Until = t.Add(-time.Second)
+	series.RRule = rule.String()
+	return before, nil
+}
+
+// materializeFrom returns the initial watermark of a series starting at start.
+// Past occurrences are not created as meetings, but can still be listed as occurrences
+func materializeFrom(start time.Time) time.Time {
+	if now := time.Now(); start.Before(now) {
+		return now
+	}
+	return start
+}
+
+func (s *seriesService) CreateSeries(series *model.MeetingSeries) error {
+	if _, _, err := parseSeries(series); err != nil {
+		return err
+	}
+	series.MaterializedUntil = materializeFrom(series.StartDate)
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("AssignedUsers.*").Create(series).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, series.CreatorID, model.AuditEntityMeetingSeries, series.ID,
+			model.AuditActionCreate, nil, series)
+	})
+}
+
+func (s *seriesService) FindSeries(projectID uint, seriesID uint) (*model.MeetingSeries, error) {
+	var series []model.MeetingSeries
+	if err := s.DB.Preload("AssignedUsers").
+		Where("id = ? AND project_id = ?", seriesID, projectID).
+		Limit(1).
+		Find(&series).Error; err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return nil, ErrSeriesNotFound
+	}
+	return &series[0], nil
+}
+
+func (s *seriesService) FindSeriesByICalUID(projectID uint, uid string) (*model.MeetingSeries, error) {
+	var series []model.MeetingSeries
+	if err := s.DB.Preload("AssignedUsers").
+		Where("project_id = ? AND ical_uid = ?", projectID, uid).
+		Limit(1).
+		Find(&series).Error; err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return nil, ErrSeriesNotFound
+	}
+	return &series[0], nil
+}
+
+func (s *seriesService) FindSeriesForProject(projectID uint) (res []model.MeetingSeries, err error) {
+	err = s.DB.Preload("AssignedUsers").
+		Where("project_id = ?", projectID).
+		Order("start_date").
+		Find(&res).Error
+	return
+}
+
+// occurrenceMeetings returns the meetings of the series ordered by their occurrence
+func occurrenceMeetings(tx *gorm.DB, seriesID uint) ([]model.Meeting, error) {
+	var meetings []model.Meeting
+	if err := tx.Where("series_id = ?", seriesID).
+		Find(&meetings).Error; err != nil {
+		return nil, err
+	}
+	// occurrence dates are compared in Go since the databases store the time zone differently
+	sort.Slice(meetings, func(i, j int) bool {
+		return occurrenceOf(meetings[i]).Before(occurrenceOf(meetings[j]))
+	})
+	return meetings, nil
+}
+
+func occurrenceOf(meeting model.Meeting) time.Time {
+	if meeting.OccurrenceDate != nil {
+		return *meeting.OccurrenceDate
+	}
+	return meeting.StartDate
+}
+
+func (s *seriesService) Occurrences(series *model.MeetingSeries, from, to time.Time) ([]Occurrence, error) {
+	starts, err := expand(series, from, to)
+	if err != nil {
+		return nil, err
+	}
+	meetings, err := occurrenceMeetings(s.DB, series.ID)
+	if err != nil {
+		return nil, err
+	}
+	byOccurrence := make(map[int64]model.Meeting, len(meetings))
+	for _, m := range meetings {
+		byOccurrence[occurrenceOf(m).Unix()] = m
+	}
+	duration := series.EndDate.Sub(series.StartDate)
+	res := make([]Occurrence, len(starts))
+	for i, start := range starts {
+		res[i] = Occurrence{
+			Start: start,
+			End:   start.Add(duration),
+		}
+		// edited occurrences are shown with their changed dates
+		if m, ok := byOccurrence[start.Unix()]; ok {
+			res[i] = Occurrence{
+				Start:     m.StartDate,
+				End:       m.EndDate,
+				MeetingID: m.ID,
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *seriesService) Materialize(projectID uint, until time.Time) error {
+	series, err := s.FindSeriesForProject(projectID)
+	if err != nil {
+		return err
+	}
+	for i := range series {
+		if err = s.MaterializeSeries(&series[i], until); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newOccurrence returns the meeting of the occurrence of the series starting at start
+func newOccurrence(series *model.MeetingSeries, start time.Time) model.Meeting {
+	occurrence := start.UTC()
+	return model.Meeting{
+		Name:           series.Name,
+		Description:    series.Description,
+		StartDate:      occurrence,
+		EndDate:        occurrence.Add(series.EndDate.Sub(series.StartDate)),
+		ProjectID:      series.ProjectID,
+		CreatorID:      series.CreatorID,
+		AssignedUsers:  series.AssignedUsers,
+		SeriesID:       &series.ID,
+		OccurrenceDate: &occurrence,
+	}
+}
+
+// createOccurrence creates the meeting of the occurrence of the series starting at start.
+// Occurrences which were already created (e.g. by another instance) are skipped
+func createOccurrence(tx *gorm.DB, series *model.MeetingSeries, start time.Time) error {
+	meeting := newOccurrence(series, start)
+	res := tx.Omit("AssignedUsers").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&meeting)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	if len(meeting.AssignedUsers) > 0 {
+		if err := tx.Omit("AssignedUsers.*").Model(&meeting).
+			Association("AssignedUsers").
+			Append(meeting.AssignedUsers); err != nil {
+			return err
+		}
+	}
+	// occurrences are created by the series, not by the user who changed the series
+	return recordAudit(tx, model.AuditEvent{
+		ProjectID:  series.ProjectID,
+		EntityType: model.AuditEntityMeeting,
+		EntityID:   meeting.ID,
+		Action:     model.AuditActionCreate,
+	}, nil, meeting)
+}
+
+func (s *seriesService) MaterializeSeries(series *model.MeetingSeries, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the series could have been materialized by a concurrent request
+	if err := s.DB.Preload("AssignedUsers").First(series, series.ID).Error; err != nil {
+		return err
+	}
+	if !series.MaterializedUntil.Before(until) {
+		return nil
+	}
+	starts, err := expand(series, series.MaterializedUntil, until)
+	if err != nil {
+		return err
+	}
+	// the watermark stops at the first occurrence which was not created
+	if len(starts) > MaxMaterializedOccurrences {
+		until = starts[MaxMaterializedOccurrences]
+		starts = starts[:MaxMaterializedOccurrences]
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		meetings, err := occurrenceMeetings(tx, series.ID)
+		if err != nil {
+			return err
+		}
+		existing := make(map[int64]bool, len(meetings))
+		for _, m := range meetings {
+			existing[occurrenceOf(m).Unix()] = true
+		}
+		for _, start := range starts {
+			if existing[start.Unix()] {
+				continue
+			}
+			if err := createOccurrence(tx, series, start); err != nil {
+				return err
+			}
+		}
+		series.MaterializedUntil = until
+		return tx.Model(series).UpdateColumn("materialized_until", until).Error
+	})
+}
+
+// dropOccurrence removes the meeting of an occurrence which no longer exists.
+// Meetings with topics are kept as single meetings, so no content is lost
+func dropOccurrence(tx *gorm.DB, meeting *model.Meeting) error {
+	var topics int64
+	if err := tx.Model(&model.Topic{}).
+		Where("meeting_id = ?", meeting.ID).
+		Count(&topics).Error; err != nil {
+		return err
+	}
+	if topics > 0 {
+		return tx.Model(meeting).UpdateColumns(map[string]any{
+			"series_id":       nil,
+			"occurrence_date": nil,
+		}).Error
+	}
+	return tx.Delete(meeting).Error
+}
+
+// reassign moves the meetings of the upcoming, unedited occurrences of the series starting at from
+// to the occurrences of target on the same day. Meetings without a matching occurrence are dropped.
+// If target is nil, all meetings are dropped
+func reassign(tx *gorm.DB, series *model.MeetingSeries, target *model.MeetingSeries, from time.Time) error {
+	meetings, err := occurrenceMeetings(tx, series.ID)
+	if err != nil {
+		return err
+	}
+	var upcoming []model.Meeting
+	for _, m := range meetings {
+		if !m.IsException && !occurrenceOf(m).Before(from) {
+			upcoming = append(upcoming, m)
+		}
+	}
+	if len(upcoming) == 0 {
+		return nil
+	}
+	// occurrences of the target on the same day as a meeting, which do not have a meeting yet
+	available := make(map[string]time.Time)
+	if target != nil {
+		_, dtstart, err := parseSeries(target)
+		if err != nil {
+			return err
+		}
+		start := from
+		if dtstart.Before(start) {
+			start = dtstart
+		}
+		end := occurrenceOf(upcoming[len(upcoming)-1]).AddDate(0, 0, 1)
+		starts, err := expand(target, start, end)
+		if err != nil {
+			return err
+		}
+		for _, t := range starts {
+			available[t.Format("2006-01-02")] = t
+		}
+		targetMeetings, err := occurrenceMeetings(tx, target.ID)
+		if err != nil {
+			return err
+		}
+		reassigned := make(map[uint]bool, len(upcoming))
+		for _, m := range upcoming {
+			reassigned[m.ID] = true
+		}
+		for _, m := range targetMeetings {
+			if reassigned[m.ID] {
+				continue
+			}
+			delete(available, occurrenceOf(m).In(dtstart.Location()).Format("2006-01-02"))
+		}
+	}
+	for i := range upcoming {
+		meeting := &upcoming[i]
+		day := ""
+		if target != nil {
+			loc, _ := time.LoadLocation(target.TimeZone)
+			day = occurrenceOf(*meeting).In(loc).Format("2006-01-02")
+		}
+		start, ok := available[day]
+		if !ok {
+			if err = dropOccurrence(tx, meeting); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(available, day)
+		occurrence := newOccurrence(target, start)
+		if err = tx.Model(meeting).Updates(map[string]any{
+			"name":            occurrence.Name,
+			"description":     occurrence.Description,
+			"start_date":      occurrence.StartDate,
+			"end_date":        occurrence.EndDate,
+			"series_id":       target.ID,
+			"occurrence_date": occurrence.OccurrenceDate,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *seriesService) UpdateSeries(series *model.MeetingSeries, actorID string) error {
+	if _, _, err := parseSeries(series); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// past meetings are kept unchanged
+	now := time.Now()
+	return auditChanges[model.MeetingSeries](s.DB, actorID, model.AuditEntityMeetingSeries, series.ID,
+		model.AuditActionUpdate, func(tx *gorm.DB) error {
+			// the watermark is reset, so missing upcoming occurrences are created on the next request
+			series.MaterializedUntil = materializeFrom(series.StartDate)
+			if err := tx.Omit("AssignedUsers").Save(series).Error; err != nil {
+				return err
+			}
+			return reassign(tx, series, series, now)
+		})
+}
+
+func (s *seriesService) findOccurrenceSeries(meeting *model.Meeting) (*model.MeetingSeries, time.Time, error) {
+	if meeting.SeriesID == nil {
+		return nil, time.Time{}, ErrNotAnOccurrence
+	}
+	series, err := s.FindSeries(meeting.ProjectID, *meeting.SeriesID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return series, occurrenceOf(*meeting), nil
+}
+
+func (s *seriesService) SplitSeries(
+	meeting *model.Meeting,
+	name, description string,
+	start, end time.Time,
+	actorID string,
+) (*model.MeetingSeries, error) {
+	series, from, err := s.findOccurrenceSeries(meeting)
+	if err != nil {
+		return nil, err
+	}
+	if !from.After(series.StartDate) {
+		// the first occurrence changes the complete series
+		series.Name = name
+		series.Description = description
+		series.StartDate = start
+		series.EndDate = end
+		return series, s.UpdateSeries(series, actorID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := &model.MeetingSeries{
+		Name:              name,
+		Description:       description,
+		StartDate:         start,
+		EndDate:           end,
+		TimeZone:          series.TimeZone,
+		ProjectID:         series.ProjectID,
+		CreatorID:         series.CreatorID,
+		MaterializedUntil: materializeFrom(start),
+		AssignedUsers:     series.AssignedUsers,
+	}
+	rule, _, err := parseSeries(series)
+	if err != nil {
+		return nil, err
+	}
+	before, err := endBefore(series, from)
+	if err != nil {
+		return nil, err
+	}
+	// the new series continues with the remaining occurrences
+	if rule.Count > 0 {
+		rule.Count -= before
+	}
+	next.RRule = rule.String()
+	for _, t := range series.ExDates {
+		if !t.Before(from) {
+			next.ExDates = append(next.ExDates, t)
+		}
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var previous model.MeetingSeries
+		if err := tx.First(&previous, series.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit("AssignedUsers").Save(series).Error; err != nil {
+			return err
+		}
+		if err := auditEntity(tx, actorID, model.AuditEntityMeetingSeries, series.ID,
+			model.AuditActionUpdate, &previous, series); err != nil {
+			return err
+		}
+		if err := tx.Omit("AssignedUsers.*").Create(next).Error; err != nil {
+			return err
+		}
+		if err := auditEntity(tx, actorID, model.AuditEntityMeetingSeries, next.ID,
+			model.AuditActionCreate, nil, next); err != nil {
+			return err
+		}
+		// the edited meeting is the first occurrence of the new series
+		before := *meeting
+		occurrence := newOccurrence(next, start)
+		if err := tx.Model(meeting).Updates(map[string]any{
+			"name":            name,
+			"description":     description,
+			"start_date":      occurrence.StartDate,
+			"end_date":        occurrence.EndDate,
+			"series_id":       next.ID,
+			"occurrence_date": occurrence.OccurrenceDate,
+		}).Error; err != nil {
+			return err
+		}
+		var after model.Meeting
+		if err := tx.First(&after, meeting.ID).Error; err != nil {
+			return err
+		}
+		if err := auditEntity(tx, actorID, model.AuditEntityMeeting, meeting.ID,
+			model.AuditActionUpdate, &before, &after); err != nil {
+			return err
+		}
+		return reassign(tx, series, next, from)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (s *seriesService) EndSeries(meeting *model.Meeting, actorID string) error {
+	series, from, err := s.findOccurrenceSeries(meeting)
+	if err != nil {
+		return err
+	}
+	if !from.After(series.StartDate) {
+		return s.deleteSeries(series, from, actorID)
+	}
+	if _, err = endBefore(series, from); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return auditChanges[model.MeetingSeries](s.DB, actorID, model.AuditEntityMeetingSeries, series.ID,
+		model.AuditActionUpdate, func(tx *gorm.DB) error {
+			if err := tx.Omit("AssignedUsers").Save(series).Error; err != nil {
+				return err
+			}
+			return reassign(tx, series, nil, from)
+		})
+}
+
+func (s *seriesService) DeleteSeries(series *model.MeetingSeries, actorID string) error {
+	// past meetings are kept
+	return s.deleteSeries(series, time.Now(), actorID)
+}
+
+// deleteSeries deletes the series and the meetings of its occurrences starting at from
+func (s *seriesService) deleteSeries(series *model.MeetingSeries, from time.Time, actorID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := reassign(tx, series, nil, from); err != nil {
+			return err
+		}
+		// the remaining meetings are kept as single meetings
+		if err := tx.Model(&model.Meeting{}).
+			Where("series_id = ?", series.ID).
+			UpdateColumn("series_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(series).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityMeetingSeries, series.ID,
+			model.AuditActionDelete, series, nil)
+	})
+}
+
+func (s *seriesService) ExcludeOccurrence(meeting *model.Meeting) error {
+	series, from, err := s.findOccurrenceSeries(meeting)
+	if err != nil {
+		return err
+	}
+	series.ExDates = append(series.ExDates, from)
+	return s.DB.Model(series).Update("ex_dates", series.ExDates).Error
+}
+
+func (s *seriesService) MarkException(meetingID uint) error {
+	return s.DB.Model(&model.Meeting{}).
+		Where("id = ? AND series_id IS NOT NULL", meetingID).
+		Update("is_exception", true).Error
+}
+
+func (s *seriesService) ExcludeDate(series *model.MeetingSeries, t time.Time) error {
+	for _, ex := range series.ExDates {
+		if ex.Equal(t) {
+			return nil
+		}
+	}
+	series.ExDates = append(series.ExDates, t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(series).Update("ex_dates", series.ExDates).Error; err != nil {
+			return err
+		}
+		meetings, err := occurrenceMeetings(tx, series.ID)
+		if err != nil {
+			return err
+		}
+		for i := range meetings {
+			if !meetings[i].IsException && occurrenceOf(meetings[i]).Equal(t) {
+				return dropOccurrence(tx, &meetings[i])
+			}
+		}
+		return nil
+	})
+}
+
+func (s *seriesService) LinkUsers(series *model.MeetingSeries, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	users := make([]model.User, len(userIDs))
+	for i, id := range userIDs {
+		users[i] = model.User{ID: id}
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("AssignedUsers.*").Model(series).
+			Association("AssignedUsers").
+			Append(users); err != nil {
+			return err
+		}
+		meetings, err := occurrenceMeetings(tx, series.ID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i := range meetings {
+			if meetings[i].IsException || meetings[i].StartDate.Before(now) {
+				continue
+			}
+			if err = tx.Omit("AssignedUsers.*").Model(&meetings[i]).
+				Association("AssignedUsers").
+				Append(users); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrUnknownStorageDriver = errors.New("unknown storage driver")
+	ErrObjectNotFound       = errors.New("object not found")
+)
+
+// ObjectInfo contains information about a stored object
+type ObjectInfo struct {
+	// Size is the size of the object in bytes
+	Size int64
+	// ContentType is the content type of the object (may be empty)
+	ContentType string
+	// LastModified is the time when the object was stored
+	LastModified time.Time
+	// ETag is the entity tag of the object (may be empty)
+	ETag string
+}
+
+// PutOptions contains optional information about an object which is stored
+type PutOptions struct {
+	// ContentType is the content type of the object
+	ContentType string
+	// Tags are stored alongside the object if the driver supports it
+	Tags map[string]string
+}
+
+// StorageService stores the content of project files
+type StorageService interface {
+	// Put stores the content of body with the given size under key
+	Put(key string, body io.Reader, size int64, opts PutOptions) error
+	// Get returns a reader for the content of the object. The reader must be closed by the caller
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetRange returns a reader for length bytes of the content starting at offset.
+	// The returned object info describes the complete object. The reader must be closed by the caller
+	GetRange(key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error)
+	// Stat returns information about the object or ErrObjectNotFound if it does not exist
+	Stat(key string) (*ObjectInfo, error)
+	// Delete deletes the object
+	Delete(key string) error
+	// DownloadURL returns a URL which can be used to download the object without further authentication
+	DownloadURL(key string, expires time.Duration) (string, error)
+	// Walk calls fn for every stored object whose key starts with prefix.
+	// Walking stops if fn returns an error
+	Walk(prefix string, fn func(key string, info *ObjectInfo) error) error
+	// UploadURL returns a URL which can be used to upload the object with a PUT request
+	// without further authentication. The content type and size of the request must match
+	UploadURL(key, contentType string, size int64, expires time.Duration) (string, error)
+}
+
+// ArchiveStorage is implemented by storage drivers which can move rarely accessed objects
+// to a cheaper storage class
+type ArchiveStorage interface {
+	// Archive moves the object to the archive storage class
+	Archive(key string) error
+}
+
+// NewStorageService creates the storage driver specified by STORAGE_DRIVER.
+// Supported drivers are "s3" (default) and "local"
+func NewStorageService() (StorageService, error) {
+	driver, ok := os.LookupEnv("STORAGE_DRIVER")
+	if !ok {
+		driver = "s3"
+	}
+	switch driver {
+	case "s3":
+		return NewS3Storage()
+	case "local":
+		return NewLocalStorage()
+	}
+	return nil, ErrUnknownStorageDriver
+}
+
+// NewObjectKey returns a new random key for an uploaded file
+func NewObjectKey() (string, error) {
+	key, err := randomHexString(64)
+	if err != nil {
+		return "", err
+	}
+	return "uploads/" + key, nil
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/pkg/lexorank"
+	"github.com/darmiel/perplex/pkg/model"
+	"github.com/darmiel/perplex/pkg/util"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("meeting template not found")
+	ErrInvalidTemplate  = errors.New("tags, priorities and users of the template must belong to the project")
+)
+
+// TemplateService manages meeting templates and creates meetings from them
+type TemplateService interface {
+	// CreateTemplate creates the template with its topics. Tags, priorities and users must belong to the project.
+	// The creation is audited as performed by the creator of the template
+	CreateTemplate(template *model.MeetingTemplate, tagIDs []uint, userIDs []string) error
+	FindTemplates(projectID uint) ([]model.MeetingTemplate, error)
+	FindTemplate(projectID, templateID uint) (*model.MeetingTemplate, error)
+	// UpdateTemplate saves the template and replaces its tags, users and topics
+	UpdateTemplate(template *model.MeetingTemplate, tagIDs []uint, userIDs []string, actorID string) error
+	DeleteTemplate(template *model.MeetingTemplate, actorID string) error
+	// CreateMeeting creates a meeting with the tags, users and topics of the template in one transaction.
+	// The creation is audited as performed by the creator of the meeting
+	CreateMeeting(
+		template *model.MeetingTemplate,
+		creatorID, name, description string,
+		startDate, endDate time.Time,
+	) (*model.Meeting, error)
+}
+
+type templateService struct {
+	DB      *gorm.DB
+	projSrv ProjectService
+}
+
+func NewTemplateService(db *gorm.DB, projSrv ProjectService) TemplateService {
+	return &templateService{
+		DB:      db,
+		projSrv: projSrv,
+	}
+}
+
+// checkReferences checks that the tags, priorities and users of the template belong to the project
+func (t *templateService) checkReferences(template *model.MeetingTemplate, tagIDs []uint, userIDs []string) error {
+	var count int64
+	if len(tagIDs) > 0 {
+		if err := t.DB.Model(&model.Tag{}).
+			Where("id IN ? AND project_id = ?", tagIDs, template.ProjectID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count != int64(len(tagIDs)) {
+			return ErrInvalidTemplate
+		}
+	}
+	var priorityIDs []uint
+	for _, topic := range template.Topics {
+		if topic.PriorityID != nil {
+			priorityIDs = append(priorityIDs, *topic.PriorityID)
+		}
+	}
+	if len(priorityIDs) > 0 {
+		var found []uint
+		if err := t.DB.Model(&model.Priority{}).
+			Where("id IN ? AND project_id = ?", priorityIDs, template.ProjectID).
+			Pluck("id", &found).Error; err != nil {
+			return err
+		}
+		known := make(map[uint]bool, len(found))
+		for _, id := range found {
+			known[id] = true
+		}
+		for _, id := range priorityIDs {
+			if !known[id] {
+				return ErrInvalidTemplate
+			}
+		}
+	}
+	if len(userIDs) > 0 {
+		project, err := t.projSrv.FindProject(template.ProjectID, "Members")
+		if err != nil {
+			return err
+		}
+		for _, id := range userIDs {
+			if _, ok := util.RoleOf(project, id); !ok {
+				return ErrInvalidTemplate
+			}
+		}
+	}
+	return nil
+}
+
+// setAssociations replaces the tags and users of the template
+func setAssociations(tx *gorm.DB, template *model.MeetingTemplate, tagIDs []uint, userIDs []string) error {
+	tags := make([]model.Tag, len(tagIDs))
+	for i, id := range tagIDs {
+		tags[i] = model.Tag{Model: gorm.Model{ID: id}}
+	}
+	users := make([]model.User, len(userIDs))
+	for i, id := range userIDs {
+		users[i] = model.User{ID: id}
+	}
+	// don't upsert the (incomplete) tags and users, only replace the associations
+	if err := tx.Omit("Tags.*").Model(template).Association("Tags").Replace(tags); err != nil {
+		return err
+	}
+	return tx.Omit("AssignedUsers.*").Model(template).Association("AssignedUsers").Replace(users)
+}
+
+func (t *templateService) CreateTemplate(template *model.MeetingTemplate, tagIDs []uint, userIDs []string) error {
+	if err := t.checkReferences(template, tagIDs, userIDs); err != nil {
+		return err
+	}
+	for i := range template.Topics {
+		template.Topics[i].Position = i
+	}
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Tags", "AssignedUsers").Create(template).Error; err != nil {
+			return err
+		}
+		if err := setAssociations(tx, template, tagIDs, userIDs); err != nil {
+			return err
+		}
+		return auditEntity(tx, template.CreatorID, model.AuditEntityMeetingTemplate, template.ID,
+			model.AuditActionCreate, nil, template)
+	})
+}
+
+func (t *templateService) preload() *gorm.DB {
+	return t.DB.Preload("Tags").
+		Preload("AssignedUsers").
+		Preload("Topics", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		})
+}
+
+func (t *templateService) FindTemplates(projectID uint) (res []model.MeetingTemplate, err error) {
+	err = t.preload().
+		Where("project_id = ?", projectID).
+		Order("name").
+		Find(&res).Error
+	return
+}
+
+func (t *templateService) FindTemplate(projectID, templateID uint) (*model.MeetingTemplate, error) {
+	var templates []model.MeetingTemplate
+	if err := t.preload().
+		Where("id = ? AND project_id = ?", templateID, projectID).
+		Limit(1).
+		Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, ErrTemplateNotFound
+	}
+	return &templates[0], nil
+}
+
+func (t *templateService) UpdateTemplate(template *model.MeetingTemplate, tagIDs []uint, userIDs []string, actorID string) error {
+	if err := t.checkReferences(template, tagIDs, userIDs); err != nil {
+		return err
+	}
+	for i := range template.Topics {
+		template.Topics[i].ID = 0
+		template.Topics[i].TemplateID = template.ID
+		template.Topics[i].Position = i
+	}
+	return auditChanges[model.MeetingTemplate](t.DB, actorID, model.AuditEntityMeetingTemplate, template.ID,
+		model.AuditActionUpdate, func(tx *gorm.DB) error {
+			if err := tx.Model(template).
+				Select("name", "description", "duration").
+				Updates(template).Error; err != nil {
+				return err
+			}
+			// the topics are replaced, so their order matches the request
+			if err := tx.Where("template_id = ?", template.ID).
+				Delete(&model.TemplateTopic{}).Error; err != nil {
+				return err
+			}
+			if len(template.Topics) > 0 {
+				if err := tx.Create(&template.Topics).Error; err != nil {
+					return err
+				}
+			}
+			return setAssociations(tx, template, tagIDs, userIDs)
+		})
+}
+
+func (t *templateService) DeleteTemplate(template *model.MeetingTemplate, actorID string) error {
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", template.ID).
+			Delete(&model.TemplateTopic{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("Tags", "AssignedUsers").Delete(template).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, actorID, model.AuditEntityMeetingTemplate, template.ID,
+			model.AuditActionDelete, template, nil)
+	})
+}
+
+func (t *templateService) CreateMeeting(
+	template *model.MeetingTemplate,
+	creatorID, name, description string,
+	startDate, endDate time.Time,
+) (*model.Meeting, error) {
+	meeting := &model.Meeting{
+		Name:          name,
+		Description:   description,
+		StartDate:     startDate,
+		EndDate:       endDate,
+		ProjectID:     template.ProjectID,
+		CreatorID:     creatorID,
+		Tags:          template.Tags,
+		AssignedUsers: template.AssignedUsers,
+	}
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Tags.*", "AssignedUsers.*").Create(meeting).Error; err != nil {
+			return err
+		}
+		if err := auditEntity(tx, creatorID, model.AuditEntityMeeting, meeting.ID,
+			model.AuditActionCreate, nil, meeting); err != nil {
+			return err
+		}
+		if len(template.Topics) == 0 {
+			return nil
+		}
+		topics := make([]model.Topic, len(template.Topics))
+		for i, skeleton := range template.Topics {
+			topics[i] = model.Topic{
+				Title:         skeleton.Title,
+				Description:   skeleton.Description,
+				CreatorID:     creatorID,
+				ForceSolution: skeleton.ForceSolution,
+				MeetingID:     meeting.ID,
+				PriorityID:    skeleton.PriorityID,
+				// same ranks as topics added one after another
+				LexoRank: lexorank.GetAlphabetForIndex(int64(i)),
+			}
+		}
+		return tx.Create(&topics).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meeting, nil
+}
@@ -11,24 +11,27 @@ import (
 )
 
 type TopicService interface {
+	// AddTopic adds the topic to the meeting. The mutations of topics are audited as performed by the actor
 	AddTopic(creatorID string, meetingID uint, title, description string, forceSolution bool, priorityID uint) (*model.Topic, error)
 	GetTopic(topicID uint, preload ...string) (*model.Topic, error)
 	ListTopicsForMeeting(meetingID uint) ([]*model.Topic, error)
-	DeleteTopic(topicID uint) error
-	EditTopic(topicID uint, title, description string, forceSolution bool, priorityID uint) error
+	DeleteTopic(topicID uint, actorID string) error
+	EditTopic(topicID uint, title, description string, forceSolution bool, priorityID uint, actorID string) error
 
-	SetLexoRank(topicID uint, rank lexorank.Rank) error
+	SetLexoRank(topicID uint, rank lexorank.Rank, actorID string) error
 	FindLexoRankTop(meetingID uint) (topic model.Topic, err error)
 	FindLexoRankBottom(meetingID uint) (topic model.Topic, err error)
 
-	SetSolution(topicID uint, commentID uint) error
-	CheckTopic(topicID uint) error
-	UncheckTopic(topicID uint) error
+	SetSolution(topicID uint, commentID uint, actorID string) error
+	CheckTopic(topicID uint, actorID string) error
+	UncheckTopic(topicID uint, actorID string) error
 	Extend(topic *model.Topic, preload ...string) error
-	LinkTag(topicID, tagID uint) error
-	UnlinkTag(topicID, tagID uint) error
-	LinkUser(topicID uint, userID string) error
-	UnlinkUser(topicID uint, userID string) error
+	LinkTag(topicID, tagID uint, actorID string) error
+	UnlinkTag(topicID, tagID uint, actorID string) error
+	LinkFile(topicID, fileID uint, actorID string) error
+	UnlinkFile(topicID, fileID uint, actorID string) error
+	LinkUser(topicID uint, userID, actorID string) error
+	UnlinkUser(topicID uint, userID, actorID string) error
 	SubscribeUser(topicID uint, userID string) error
 	UnsubscribeUser(topicID uint, userID string) error
 	IsSubscribed(topicID uint, userID string) (bool, error)
@@ -49,7 +52,8 @@ func NewTopicService(db *gorm.DB, projSrv ProjectService) TopicService {
 func (m *topicService) preload() *gorm.DB {
 	return m.DB.Preload("Tags").
 		Preload("AssignedUsers").
-		Preload("Priority")
+		Preload("Priority").
+		Preload("Files")
 }
 
 func (m *topicService) AddTopic(
@@ -82,7 +86,12 @@ func (m *topicService) AddTopic(
 		PriorityID:    priorityIDCreate,
 		LexoRank:      lexorank.GetAlphabetForIndex(count),
 	}
-	err = m.DB.Create(res).Error
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(res).Error; err != nil {
+			return err
+		}
+		return auditEntity(tx, creatorID, model.AuditEntityTopic, res.ID, model.AuditActionCreate, nil, res)
+	})
 	return
 }
 
@@ -109,22 +118,30 @@ func (m *topicService) ListTopicsForMeeting(meetingID uint) (res []*model.Topic,
 	return
 }
 
-func (m *topicService) DeleteTopic(topicID uint) error {
-	res := m.DB.Delete(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
+func (m *topicService) DeleteTopic(topicID uint, actorID string) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var topic model.Topic
+		if err := tx.First(&topic, topicID).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&topic)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected <= 0 {
+			return ErrNotMatches
+		}
+		return auditEntity(tx, actorID, model.AuditEntityTopic, topicID, model.AuditActionDelete, &topic, nil)
 	})
-	if res.Error != nil {
-		return res.Error
-	}
-	if res.RowsAffected <= 0 {
-		return ErrNotMatches
-	}
-	return nil
 }
 
-func (m *topicService) EditTopic(topicID uint, title, description string, forceSolution bool, priorityID uint) error {
+func (m *topicService) EditTopic(
+	topicID uint,
+	title, description string,
+	forceSolution bool,
+	priorityID uint,
+	actorID string,
+) error {
 	var priorityIDEdit interface{} = nil
 	if priorityID != 0 {
 		if _, err := m.projSrv.FindPriority(priorityID); err != nil {
@@ -132,39 +149,50 @@ func (m *topicService) EditTopic(topicID uint, title, description string, forceS
 		}
 		priorityIDEdit = priorityID
 	}
-	return m.DB.Updates(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-		Title:       title,
-		Description: description,
-	}).Update("force_solution", forceSolution).
-		Update("PriorityID", priorityIDEdit).
-		Error
+	return auditChanges[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Updates(&model.Topic{
+				Model: gorm.Model{
+					ID: topicID,
+				},
+				Title:       title,
+				Description: description,
+			}).Update("force_solution", forceSolution).
+				Update("PriorityID", priorityIDEdit).
+				Error
+		})
 }
 
-func (m *topicService) SetSolution(topicID uint, commentID uint) error {
-	return m.DB.Model(&model.Topic{}).
-		Where("id = ?", topicID).
-		Update("solution_id", commentID).
-		Error
+func (m *topicService) SetSolution(topicID uint, commentID uint, actorID string) error {
+	return auditChanges[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Topic{}).
+				Where("id = ?", topicID).
+				Update("solution_id", commentID).
+				Error
+		})
 }
 
-func (m *topicService) toggleTopic(topicID uint, close bool) error {
+func (m *topicService) toggleTopic(topicID uint, close bool, actorID string) error {
 	var t sql.NullTime
+	action := model.AuditActionOpen
 	if close {
 		t.Time = time.Now()
 		t.Valid = true
+		action = model.AuditActionClose
 	}
-	return m.DB.Model(&model.Topic{}).Where("id = ?", topicID).Update("closed_at", t).Error
+	return auditChanges[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, action,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Topic{}).Where("id = ?", topicID).Update("closed_at", t).Error
+		})
 }
 
-func (m *topicService) CheckTopic(topicID uint) error {
-	return m.toggleTopic(topicID, true)
+func (m *topicService) CheckTopic(topicID uint, actorID string) error {
+	return m.toggleTopic(topicID, true, actorID)
 }
 
-func (m *topicService) UncheckTopic(topicID uint) error {
-	return m.toggleTopic(topicID, false)
+func (m *topicService) UncheckTopic(topicID uint, actorID string) error {
+	return m.toggleTopic(topicID, false, actorID)
 }
 
 func (m *topicService) Extend(topic *model.Topic, preload ...string) error {
@@ -175,56 +203,34 @@ func (m *topicService) Extend(topic *model.Topic, preload ...string) error {
 	return q.First(topic).Error
 }
 
-func (m *topicService) LinkTag(topicID, tagID uint) error {
-	return m.DB.Model(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-	}).
-		Association("Tags").
-		Append(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
-		})
+func (m *topicService) LinkTag(topicID, tagID uint, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionLink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
 }
 
-func (m *topicService) UnlinkTag(topicID, tagID uint) error {
-	return m.DB.Model(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-	}).
-		Association("Tags").
-		Delete(&model.Tag{
-			Model: gorm.Model{
-				ID: tagID,
-			},
-		})
+func (m *topicService) UnlinkTag(topicID, tagID uint, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUnlink,
+		"Tags", &model.Tag{Model: gorm.Model{ID: tagID}}, "tag_id", tagID)
 }
 
-func (m *topicService) LinkUser(topicID uint, userID string) error {
-	return m.DB.Model(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-	}).
-		Association("AssignedUsers").
-		Append(&model.User{
-			ID: userID,
-		})
+func (m *topicService) LinkFile(topicID, fileID uint, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionLink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
 }
 
-func (m *topicService) UnlinkUser(topicID uint, userID string) error {
-	return m.DB.Model(&model.Topic{
-		Model: gorm.Model{
-			ID: topicID,
-		},
-	}).
-		Association("AssignedUsers").
-		Delete(&model.User{
-			ID: userID,
-		})
+func (m *topicService) UnlinkFile(topicID, fileID uint, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUnlink,
+		"Files", &model.ProjectFile{Model: gorm.Model{ID: fileID}}, "file_id", fileID)
+}
+
+func (m *topicService) LinkUser(topicID uint, userID, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionLink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
+}
+
+func (m *topicService) UnlinkUser(topicID uint, userID, actorID string) error {
+	return auditLink[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUnlink,
+		"AssignedUsers", &model.User{ID: userID}, "user_id", userID)
 }
 
 func (m *topicService) SubscribeUser(topicID uint, userID string) error {
@@ -265,11 +271,14 @@ func (m *topicService) IsSubscribed(topicID uint, userID string) (bool, error) {
 	return false, nil
 }
 
-func (m *topicService) SetLexoRank(topicID uint, rank lexorank.Rank) error {
-	return m.DB.Model(&model.Topic{}).
-		Where("id = ?", topicID).
-		Update("lexo_rank", rank).
-		Error
+func (m *topicService) SetLexoRank(topicID uint, rank lexorank.Rank, actorID string) error {
+	return auditChanges[model.Topic](m.DB, actorID, model.AuditEntityTopic, topicID, model.AuditActionUpdate,
+		func(tx *gorm.DB) error {
+			return tx.Model(&model.Topic{}).
+				Where("id = ?", topicID).
+				Update("lexo_rank", rank).
+				Error
+		})
 }
 
 func (m *topicService) FindLexoRankTop(meetingID uint) (topic model.Topic, err error) {
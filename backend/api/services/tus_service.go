@@ -0,0 +1,280 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTusOffsetMismatch = errors.New("upload offset does not match")
+	ErrTusUploadLocked   = errors.New("upload is currently in use")
+	ErrTusUploadExpired  = errors.New("upload expired")
+	ErrTusUploadComplete = errors.New("upload already complete")
+	ErrTusUploadFinished = errors.New("upload was already finished")
+)
+
+// tusUploadTTL is the time an incomplete upload is kept after the last received chunk
+const tusUploadTTL = 24 * time.Hour
+
+// TusService manages resumable uploads using the tus protocol.
+// Partial uploads are stored in TUS_UPLOAD_PATH (default: data/tus) and handed to the
+// storage driver once all bytes were received
+type TusService interface {
+	// CreateUpload creates the upload and its partial file.
+	// The project and expiry date of the upload are set by the service
+	CreateUpload(project *model.Project, upload *model.ProjectFileTusUpload) error
+	FindUpload(uploadID uint) (*model.ProjectFileTusUpload, error)
+	// WriteChunk appends the body to the upload if offset matches the current offset of the upload.
+	// The received bytes are kept even if reading the body fails, so the client can resume the upload.
+	// It returns the new offset of the upload
+	WriteChunk(project *model.Project, upload *model.ProjectFileTusUpload, offset, chunkLength int64, body io.Reader) (int64, error)
+	// FinishUpload stores the complete upload and creates the project file
+	// or adds a new version to the file if the upload has a FileID
+	FinishUpload(upload *model.ProjectFileTusUpload) (*model.ProjectFile, error)
+	DeleteUpload(upload *model.ProjectFileTusUpload) error
+	// CleanupExpiredUploads deletes all expired incomplete uploads
+	CleanupExpiredUploads() (int, error)
+}
+
+type tusService struct {
+	DB        *gorm.DB
+	storage   StorageService
+	objectSrv ObjectService
+	scanSrv   ScanService
+	quotaSrv  QuotaService
+	basePath  string
+	// locks prevents concurrent writes to the same upload
+	locks sync.Map
+}
+
+func NewTusService(
+	db *gorm.DB,
+	storage StorageService,
+	objectSrv ObjectService,
+	scanSrv ScanService,
+	quotaSrv QuotaService,
+) (TusService, error) {
+	basePath, ok := os.LookupEnv("TUS_UPLOAD_PATH")
+	if !ok {
+		basePath = "data/tus"
+	}
+	if err := os.MkdirAll(basePath, 0o750); err != nil {
+		return nil, err
+	}
+	return &tusService{
+		DB:        db,
+		storage:   storage,
+		objectSrv: objectSrv,
+		scanSrv:   scanSrv,
+		quotaSrv:  quotaSrv,
+		basePath:  basePath,
+	}, nil
+}
+
+// path returns the path of the partial file of an upload
+func (t *tusService) path(uploadID uint) string {
+	return filepath.Join(t.basePath, fmt.Sprintf("%d.part", uploadID))
+}
+
+// lock locks the upload for writing. It returns false if the upload is already locked
+func (t *tusService) lock(uploadID uint) (func(), bool) {
+	m, _ := t.locks.LoadOrStore(uploadID, new(sync.Mutex))
+	mu := m.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func (t *tusService) CreateUpload(project *model.Project, upload *model.ProjectFileTusUpload) error {
+	// fail early if the complete file would not fit into the quota
+	if err := checkUploadQuota(t.DB, t.quotaSrv, project, upload.CreatorID, upload.Length); err != nil {
+		return err
+	}
+	upload.ProjectID = project.ID
+	upload.ExpiresAt = time.Now().Add(tusUploadTTL)
+	if err := t.DB.Create(upload).Error; err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.path(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		_ = t.DB.Unscoped().Delete(upload).Error
+		return err
+	}
+	return f.Close()
+}
+
+func (t *tusService) FindUpload(uploadID uint) (*model.ProjectFileTusUpload, error) {
+	var upload model.ProjectFileTusUpload
+	if err := t.DB.First(&upload, uploadID).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (t *tusService) WriteChunk(
+	project *model.Project,
+	upload *model.ProjectFileTusUpload,
+	offset, chunkLength int64,
+	body io.Reader,
+) (int64, error) {
+	unlock, ok := t.lock(upload.ID)
+	if !ok {
+		return upload.Offset, ErrTusUploadLocked
+	}
+	defer unlock()
+
+	// reload the upload since another request could have written to it in the meantime
+	current, err := t.FindUpload(upload.ID)
+	if err != nil {
+		return upload.Offset, err
+	}
+	*upload = *current
+	if upload.IsExpired() {
+		return upload.Offset, ErrTusUploadExpired
+	}
+	if upload.Offset != offset {
+		return upload.Offset, ErrTusOffsetMismatch
+	}
+	remaining := upload.Length - upload.Offset
+	if remaining <= 0 {
+		return upload.Offset, ErrTusUploadComplete
+	}
+	if chunkLength < 0 || chunkLength > remaining {
+		chunkLength = remaining
+	}
+	// the quota is checked for every chunk since the received bytes count towards the quota
+	if err = checkQuotaLeft(t.DB, project, chunkLength); err != nil {
+		return upload.Offset, err
+	}
+	if err = checkUserQuotaLeft(t.DB, t.quotaSrv, upload.CreatorID, chunkLength); err != nil {
+		return upload.Offset, err
+	}
+
+	f, err := os.OpenFile(t.path(upload.ID), os.O_WRONLY, 0o640)
+	if err != nil {
+		return upload.Offset, err
+	}
+	// truncate bytes of a previously failed write which were not committed
+	if err = f.Truncate(upload.Offset); err != nil {
+		_ = f.Close()
+		return upload.Offset, err
+	}
+	if _, err = f.Seek(upload.Offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return upload.Offset, err
+	}
+	written, copyErr := io.Copy(f, io.LimitReader(body, chunkLength))
+	if err = f.Close(); err != nil {
+		return upload.Offset, err
+	}
+	if written > 0 {
+		newOffset, expiresAt := upload.Offset+written, time.Now().Add(tusUploadTTL)
+		if err = t.DB.Model(upload).Updates(map[string]any{
+			"upload_offset": newOffset,
+			"expires_at":    expiresAt,
+		}).Error; err != nil {
+			return upload.Offset, err
+		}
+		upload.Offset, upload.ExpiresAt = newOffset, expiresAt
+	}
+	return upload.Offset, copyErr
+}
+
+func (t *tusService) FinishUpload(upload *model.ProjectFileTusUpload) (*model.ProjectFile, error) {
+	if upload.Offset != upload.Length {
+		return nil, ErrTusOffsetMismatch
+	}
+	f, err := os.Open(t.path(upload.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// the content type of the upload metadata is not trusted
+	contentType, body, err := DetectContentType(f)
+	if err != nil {
+		return nil, err
+	}
+	object, err := t.objectSrv.Store(body, upload.Length, PutOptions{
+		ContentType: contentType,
+		Tags: map[string]string{
+			"ProjectID": strconv.FormatUint(uint64(upload.ProjectID), 10),
+			"UserID":    upload.CreatorID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	scan := t.scanSrv.ScanObject(object.ObjectKey)
+	var file *model.ProjectFile
+	err = t.DB.Transaction(func(tx *gorm.DB) (err error) {
+		// the upload is claimed first, so concurrent requests cannot both create the file
+		result := tx.Unscoped().Delete(upload)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected <= 0 {
+			return ErrTusUploadFinished
+		}
+		if upload.FileID != 0 {
+			file, err = addFileVersion(tx, upload.FileID, object, scan, upload.CreatorID)
+			return
+		}
+		file = &model.ProjectFile{
+			Name:           upload.Name,
+			ObjectKey:      object.ObjectKey,
+			Size:           object.Size,
+			Hash:           object.Hash,
+			ScanStatus:     scan.Status,
+			ScanSignature:  scan.Signature,
+			CreatorID:      upload.CreatorID,
+			LastAccessedAt: time.Now(),
+		}
+		return NewProjectService(tx).CreateFile(upload.ProjectID, file)
+	})
+	if err != nil {
+		_ = t.objectSrv.Release(object.ObjectKey)
+		return nil, err
+	}
+	if err = t.DeleteUpload(upload); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (t *tusService) DeleteUpload(upload *model.ProjectFileTusUpload) error {
+	if err := os.Remove(t.path(upload.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	t.locks.Delete(upload.ID)
+	return t.DB.Unscoped().Delete(upload).Error
+}
+
+func (t *tusService) CleanupExpiredUploads() (int, error) {
+	var expired []model.ProjectFileTusUpload
+	if err := t.DB.Where("expires_at <= ?", time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	// uploads which cannot be deleted don't prevent the cleanup of the remaining uploads
+	var (
+		deleted int
+		errs    []error
+	)
+	for i := range expired {
+		if err := t.DeleteUpload(&expired[i]); err != nil {
+			errs = append(errs, fmt.Errorf("tus upload %d: %w", expired[i].ID, err))
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/darmiel/perplex/pkg/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+var (
+	ErrFileTooBig            = errors.New("file too big")
+	ErrNoFileQuotaLeft       = errors.New("no file quota left")
+	ErrUploadNotFound        = errors.New("uploaded file not found")
+	ErrUploadSizeMismatch    = errors.New("uploaded size does not match the announced size")
+	ErrUploadETagMismatch    = errors.New("uploaded etag does not match")
+	ErrUploadReservationGone = errors.New("upload reservation expired")
+)
+
+type UploadService interface {
+	// UsedQuota returns the size of all files (including previous versions), active upload reservations
+	// and already received bytes of resumable uploads of the project
+	UsedQuota(projectID uint) (uint64, error)
+	// CheckQuota checks if a file with the given size can be added to the project by the user
+	CheckQuota(project *model.Project, userID string, size int64) error
+	// ReserveUpload reserves the announced size of the upload for the given duration.
+	// The object key, project and expiry date of the upload are set by the service
+	ReserveUpload(project *model.Project, upload *model.ProjectFileUpload, ttl time.Duration) error
+	FindUpload(uploadID uint) (*model.ProjectFileUpload, error)
+	// FindUploadByKey returns the active reservation of the object key
+	// or ErrUploadReservationGone if there is none
+	FindUploadByKey(key string) (*model.ProjectFileUpload, error)
+	// CompleteUpload verifies the uploaded object and creates the project file
+	// or adds a new version to the file if the upload has a FileID
+	CompleteUpload(upload *model.ProjectFileUpload, etag string) (*model.ProjectFile, error)
+	CancelUpload(upload *model.ProjectFileUpload) error
+	// CleanupExpiredUploads deletes all expired reservations and their (partially) uploaded objects
+	CleanupExpiredUploads() (int, error)
+}
+
+type uploadService struct {
+	DB        *gorm.DB
+	storage   StorageService
+	objectSrv ObjectService
+	scanSrv   ScanService
+	quotaSrv  QuotaService
+}
+
+func NewUploadService(
+	db *gorm.DB,
+	storage StorageService,
+	objectSrv ObjectService,
+	scanSrv ScanService,
+	quotaSrv QuotaService,
+) UploadService {
+	return &uploadService{
+		DB:        db,
+		storage:   storage,
+		objectSrv: objectSrv,
+		scanSrv:   scanSrv,
+		quotaSrv:  quotaSrv,
+	}
+}
+
+// storedSize returns the size of all files and previous versions of the project.
+// Identical content is only counted once per project
+func storedSize(tx *gorm.DB, projectID uint) (uint64, error) {
+	var size *uint64
+	if err := tx.Raw(`SELECT sum(size) FROM (
+		SELECT object_key, size FROM project_files WHERE project_id = ? AND deleted_at IS NULL
+		UNION
+		SELECT object_key, size FROM project_file_versions WHERE project_id = ? AND deleted_at IS NULL
+	) AS objects`, projectID, projectID).
+		Scan(&size).Error; err != nil {
+		return 0, err
+	}
+	if size == nil {
+		return 0, nil
+	}
+	return *size, nil
+}
+
+func usedQuota(tx *gorm.DB, projectID uint) (uint64, error) {
+	total, err := storedSize(tx, projectID)
+	if err != nil {
+		return 0, err
+	}
+	var reserved, partial *uint64
+	if err := tx.Model(&model.ProjectFileUpload{}).
+		Where("project_id = ? AND expires_at > ?", projectID, time.Now()).
+		Select("sum(size)").
+		Scan(&reserved).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&model.ProjectFileTusUpload{}).
+		Where("project_id = ? AND expires_at > ?", projectID, time.Now()).
+		Select("sum(upload_offset)").
+		Scan(&partial).Error; err != nil {
+		return 0, err
+	}
+	for _, size := range []*uint64{reserved, partial} {
+		if size != nil {
+			total += *size
+		}
+	}
+	return total, nil
+}
+
+func checkQuota(tx *gorm.DB, project *model.Project, size int64) error {
+	if project.MaxProjectFileSize >= 0 && size > project.MaxProjectFileSize {
+		return ErrFileTooBig
+	}
+	return checkQuotaLeft(tx, project, size)
+}
+
+// checkUploadQuota checks the quota of the project and the quota of the uploading user
+func checkUploadQuota(tx *gorm.DB, quotaSrv QuotaService, project *model.Project, userID string, size int64) error {
+	if err := checkQuota(tx, project, size); err != nil {
+		return err
+	}
+	return checkUserQuotaLeft(tx, quotaSrv, userID, size)
+}
+
+// checkQuotaLeft checks if the project has enough quota left for the given amount of bytes
+func checkQuotaLeft(tx *gorm.DB, project *model.Project, size int64) error {
+	if project.ProjectFileSizeQuota < 0 {
+		return nil
+	}
+	used, err := usedQuota(tx, project.ID)
+	if err != nil {
+		return err
+	}
+	if used+uint64(size) > uint64(project.ProjectFileSizeQuota) {
+		return ErrNoFileQuotaLeft
+	}
+	return nil
+}
+
+// lockProject locks the project until the end of the transaction, so concurrent quota checks
+// of the project cannot all pass before their reservations are stored
+func lockProject(tx *gorm.DB, projectID uint) error {
+	var ids []uint
+	return tx.Model(&model.Project{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", projectID).
+		Pluck("id", &ids).Error
+}
+
+func (u *uploadService) UsedQuota(projectID uint) (uint64, error) {
+	return usedQuota(u.DB, projectID)
+}
+
+func (u *uploadService) CheckQuota(project *model.Project, userID string, size int64) error {
+	return checkUploadQuota(u.DB, u.quotaSrv, project, userID, size)
+}
+
+func (u *uploadService) ReserveUpload(project *model.Project, upload *model.ProjectFileUpload, ttl time.Duration) error {
+	key, err := NewObjectKey()
+	if err != nil {
+		return err
+	}
+	return u.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockProject(tx, project.ID); err != nil {
+			return err
+		}
+		if err := checkUploadQuota(tx, u.quotaSrv, project, upload.CreatorID, upload.Size); err != nil {
+			return err
+		}
+		upload.ObjectKey = key
+		upload.ProjectID = project.ID
+		upload.ExpiresAt = time.Now().Add(ttl)
+		return tx.Create(upload).Error
+	})
+}
+
+func (u *uploadService) FindUpload(uploadID uint) (*model.ProjectFileUpload, error) {
+	var upload model.ProjectFileUpload
+	if err := u.DB.First(&upload, uploadID).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (u *uploadService) FindUploadByKey(key string) (*model.ProjectFileUpload, error) {
+	var uploads []model.ProjectFileUpload
+	if err := u.DB.Where("object_key = ? AND expires_at > ?", key, time.Now()).
+		Limit(1).
+		Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+	if len(uploads) == 0 {
+		return nil, ErrUploadReservationGone
+	}
+	return &uploads[0], nil
+}
+
+func (u *uploadService) CompleteUpload(upload *model.ProjectFileUpload, etag string) (res *model.ProjectFile, err error) {
+	if upload.IsExpired() {
+		return nil, ErrUploadReservationGone
+	}
+	info, err := u.storage.Stat(upload.ObjectKey)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if info.Size != upload.Size {
+		return nil, ErrUploadSizeMismatch
+	}
+	// ETags are compared without quotes since not all clients preserve them
+	if etag != "" && strings.Trim(etag, `"`) != strings.Trim(info.ETag, `"`) {
+		return nil, ErrUploadETagMismatch
+	}
+	// the uploaded object is copied to a key the uploader cannot write to, so the content
+	// cannot be replaced after it was hashed and scanned
+	object, err := u.objectSrv.Adopt(upload.ObjectKey, info.Size, PutOptions{ContentType: upload.ContentType})
+	if err != nil {
+		return nil, err
+	}
+	scan := u.scanSrv.ScanObject(object.ObjectKey)
+	err = u.DB.Transaction(func(tx *gorm.DB) error {
+		// the reservation is deleted first so a concurrent completion cannot create the file twice
+		result := tx.Unscoped().Delete(upload)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected <= 0 {
+			return ErrUploadReservationGone
+		}
+		if upload.FileID != 0 {
+			res, err = addFileVersion(tx, upload.FileID, object, scan, upload.CreatorID)
+			return err
+		}
+		res = &model.ProjectFile{
+			Name:           upload.Name,
+			ObjectKey:      object.ObjectKey,
+			Size:           object.Size,
+			Hash:           object.Hash,
+			ScanStatus:     scan.Status,
+			ScanSignature:  scan.Signature,
+			ProjectID:      upload.ProjectID,
+			CreatorID:      upload.CreatorID,
+			LastAccessedAt: time.Now(),
+		}
+		return NewProjectService(tx).CreateFile(upload.ProjectID, res)
+	})
+	if err != nil {
+		_ = u.objectSrv.Release(object.ObjectKey)
+		return nil, err
+	}
+	return res, nil
+}
+
+func (u *uploadService) CancelUpload(upload *model.ProjectFileUpload) error {
+	if err := u.storage.Delete(upload.ObjectKey); err != nil {
+		return err
+	}
+	return u.DB.Unscoped().Delete(upload).Error
+}
+
+func (u *uploadService) CleanupExpiredUploads() (int, error) {
+	var expired []model.ProjectFileUpload
+	if err := u.DB.Where("expires_at <= ?", time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	// uploads which cannot be deleted don't prevent the cleanup of the remaining uploads
+	var (
+		deleted int
+		errs    []error
+	)
+	for i := range expired {
+		if err := u.CancelUpload(&expired[i]); err != nil {
+			errs = append(errs, fmt.Errorf("upload %d: %w", expired[i].ID, err))
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -13,6 +13,7 @@ import (
 type UserService interface {
 	FindUser(userID string) (*model.User, error)
 	ChangeName(userID, newName string) error
+	SetEmail(userID, email string) error
 	GetName(userID string) (string, error)
 	ListUsers(query string, page int) (res []*model.User, err error)
 	ListUpcomingMeetings(userID string) []*model.Meeting
@@ -24,16 +25,18 @@ type UserService interface {
 }
 
 type userService struct {
-	DB      *gorm.DB
-	projSrv ProjectService
-	meetSrv MeetingService
+	DB        *gorm.DB
+	projSrv   ProjectService
+	meetSrv   MeetingService
+	seriesSrv SeriesService
 }
 
-func NewUserService(db *gorm.DB, projSrv ProjectService, meetSrv MeetingService) UserService {
+func NewUserService(db *gorm.DB, projSrv ProjectService, meetSrv MeetingService, seriesSrv SeriesService) UserService {
 	return &userService{
-		DB:      db,
-		projSrv: projSrv,
-		meetSrv: meetSrv,
+		DB:        db,
+		projSrv:   projSrv,
+		meetSrv:   meetSrv,
+		seriesSrv: seriesSrv,
 	}
 }
 
@@ -44,13 +47,21 @@ func (u userService) FindUser(userID string) (res *model.User, err error) {
 
 func (u userService) ChangeName(userID, newName string) error {
 	return u.DB.Clauses(clause.OnConflict{
-		UpdateAll: true,
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_name", "updated_at"}),
 	}).Create(&model.User{
 		ID:       userID,
 		UserName: newName,
 	}).Error
 }
 
+func (u userService) SetEmail(userID, email string) error {
+	return u.DB.Model(&model.User{}).
+		Where("id = ?", userID).
+		Update("email", email).
+		Error
+}
+
 func (u userService) GetName(userID string) (res string, err error) {
 	var user model.User
 	if err = u.DB.First(&user, &model.User{ID: userID}).Error; err == nil {
@@ -86,6 +97,8 @@ func (u userService) ListUpcomingMeetings(userID string) (res []*model.Meeting)
 	// get all meetings from these projects
 	meetings := make(map[uint]*model.Meeting)
 	for p := range projects {
+		// create the upcoming occurrences of recurring meetings (already created meetings are listed anyway)
+		_ = u.seriesSrv.Materialize(p, time.Now().Add(SeriesHorizon))
 		if m, err := u.meetSrv.FindMeetingsForProject(p); err == nil {
 			for _, meeting := range m {
 				fmt.Println("meeting", meeting.Name, meeting.StartDate)
@@ -3,14 +3,15 @@ package main
 import (
 	"context"
 	firebase "firebase.google.com/go"
+	"fmt"
 	"github.com/darmiel/perplex/api/handlers"
 	"github.com/darmiel/perplex/api/routes"
 	"github.com/darmiel/perplex/api/services"
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/darmiel/perplex/pkg/model"
 	"github.com/darmiel/perplex/pkg/util"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 	"go.uber.org/zap"
 	"google.golang.org/api/option"
 	"gorm.io/driver/postgres"
@@ -20,6 +21,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -27,15 +29,9 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
-	// firebase auth
-	fbApp, err := firebase.NewApp(context.TODO(), nil, option.WithCredentialsFile("config/firebase.json"))
-	if err != nil {
-		sugar.With(err).Fatalln("cannot create firebase app")
-		return
-	}
-
 	// database setup
 	var db *gorm.DB
+	var err error
 	if sqlitePath, ok := os.LookupEnv("SQLITE_PATH"); ok {
 		db, err = gorm.Open(sqlite.Open(sqlitePath))
 	} else if postgresDSN, ok := os.LookupEnv("POSTGRES_DSN"); ok {
@@ -48,6 +44,15 @@ func main() {
 		sugar.With(err).Fatalln("cannot open database")
 		return
 	}
+	// store the role of project members in the user_projects join table
+	if err = db.SetupJoinTable(new(model.Project), "Users", new(model.ProjectMember)); err != nil {
+		sugar.With(err).Fatalln("cannot setup project members join table")
+		return
+	}
+	if err = db.SetupJoinTable(new(model.User), "UserProjects", new(model.ProjectMember)); err != nil {
+		sugar.With(err).Fatalln("cannot setup user projects join table")
+		return
+	}
 	if err = db.AutoMigrate(
 		new(model.User),
 		new(model.Comment),
@@ -59,57 +64,204 @@ func main() {
 		new(model.Tag),
 		new(model.Notification),
 		new(model.ProjectFile),
+		new(model.ProjectFileUpload),
+		new(model.ProjectFileTusUpload),
+		new(model.ProjectFileVersion),
+		new(model.StoredObject),
+		new(model.ProjectFolder),
+		new(model.RetentionPolicy),
+		new(model.MeetingSeries),
+		new(model.MeetingTemplate),
+		new(model.TemplateTopic),
+		new(model.CalendarFeed),
+		new(model.ProjectMember),
+		new(model.ProjectInvite),
+		new(model.AccessToken),
+		new(model.AuditEvent),
 	); err != nil {
 		sugar.With(err).Fatalln("cannot migrate user")
 		return
 	}
 
+	storageService, err := services.NewStorageService()
+	if err != nil {
+		sugar.With(err).Fatalln("cannot create storage service")
+		return
+	}
+	reconcileService := services.NewReconcileService(db, storageService)
+	quotaConfig, err := services.NewQuotaConfig()
+	if err != nil {
+		sugar.With(err).Fatalln("cannot read quota configuration")
+		return
+	}
+	quotaService := services.NewQuotaService(db, quotaConfig)
+
+	// admin commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			if err = runReconcileCommand(os.Args[2:], reconcileService); err != nil {
+				sugar.With(err).Fatalln("cannot reconcile storage")
+			}
+			return
+		case "quota":
+			if err = runQuotaCommand(os.Args[2:], quotaService); err != nil {
+				sugar.With(err).Fatalln("cannot change quota")
+			}
+			return
+		default:
+			sugar.Fatalf("unknown command: %s", os.Args[1])
+			return
+		}
+	}
+
+	// authentication provider
+	authenticator, err := newAuthenticator()
+	if err != nil {
+		sugar.With(err).Fatalln("cannot create authenticator")
+		return
+	}
+	scheduleReconcile(reconcileService, sugar)
+
 	// api
 	app := fiber.New(fiber.Config{
 		AppName:           "perplex-api",
 		StreamRequestBody: true,
 	})
-	app.Use(cors.New())
+	app.Use(cors.New(cors.Config{
+		// allow browser clients to read the headers of the tus protocol
+		ExposeHeaders: "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, Upload-Metadata",
+	}))
 	app.Get("/", func(ctx *fiber.Ctx) error {
 		return ctx.SendString("Welcome to the perplex api! https://github.com/darmiel/perplex")
 	})
 
-	app.Use(gofiberfirebaseauth.New(fbApp, gofiberfirebaseauth.Config{
-		TokenExtractor: gofiberfirebaseauth.NewHeaderExtractor("Bearer "),
-	}))
-
-	s3Service, err := services.NewS3Service()
+	objectService := services.NewObjectService(db, storageService)
+	scanService, err := services.NewScanService(storageService, sugar)
 	if err != nil {
-		sugar.With(err).Fatalln("cannot create s3 service")
+		sugar.With(err).Fatalln("cannot create scan service")
 		return
 	}
+	uploadService := services.NewUploadService(db, storageService, objectService, scanService, quotaService)
+
+	// signed urls of the local storage driver are served without authentication
+	if localStorage, ok := storageService.(services.LocalStorage); ok {
+		storageHandler := handlers.NewStorageHandler(localStorage, uploadService, sugar)
+		routes.StorageRoutes(app.Group("/storage"), storageHandler)
+	}
+	// STORAGE_PROXY_DOWNLOADS streams downloads through the api, e.g. if the storage is not reachable by clients.
+	// like the local storage driver, the download urls are signed and served without authentication
+	var downloadSigner services.DownloadSigner
+	if _, ok := os.LookupEnv("STORAGE_PROXY_DOWNLOADS"); ok {
+		if downloadSigner, err = services.NewDownloadSigner(); err != nil {
+			sugar.With(err).Fatalln("cannot create download signer")
+		}
+		downloadHandler := handlers.NewDownloadHandler(storageService, downloadSigner)
+		routes.DownloadRoutes(app.Group("/downloads"), downloadHandler)
+	}
+
+	validate, err := util.NewValidate()
+	if err != nil {
+		sugar.With(err).Fatalln("cannot create validator")
+	}
 
 	projectService := services.NewProjectService(db)
 	meetingService := services.NewMeetingService(db)
+	seriesService := services.NewSeriesService(db)
+
+	// calendar feeds are authenticated by the secret token in the url, so calendar clients can subscribe to them.
+	// meetings and actions are linked to FRONTEND_URL (if set)
+	calendarService := services.NewCalendarService(db, projectService, seriesService, os.Getenv("FRONTEND_URL"))
+	calendarHandler := handlers.NewCalendarHandler(calendarService, projectService, sugar, validate)
+	routes.CalendarFeedRoutes(app.Group("/calendar"), calendarHandler)
+
+	// personal access tokens are accepted alongside the tokens of the authentication provider
+	accessTokenService := services.NewAccessTokenService(db)
+	app.Use(auth.New(auth.WithAccessTokens(accessTokenService, authenticator)))
+	// access tokens restricted to specific projects cannot use routes outside of these projects
+	app.Use(handlers.RestrictedTokenMiddleware)
+
 	topicService := services.NewTopicService(db, projectService)
 	commentService := services.NewCommentService(db, topicService)
-	userService := services.NewUserService(db, projectService, meetingService)
+	userService := services.NewUserService(db, projectService, meetingService, seriesService)
 	actionService := services.NewActionService(db, projectService)
+	inviteService := services.NewInviteService(db)
+	auditService := services.NewAuditService(db)
+	fileProcessingService := services.NewFileProcessingService(db, storageService, sugar)
+	fileVersionService := services.NewFileVersionService(db)
+	folderService := services.NewFolderService(db)
+	retentionService := services.NewRetentionService(
+		db,
+		storageService,
+		objectService,
+		fileVersionService,
+		projectService,
+		userService,
+		sugar,
+	)
+	scheduleRetention(retentionService, sugar)
+	tusService, err := services.NewTusService(db, storageService, objectService, scanService, quotaService)
+	if err != nil {
+		sugar.With(err).Fatalln("cannot create tus service")
+		return
+	}
+
+	// expired upload reservations free their quota and the partially uploaded objects are deleted
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			deleted, err := uploadService.CleanupExpiredUploads()
+			if err != nil {
+				sugar.Warnf("cannot cleanup expired uploads: %v", err)
+			}
+			if deleted > 0 {
+				sugar.Infof("deleted %d expired upload reservations", deleted)
+			}
+			deleted, err = tusService.CleanupExpiredUploads()
+			if err != nil {
+				sugar.Warnf("cannot cleanup expired tus uploads: %v", err)
+			}
+			if deleted > 0 {
+				sugar.Infof("deleted %d expired tus uploads", deleted)
+			}
+		}
+	}()
+
+	inviteHandler := handlers.NewInviteHandler(inviteService, userService, sugar, validate)
 
 	// user middleware
 	// check if user is already registered in database
 	// if not, create user with username from email address
 	// and set username/email in ctx.Locals("user_id") for logging
 	app.Use(func(ctx *fiber.Ctx) error {
-		u := ctx.Locals("user").(gofiberfirebaseauth.User)
+		u := ctx.Locals("user").(auth.Principal)
 		// check if user is already registered
-		if name, err := userService.GetName(u.UserID); err != nil {
-			// extract username from email
-			username := u.Email[:strings.Index(u.Email, "@")]
+		if user, err := userService.FindUser(u.UserID); err != nil {
+			username := defaultUserName(u)
 			sugar.Infof("user %s is not registered yet, creating user with name %s", u.UserID, username)
 			// create user
 			if err = userService.ChangeName(u.UserID, username); err != nil {
 				ctx.Locals("friendly_user", u.Email)
 				sugar.Warnf("cannot create user %s: %v", u.UserID, err)
+				return ctx.Next()
 			}
 			ctx.Locals("friendly_user", username)
+			if u.EmailVerified {
+				if err = userService.SetEmail(u.UserID, strings.ToLower(u.Email)); err != nil {
+					sugar.Warnf("cannot set email of user %s: %v", u.UserID, err)
+				}
+			}
+			// convert pending email invites into project memberships
+			inviteHandler.AcceptPendingInvites(u, username)
 		} else {
-			ctx.Locals("friendly_user", name)
+			ctx.Locals("friendly_user", user.UserName)
+			// keep the verified email address up to date
+			if u.EmailVerified && user.Email != strings.ToLower(u.Email) {
+				if err = userService.SetEmail(u.UserID, strings.ToLower(u.Email)); err != nil {
+					sugar.Warnf("cannot update email of user %s: %v", u.UserID, err)
+				}
+			}
 		}
 		return ctx.Next()
 	})
@@ -136,24 +288,74 @@ func main() {
 		return err
 	})
 
-	validate, err := util.NewValidate()
-	if err != nil {
-		sugar.With(err).Fatalln("cannot create validator")
-	}
-
 	// middlewares
 	middlewareHandler := handlers.NewMiddlewareHandler(userService, projectService, meetingService)
 
 	// /project
-	projectHandler := handlers.NewProjectHandler(projectService, userService, s3Service, sugar, validate)
+	projectHandler := handlers.NewProjectHandler(
+		projectService,
+		userService,
+		storageService,
+		objectService,
+		uploadService,
+		fileProcessingService,
+		fileVersionService,
+		scanService,
+		quotaService,
+		downloadSigner,
+		sugar,
+		validate,
+	)
+	tusHandler := handlers.NewTusHandler(tusService, projectService, fileProcessingService, sugar)
 	projectGroup := app.Group("/project")
-	routes.ProjectRoutes(projectGroup, projectHandler, middlewareHandler)
+	routes.ProjectRoutes(projectGroup, projectHandler, tusHandler, middlewareHandler)
+
+	// /project/:project_id/folders
+	folderHandler := handlers.NewFolderHandler(folderService, sugar, validate)
+	folderGroup := projectGroup.Group("/:project_id/folders")
+	routes.FolderRoutes(folderGroup, folderHandler, middlewareHandler)
+
+	// /project/:project_id/retention
+	retentionHandler := handlers.NewRetentionHandler(retentionService, sugar, validate)
+	retentionGroup := projectGroup.Group("/:project_id/retention")
+	routes.RetentionRoutes(retentionGroup, retentionHandler)
+
+	// /project/:project_id/invite
+	inviteGroup := projectGroup.Group("/:project_id/invite")
+	routes.InviteRoutes(inviteGroup, inviteHandler)
+
+	// /invite
+	routes.InviteAcceptRoutes(app.Group("/invite"), inviteHandler)
 
 	// /meetings
-	meetingHandler := handlers.NewMeetingHandler(meetingService, projectService, userService, sugar, validate)
+	meetingHandler := handlers.NewMeetingHandler(
+		meetingService,
+		projectService,
+		userService,
+		seriesService,
+		sugar,
+		validate,
+	)
 	meetingGroup := projectGroup.Group("/:project_id/meeting")
 	routes.MeetingRoutes(meetingGroup, meetingHandler, middlewareHandler)
 
+	// /project/:project_id/import
+	importService := services.NewImportService(db, projectService, meetingService, seriesService)
+	importHandler := handlers.NewImportHandler(importService, sugar)
+	importGroup := projectGroup.Group("/:project_id/import")
+	routes.ImportRoutes(importGroup, importHandler)
+
+	// /project/:project_id/series
+	seriesHandler := handlers.NewSeriesHandler(seriesService, sugar, validate)
+	seriesGroup := projectGroup.Group("/:project_id/series")
+	routes.SeriesRoutes(seriesGroup, seriesHandler)
+
+	// /project/:project_id/templates
+	templateService := services.NewTemplateService(db, projectService)
+	templateHandler := handlers.NewTemplateHandler(templateService, sugar, validate)
+	templateGroup := projectGroup.Group("/:project_id/templates")
+	routes.TemplateRoutes(templateGroup, templateHandler)
+
 	// /topics
 	topicHandler := handlers.NewTopicHandler(topicService, meetingService, projectService, userService, sugar, validate)
 	topicGroup := meetingGroup.Group("/:meeting_id/topic")
@@ -171,13 +373,29 @@ func main() {
 		validate,
 	)
 	commentGroup := projectGroup.Group("/:project_id/comment")
-	routes.CommentRoutes(commentGroup, commentHandler)
+	routes.CommentRoutes(commentGroup, commentHandler, middlewareHandler)
 
 	// /user
-	userHandler := handlers.NewUserHandler(userService, projectService, meetingService, topicService, actionService, sugar, validate)
+	userHandler := handlers.NewUserHandler(
+		userService,
+		projectService,
+		meetingService,
+		topicService,
+		actionService,
+		quotaService,
+		sugar,
+		validate,
+	)
 	userGroup := app.Group("/user")
 	routes.UserRoutes(userGroup, userHandler)
 
+	// /user/me/tokens
+	accessTokenHandler := handlers.NewAccessTokenHandler(accessTokenService, projectService, sugar, validate)
+	routes.AccessTokenRoutes(userGroup.Group("/me/tokens"), accessTokenHandler)
+
+	// /user/me/calendars
+	routes.CalendarRoutes(userGroup.Group("/me/calendars"), calendarHandler, accessTokenHandler)
+
 	// /action
 	actionHandler := handlers.NewActionHandler(actionService, topicService, meetingService, userService, sugar, validate)
 	actionGroup := projectGroup.Group("/:project_id/action")
@@ -193,6 +411,11 @@ func main() {
 	priorityGroup := projectGroup.Group("/:project_id/priority")
 	routes.PriorityRoutes(priorityGroup, priorityHandler)
 
+	// /audit
+	auditHandler := handlers.NewAuditHandler(auditService, sugar)
+	auditGroup := projectGroup.Group("/:project_id/audit")
+	routes.AuditRoutes(auditGroup, auditHandler)
+
 	// start web server
 	go func() {
 		if err := app.Listen(":8080"); err != nil {
@@ -205,3 +428,47 @@ func main() {
 	sugar.Infoln("shutting down web-server")
 	_ = app.Shutdown()
 }
+
+// newAuthenticator creates the authentication provider specified by AUTH_PROVIDER.
+// Supported providers are "firebase" (default) and "jwt" (generic OIDC, e.g. Keycloak or Authentik)
+func newAuthenticator() (auth.Authenticator, error) {
+	provider, ok := os.LookupEnv("AUTH_PROVIDER")
+	if !ok {
+		provider = "firebase"
+	}
+	switch provider {
+	case "firebase":
+		credentialsFile, ok := os.LookupEnv("FIREBASE_CREDENTIALS")
+		if !ok {
+			credentialsFile = "config/firebase.json"
+		}
+		fbApp, err := firebase.NewApp(context.TODO(), nil, option.WithCredentialsFile(credentialsFile))
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewFirebaseAuthenticator(fbApp)
+	case "jwt":
+		return auth.NewJWTAuthenticator(auth.JWTConfig{
+			Secret:      []byte(os.Getenv("AUTH_JWT_SECRET")),
+			JWKSFile:    os.Getenv("AUTH_JWT_JWKS_FILE"),
+			Issuer:      os.Getenv("AUTH_JWT_ISSUER"),
+			Audience:    os.Getenv("AUTH_JWT_AUDIENCE"),
+			UserIDClaim: os.Getenv("AUTH_JWT_USER_ID_CLAIM"),
+			EmailClaim:  os.Getenv("AUTH_JWT_EMAIL_CLAIM"),
+			NameClaim:   os.Getenv("AUTH_JWT_NAME_CLAIM"),
+		})
+	}
+	return nil, fmt.Errorf("unknown auth provider: %s", provider)
+}
+
+// defaultUserName returns the name of a newly registered user.
+// It prefers the username from the token and falls back to the local part of the email address
+func defaultUserName(u auth.Principal) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	if at := strings.Index(u.Email, "@"); at > 0 {
+		return u.Email[:at]
+	}
+	return u.UserID
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+var (
+	ErrNoToken       = errors.New("no token provided")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrReadOnlyToken = errors.New("token is read-only")
+)
+
+// AccessTokenPrefix is the prefix of all personal access tokens
+const AccessTokenPrefix = "pplx_"
+
+// Principal represents the authenticated user of a request
+type Principal struct {
+	// UserID is the unique ID of the user issued by the authentication provider
+	UserID string
+	// Email is the email address of the user (may be empty)
+	Email string
+	// EmailVerified is true if the provider verified the email address
+	EmailVerified bool
+	// Name is the preferred username of the user (may be empty)
+	Name string
+	// AccessTokenID is the ID of the personal access token used for the request (0 for ID tokens)
+	AccessTokenID uint
+	// ReadOnly is true if the principal may only perform non-mutating requests
+	ReadOnly bool
+	// ProjectIDs restricts the principal to the given projects (nil = no restriction)
+	ProjectIDs []uint
+}
+
+// CanAccessProject returns true if the principal is not restricted from accessing the project
+func (p Principal) CanAccessProject(projectID uint) bool {
+	if p.ProjectIDs == nil {
+		return true
+	}
+	for _, id := range p.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifiedEmail returns the email address of the principal if it was verified by the provider,
+// otherwise an empty string
+func (p Principal) VerifiedEmail() string {
+	if !p.EmailVerified {
+		return ""
+	}
+	return p.Email
+}
+
+// IsAccessToken returns true if the principal was authenticated using a personal access token
+func (p Principal) IsAccessToken() bool {
+	return p.AccessTokenID != 0
+}
+
+// Authenticator verifies a bearer token and returns the principal it belongs to
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// New creates a middleware which extracts the bearer token from the Authorization header,
+// verifies it using the given Authenticator and stores the Principal in the "user" local
+func New(authenticator Authenticator) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		header := ctx.Get(fiber.HeaderAuthorization)
+		if header == "" {
+			return ctx.Status(fiber.StatusUnauthorized).SendString(ErrNoToken.Error())
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return ctx.Status(fiber.StatusUnauthorized).SendString(ErrInvalidToken.Error())
+		}
+		// the reason is not returned since it could reveal details of the token verification
+		principal, err := authenticator.Authenticate(ctx.Context(), token)
+		if err != nil {
+			return ctx.Status(fiber.StatusUnauthorized).SendString(ErrInvalidToken.Error())
+		}
+		if principal.ReadOnly {
+			switch ctx.Method() {
+			case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			default:
+				return ctx.Status(fiber.StatusForbidden).SendString(ErrReadOnlyToken.Error())
+			}
+		}
+		ctx.Locals("user", *principal)
+		return ctx.Next()
+	}
+}
+
+type accessTokenAuthenticator struct {
+	accessTokens Authenticator
+	fallback     Authenticator
+}
+
+// WithAccessTokens creates an Authenticator which verifies personal access tokens (prefixed with AccessTokenPrefix)
+// using accessTokens and all other tokens using fallback
+func WithAccessTokens(accessTokens, fallback Authenticator) Authenticator {
+	return &accessTokenAuthenticator{accessTokens, fallback}
+}
+
+func (a *accessTokenAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if strings.HasPrefix(token, AccessTokenPrefix) {
+		return a.accessTokens.Authenticate(ctx, token)
+	}
+	return a.fallback.Authenticate(ctx, token)
+}
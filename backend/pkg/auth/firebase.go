@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	firebase "firebase.google.com/go"
+	firebaseauth "firebase.google.com/go/auth"
+)
+
+type firebaseAuthenticator struct {
+	client *firebaseauth.Client
+}
+
+// NewFirebaseAuthenticator creates an Authenticator which verifies Firebase ID tokens
+func NewFirebaseAuthenticator(app *firebase.App) (Authenticator, error) {
+	client, err := app.Auth(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &firebaseAuthenticator{client}, nil
+}
+
+func (f *firebaseAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	verified, err := f.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	principal := &Principal{
+		UserID: verified.UID,
+	}
+	if email, ok := verified.Claims["email"].(string); ok {
+		principal.Email = email
+	}
+	if emailVerified, ok := verified.Claims["email_verified"].(bool); ok {
+		principal.EmailVerified = emailVerified
+	}
+	return principal, nil
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+)
+
+var (
+	ErrNoVerificationKey = errors.New("neither jwks file nor secret specified")
+	ErrUnknownKeyID      = errors.New("unknown key id")
+	ErrMissingClaim      = errors.New("missing claim")
+)
+
+// JWTConfig configures the generic OIDC / JWT Authenticator
+type JWTConfig struct {
+	// Secret is the shared secret for HMAC signed tokens (optional)
+	Secret []byte
+	// JWKSFile is the path to a local JSON Web Key Set for RSA / ECDSA signed tokens (optional)
+	JWKSFile string
+	// Issuer is the expected "iss" claim (optional)
+	Issuer string
+	// Audience is the expected "aud" claim (optional)
+	Audience string
+	// UserIDClaim is the claim containing the user ID. Default: "sub"
+	UserIDClaim string
+	// EmailClaim is the claim containing the email address. Default: "email"
+	EmailClaim string
+	// NameClaim is the claim containing the preferred username. Default: "preferred_username"
+	NameClaim string
+}
+
+type jwtAuthenticator struct {
+	config JWTConfig
+	keys   map[string]any
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator creates an Authenticator which verifies JWTs issued by an OIDC provider
+// (e.g. Keycloak or Authentik) against a local JWKS file or a shared secret
+func NewJWTAuthenticator(config JWTConfig) (Authenticator, error) {
+	if config.UserIDClaim == "" {
+		config.UserIDClaim = "sub"
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "preferred_username"
+	}
+
+	a := &jwtAuthenticator{config: config}
+
+	var methods []string
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+	if len(config.Secret) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoVerificationKey
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	a.parser = jwt.NewParser(options...)
+	return a, nil
+}
+
+func (a *jwtAuthenticator) keyFunc(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.config.Secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	// tokens without key id are accepted if the key set only contains a single key
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKeyID
+}
+
+func (a *jwtAuthenticator) Authenticate(_ context.Context, token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.keyFunc); err != nil {
+		return nil, err
+	}
+	userID, ok := claims[a.config.UserIDClaim].(string)
+	if !ok || userID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrMissingClaim, a.config.UserIDClaim)
+	}
+	principal := &Principal{
+		UserID: userID,
+	}
+	if email, ok := claims[a.config.EmailClaim].(string); ok {
+		principal.Email = email
+	}
+	if emailVerified, ok := claims["email_verified"].(bool); ok {
+		principal.EmailVerified = emailVerified
+	}
+	if name, ok := claims[a.config.NameClaim].(string); ok {
+		principal.Name = name
+	}
+	return principal, nil
+}
+
+// JWKS
+
+type jsonWebKey struct {
+	KeyID   string `json:"kid"`
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func loadJWKS(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		// skip encryption keys
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse key %s: %w", k.KeyID, err)
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+}
@@ -0,0 +1,364 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCalendar = errors.New("invalid calendar")
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+// windowsZones maps the time zone names used by Outlook and Exchange to IANA time zones
+var windowsZones = map[string]string{
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"Romance Standard Time":          "Europe/Paris",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"GTB Standard Time":              "Europe/Bucharest",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// property is a parsed content line
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// component is a parsed component with its properties and sub-components
+type component struct {
+	name       string
+	properties []property
+	children   []*component
+}
+
+func (c *component) get(name string) (property, bool) {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return property{}, false
+}
+
+func (c *component) all(name string) (res []property) {
+	for _, p := range c.properties {
+		if p.name == name {
+			res = append(res, p)
+		}
+	}
+	return
+}
+
+// unfold joins folded content lines
+func unfold(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+	return strings.Split(data, "\n")
+}
+
+// parseProperty parses a content line like `DTSTART;TZID="Europe/Berlin":20240101T100000`
+func parseProperty(line string) (property, error) {
+	var (
+		quoted bool
+		parts  []string
+		start  int
+	)
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			parts = append(parts, line[start:i])
+			start = i + 1
+		case r == ':' && !quoted:
+			parts = append(parts, line[start:i])
+			p := property{
+				name:   strings.ToUpper(parts[0]),
+				params: make(map[string]string, len(parts)-1),
+				value:  line[i+1:],
+			}
+			for _, param := range parts[1:] {
+				key, value, _ := strings.Cut(param, "=")
+				p.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+			}
+			return p, nil
+		}
+	}
+	return property{}, fmt.Errorf("%w: invalid line %q", ErrInvalidCalendar, line)
+}
+
+func parseComponents(data string) (*component, error) {
+	root := &component{}
+	stack := []*component{root}
+	for _, line := range unfold(data) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+		current := stack[len(stack)-1]
+		switch p.name {
+		case "BEGIN":
+			child := &component{name: strings.ToUpper(p.value)}
+			current.children = append(current.children, child)
+			stack = append(stack, child)
+		case "END":
+			if len(stack) == 1 || current.name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("%w: unexpected END:%s", ErrInvalidCalendar, p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			current.properties = append(current.properties, p)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("%w: missing END:%s", ErrInvalidCalendar, stack[len(stack)-1].name)
+	}
+	return root, nil
+}
+
+// UnescapeText unescapes a TEXT value
+func UnescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// ParseDuration parses a DURATION value like "PT1H30M" or "P1D"
+func ParseDuration(s string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(s)
+	if match == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidDuration, s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidDuration, s)
+		}
+		d += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// decoder resolves the time zones of a calendar
+type decoder struct {
+	// zones contains the time zones defined by VTIMEZONE components which are not IANA time zones
+	zones map[string]*time.Location
+}
+
+// location returns the location of a TZID and its IANA name (empty if the time zone is unknown)
+func (d *decoder) location(tzid string) (*time.Location, string) {
+	tzid = strings.TrimPrefix(tzid, "/")
+	if loc, err := time.LoadLocation(tzid); err == nil && tzid != "" && tzid != "Local" {
+		return loc, tzid
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc, name
+		}
+	}
+	if loc, ok := d.zones[tzid]; ok {
+		return loc, ""
+	}
+	return time.UTC, ""
+}
+
+// parseTimeValue parses a DATE or DATE-TIME value. Floating times are interpreted as UTC
+func (d *decoder) parseTimeValue(value string, params map[string]string) (time.Time, bool, string, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		return t, true, "", err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, "", err
+	}
+	loc, name := d.location(params["TZID"])
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, name, err
+}
+
+func (d *decoder) parseTime(p property) (time.Time, bool, string, error) {
+	t, allDay, name, err := d.parseTimeValue(p.value, p.params)
+	if err != nil {
+		return time.Time{}, false, "", fmt.Errorf("%w: %s: %v", ErrInvalidCalendar, p.name, err)
+	}
+	return t, allDay, name, nil
+}
+
+// parseZones creates fixed time zones for VTIMEZONE components with unknown TZIDs
+// using the standard offset (TZOFFSETTO)
+func (d *decoder) parseZones(cal *component) {
+	for _, c := range cal.children {
+		if c.name != "VTIMEZONE" {
+			continue
+		}
+		tzid, ok := c.get("TZID")
+		if !ok {
+			continue
+		}
+		for _, sub := range c.children {
+			offset, ok := sub.get("TZOFFSETTO")
+			if sub.name != "STANDARD" || !ok || len(offset.value) < 5 {
+				continue
+			}
+			hours, errH := strconv.Atoi(offset.value[1:3])
+			minutes, errM := strconv.Atoi(offset.value[3:5])
+			if errH != nil || errM != nil {
+				continue
+			}
+			seconds := hours*3600 + minutes*60
+			if offset.value[0] == '-' {
+				seconds = -seconds
+			}
+			d.zones[tzid.value] = time.FixedZone(tzid.value, seconds)
+		}
+	}
+}
+
+func (d *decoder) parseEvent(c *component) (Event, error) {
+	var e Event
+	if p, ok := c.get("UID"); ok {
+		e.UID = p.value
+	}
+	if p, ok := c.get("SUMMARY"); ok {
+		e.Summary = UnescapeText(p.value)
+	}
+	if p, ok := c.get("DESCRIPTION"); ok {
+		e.Description = UnescapeText(p.value)
+	}
+	if p, ok := c.get("URL"); ok {
+		e.URL = p.value
+	}
+	if p, ok := c.get("STATUS"); ok {
+		e.Cancelled = strings.EqualFold(p.value, "CANCELLED")
+	}
+	if p, ok := c.get("RRULE"); ok {
+		e.RRule = p.value
+	}
+	p, ok := c.get("DTSTART")
+	if !ok {
+		return e, fmt.Errorf("%w: event %q without DTSTART", ErrInvalidCalendar, e.UID)
+	}
+	var err error
+	if e.Start, e.AllDay, e.TimeZone, err = d.parseTime(p); err != nil {
+		return e, err
+	}
+	if p, ok = c.get("DTEND"); ok {
+		if e.End, _, _, err = d.parseTime(p); err != nil {
+			return e, err
+		}
+	} else if p, ok = c.get("DURATION"); ok {
+		duration, err := ParseDuration(p.value)
+		if err != nil {
+			return e, err
+		}
+		e.End = e.Start.Add(duration)
+	} else if e.AllDay {
+		e.End = e.Start.AddDate(0, 0, 1)
+	} else {
+		e.End = e.Start
+	}
+	if p, ok = c.get("RECURRENCE-ID"); ok {
+		if e.RecurrenceID, _, _, err = d.parseTime(p); err != nil {
+			return e, err
+		}
+	}
+	for _, p := range c.all("EXDATE") {
+		for _, value := range strings.Split(p.value, ",") {
+			t, _, _, err := d.parseTimeValue(value, p.params)
+			if err != nil {
+				return e, fmt.Errorf("%w: EXDATE: %v", ErrInvalidCalendar, err)
+			}
+			e.ExDates = append(e.ExDates, t)
+		}
+	}
+	for _, p := range c.all("ATTENDEE") {
+		a := Attendee{Name: p.params["CN"]}
+		if len(p.value) > 7 && strings.EqualFold(p.value[:7], "mailto:") {
+			a.Email = p.value[7:]
+		}
+		e.Attendees = append(e.Attendees, a)
+	}
+	return e, nil
+}
+
+// Decode reads the events of a calendar. To-dos and other components are ignored
+func Decode(r io.Reader) (*Calendar, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseComponents(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(root.children) == 0 || root.children[0].name != "VCALENDAR" {
+		return nil, fmt.Errorf("%w: missing VCALENDAR", ErrInvalidCalendar)
+	}
+	vcal := root.children[0]
+	d := &decoder{zones: make(map[string]*time.Location)}
+	d.parseZones(vcal)
+	cal := &Calendar{}
+	if p, ok := vcal.get("PRODID"); ok {
+		cal.ProdID = p.value
+	}
+	if p, ok := vcal.get("X-WR-CALNAME"); ok {
+		cal.Name = UnescapeText(p.value)
+	}
+	for _, c := range vcal.children {
+		if c.name != "VEVENT" {
+			continue
+		}
+		e, err := d.parseEvent(c)
+		if err != nil {
+			return nil, err
+		}
+		cal.Events = append(cal.Events, e)
+	}
+	return cal, nil
+}
@@ -0,0 +1,211 @@
+// Package ical reads and writes iCalendar (RFC 5545) data with events (VEVENT) and to-dos (VTODO)
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximum length of a content line in octets (excluding the line break)
+const maxLineLength = 75
+
+// Calendar is an iCalendar object (VCALENDAR)
+type Calendar struct {
+	// ProdID identifies the product which created the calendar
+	ProdID string
+	// Name is displayed by calendar clients (X-WR-CALNAME)
+	Name   string
+	Events []Event
+	Todos  []Todo
+}
+
+// Attendee is a participant of an event
+type Attendee struct {
+	Name  string
+	Email string
+}
+
+// Event is a calendar event (VEVENT)
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Start        time.Time
+	End          time.Time
+	URL          string
+	Attendees    []Attendee
+	Created      time.Time
+	LastModified time.Time
+
+	// the following fields are only read by Decode
+
+	// TimeZone is the IANA time zone of the start (empty for UTC and floating times)
+	TimeZone string
+	// AllDay is true if the event starts at a date instead of a date-time
+	AllDay bool
+	// RRule is the recurrence rule of the event (without "RRULE:")
+	RRule string
+	// ExDates contains the start times of excluded occurrences
+	ExDates []time.Time
+	// RecurrenceID is the original start of the occurrence the event overrides (zero if not an override)
+	RecurrenceID time.Time
+	// Cancelled is true if the status of the event is CANCELLED
+	Cancelled bool
+}
+
+// Todo is a to-do with a due date (VTODO)
+type Todo struct {
+	UID          string
+	Summary      string
+	Description  string
+	Due          time.Time
+	URL          string
+	Attendees    []Attendee
+	Created      time.Time
+	LastModified time.Time
+	// Completed is the time when the to-do was completed (zero if not completed)
+	Completed time.Time
+}
+
+// EscapeText escapes a TEXT value
+func EscapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// FormatTime formats the time as UTC DATE-TIME value
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escapeParam quotes a parameter value. Double quotes are not allowed in parameter values
+func escapeParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a content line and folds it after maxLineLength octets without splitting characters
+func (w *writer) line(name, value string) {
+	if w.err != nil {
+		return
+	}
+	line := name + ":" + value
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if _, w.err = w.w.WriteString(line[:cut] + "\r\n "); w.err != nil {
+			return
+		}
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = maxLineLength - 1
+	}
+	_, w.err = w.w.WriteString(line + "\r\n")
+}
+
+// text writes a TEXT property if the value is not empty
+func (w *writer) text(name, value string) {
+	if value != "" {
+		w.line(name, EscapeText(value))
+	}
+}
+
+// time writes a DATE-TIME property if the time is not zero
+func (w *writer) time(name string, t time.Time) {
+	if !t.IsZero() {
+		w.line(name, FormatTime(t))
+	}
+}
+
+// attendees writes the attendees which have an email address (ATTENDEE requires a calendar user address)
+func (w *writer) attendees(attendees []Attendee) {
+	for _, a := range attendees {
+		if a.Email == "" {
+			continue
+		}
+		name := "ATTENDEE"
+		if a.Name != "" {
+			name += ";CN=" + escapeParam(a.Name)
+		}
+		w.line(name, "mailto:"+a.Email)
+	}
+}
+
+// stamp returns the DTSTAMP of a component
+func stamp(lastModified, created time.Time) time.Time {
+	if !lastModified.IsZero() {
+		return lastModified
+	}
+	if !created.IsZero() {
+		return created
+	}
+	return time.Now()
+}
+
+// Encode writes the calendar to w
+func (c *Calendar) Encode(out io.Writer) error {
+	w := &writer{w: bufio.NewWriter(out)}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", c.ProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", c.Name)
+	for _, e := range c.Events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", e.UID)
+		w.time("DTSTAMP", stamp(e.LastModified, e.Created))
+		w.time("DTSTART", e.Start)
+		w.time("DTEND", e.End)
+		w.text("SUMMARY", e.Summary)
+		w.text("DESCRIPTION", e.Description)
+		if e.URL != "" {
+			w.line("URL", e.URL)
+		}
+		w.attendees(e.Attendees)
+		w.time("CREATED", e.Created)
+		w.time("LAST-MODIFIED", e.LastModified)
+		w.line("END", "VEVENT")
+	}
+	for _, t := range c.Todos {
+		w.line("BEGIN", "VTODO")
+		w.line("UID", t.UID)
+		w.time("DTSTAMP", stamp(t.LastModified, t.Created))
+		w.time("DUE", t.Due)
+		w.text("SUMMARY", t.Summary)
+		w.text("DESCRIPTION", t.Description)
+		if t.URL != "" {
+			w.line("URL", t.URL)
+		}
+		w.attendees(t.Attendees)
+		if t.Completed.IsZero() {
+			w.line("STATUS", "NEEDS-ACTION")
+		} else {
+			w.line("STATUS", "COMPLETED")
+			w.time("COMPLETED", t.Completed)
+		}
+		w.time("CREATED", t.Created)
+		w.time("LAST-MODIFIED", t.LastModified)
+		w.line("END", "VTODO")
+	}
+	w.line("END", "VCALENDAR")
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,333 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event Event
+		// want is the decoded event, the encoded event if nil
+		want *Event
+	}{
+		{
+			name: "minimal",
+			event: Event{
+				UID:   "meeting-1@perplex",
+				Start: start,
+				End:   start.Add(time.Hour),
+			},
+		},
+		{
+			name: "escaped text",
+			event: Event{
+				UID:         "meeting-2@perplex",
+				Summary:     `Planning; budget, roadmap \ misc`,
+				Description: "first line\nsecond line, with comma\n\nthird; line",
+				Start:       start,
+				End:         start.Add(30 * time.Minute),
+				URL:         "https://perplex.example/project/1/meeting/2",
+			},
+		},
+		{
+			// long lines are folded without splitting multi-byte characters
+			name: "folded lines",
+			event: Event{
+				UID:         "meeting-3@perplex",
+				Summary:     strings.Repeat("Besprechung über Größenänderungen ", 5),
+				Description: strings.Repeat("日本語のテキスト", 20),
+				Start:       start,
+				End:         start.Add(time.Hour),
+			},
+		},
+		{
+			name: "attendees",
+			event: Event{
+				UID:   "meeting-4@perplex",
+				Start: start,
+				End:   start.Add(time.Hour),
+				Attendees: []Attendee{
+					{Name: "Jane Doe", Email: "jane@example.com"},
+					{Email: "john@example.com"},
+					{Name: `Max "the boss" Mustermann; CEO`, Email: "max@example.com"},
+					{Name: "No Email"},
+				},
+			},
+			want: &Event{
+				UID:   "meeting-4@perplex",
+				Start: start,
+				End:   start.Add(time.Hour),
+				// attendees without email are not encoded, double quotes are not allowed in parameters
+				Attendees: []Attendee{
+					{Name: "Jane Doe", Email: "jane@example.com"},
+					{Email: "john@example.com"},
+					{Name: `Max 'the boss' Mustermann; CEO`, Email: "max@example.com"},
+				},
+			},
+		},
+		{
+			// times are encoded in UTC
+			name: "time zone",
+			event: Event{
+				UID:   "meeting-5@perplex",
+				Start: time.Date(2024, time.July, 1, 10, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+				End:   time.Date(2024, time.July, 1, 11, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+			},
+			want: &Event{
+				UID:   "meeting-5@perplex",
+				Start: time.Date(2024, time.July, 1, 8, 0, 0, 0, time.UTC),
+				End:   time.Date(2024, time.July, 1, 9, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := &Calendar{
+				ProdID: "-//perplex//test//EN",
+				Name:   "Project, with; special chars",
+				Events: []Event{tt.event},
+			}
+			var buf bytes.Buffer
+			if err := cal.Encode(&buf); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+				if len(line) > maxLineLength {
+					t.Errorf("line exceeds %d octets: %q", maxLineLength, line)
+				}
+			}
+			decoded, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ProdID != cal.ProdID || decoded.Name != cal.Name {
+				t.Errorf("unexpected calendar %q (%q)", decoded.Name, decoded.ProdID)
+			}
+			want := tt.event
+			if tt.want != nil {
+				want = *tt.want
+			}
+			if len(decoded.Events) != 1 {
+				t.Fatalf("expected 1 event, got %d", len(decoded.Events))
+			}
+			if got := decoded.Events[0]; !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected event\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeTodos(t *testing.T) {
+	due := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		todo Todo
+		want []string
+	}{
+		{
+			name: "open",
+			todo: Todo{UID: "action-1@perplex", Summary: "Write minutes", Due: due},
+			want: []string{"UID:action-1@perplex", "DUE:20240304T120000Z", "SUMMARY:Write minutes", "STATUS:NEEDS-ACTION"},
+		},
+		{
+			name: "completed",
+			todo: Todo{UID: "action-2@perplex", Due: due, Completed: due.Add(-time.Hour)},
+			want: []string{"STATUS:COMPLETED", "COMPLETED:20240304T110000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (&Calendar{Todos: []Todo{tt.todo}}).Encode(&buf); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(buf.String(), "\r\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("missing line %q in\n%s", want, buf.String())
+				}
+			}
+			// to-dos are ignored by Decode
+			cal, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cal.Events) != 0 {
+				t.Errorf("expected no events, got %d", len(cal.Events))
+			}
+		})
+	}
+}
+
+// calendar wraps the lines in a VCALENDAR
+func calendar(lines ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+func TestDecode(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	start := time.Date(2024, time.March, 4, 10, 0, 0, 0, berlin)
+	tests := []struct {
+		name  string
+		input string
+		want  Event
+	}{
+		{
+			name: "IANA time zone and duration",
+			input: calendar("BEGIN:VEVENT", "UID:1", "DTSTART;TZID=Europe/Berlin:20240304T100000",
+				"DURATION:PT1H30M", "END:VEVENT"),
+			want: Event{UID: "1", Start: start, End: start.Add(90 * time.Minute), TimeZone: "Europe/Berlin"},
+		},
+		{
+			name: "Windows time zone",
+			input: calendar("BEGIN:VEVENT", "UID:2", `DTSTART;TZID="W. Europe Standard Time":20240304T100000`,
+				`DTEND;TZID="W. Europe Standard Time":20240304T110000`, "END:VEVENT"),
+			want: Event{UID: "2", Start: start, End: start.Add(time.Hour), TimeZone: "Europe/Berlin"},
+		},
+		{
+			name: "custom time zone",
+			input: calendar("BEGIN:VTIMEZONE", "TZID:Custom Zone", "BEGIN:STANDARD", "DTSTART:19701025T030000",
+				"TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "END:STANDARD", "END:VTIMEZONE",
+				"BEGIN:VEVENT", "UID:3", "DTSTART;TZID=Custom Zone:20240304T100000", "END:VEVENT"),
+			want: Event{
+				UID:   "3",
+				Start: time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "all-day event",
+			input: calendar("BEGIN:VEVENT", "UID:4", "DTSTART;VALUE=DATE:20240304", "END:VEVENT"),
+			want: Event{
+				UID:    "4",
+				Start:  time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+				AllDay: true,
+			},
+		},
+		{
+			name: "recurring event with excluded dates",
+			input: calendar("BEGIN:VEVENT", "UID:5", "DTSTART:20240304T090000Z", "DTEND:20240304T100000Z",
+				"RRULE:FREQ=WEEKLY;BYDAY=MO", "EXDATE:20240311T090000Z,20240318T090000Z", "END:VEVENT"),
+			want: Event{
+				UID:   "5",
+				Start: time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2024, time.March, 4, 10, 0, 0, 0, time.UTC),
+				RRule: "FREQ=WEEKLY;BYDAY=MO",
+				ExDates: []time.Time{
+					time.Date(2024, time.March, 11, 9, 0, 0, 0, time.UTC),
+					time.Date(2024, time.March, 18, 9, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "cancelled override of an occurrence",
+			input: calendar("BEGIN:VEVENT", "UID:5", "RECURRENCE-ID:20240325T090000Z",
+				"DTSTART:20240325T090000Z", "STATUS:CANCELLED", "END:VEVENT"),
+			want: Event{
+				UID:          "5",
+				Start:        time.Date(2024, time.March, 25, 9, 0, 0, 0, time.UTC),
+				End:          time.Date(2024, time.March, 25, 9, 0, 0, 0, time.UTC),
+				RecurrenceID: time.Date(2024, time.March, 25, 9, 0, 0, 0, time.UTC),
+				Cancelled:    true,
+			},
+		},
+		{
+			name: "folded and escaped text",
+			input: calendar("BEGIN:VEVENT", "UID:6", "DTSTART:20240304T090000Z",
+				`SUMMARY:Weekly\, sync`, `DESCRIPTION:first\nsec`, ` ond\; line`, "END:VEVENT"),
+			want: Event{
+				UID:         "6",
+				Summary:     "Weekly, sync",
+				Description: "first\nsecond; line",
+				Start:       time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+				End:         time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal, err := Decode(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cal.Events) != 1 {
+				t.Fatalf("expected 1 event, got %d", len(cal.Events))
+			}
+			got := cal.Events[0]
+			// times are compared as instants since the locations differ
+			if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+				t.Errorf("expected %s - %s, got %s - %s", tt.want.Start, tt.want.End, got.Start, got.End)
+			}
+			got.Start, got.End = tt.want.Start, tt.want.End
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected event\n got: %+v\nwant: %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "no calendar", input: "BEGIN:VEVENT\r\nEND:VEVENT\r\n"},
+		{name: "missing end", input: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{name: "invalid line", input: calendar("BEGIN:VEVENT", "UID", "END:VEVENT")},
+		{name: "missing start", input: calendar("BEGIN:VEVENT", "UID:1", "END:VEVENT")},
+		{name: "invalid start", input: calendar("BEGIN:VEVENT", "UID:1", "DTSTART:tomorrow", "END:VEVENT")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(strings.NewReader(tt.input)); !errors.Is(err, ErrInvalidCalendar) {
+				t.Fatalf("expected %v, got %v", ErrInvalidCalendar, err)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "PT1H30M", want: 90 * time.Minute},
+		{value: "P1D", want: 24 * time.Hour},
+		{value: "P1W", want: 7 * 24 * time.Hour},
+		{value: "P1DT2H3M4S", want: 26*time.Hour + 3*time.Minute + 4*time.Second},
+		{value: "-PT15M", want: -15 * time.Minute},
+		{value: "P", err: true},
+		{value: "PT", err: true},
+		{value: "1H", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidDuration) {
+					t.Fatalf("expected %v, got %v", ErrInvalidDuration, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("expected %s, got %s (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
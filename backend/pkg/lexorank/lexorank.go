@@ -28,7 +28,7 @@ const magic = 456976
 // Example: 0 -> "a", 1 -> "b", 25 -> "z", 26 -> "aa", 27 -> "ab",
 // 51 -> "az", 52 -> "ba", 53 -> "bb", 77 -> "bz", 78 -> "ca"
 func GetAlphabetForIndex(index int64) Rank {
-	if index > magic {
+	if index >= magic {
 		return Rank(fmt.Sprintf("zzzz%s%c",
 			strings.Repeat("z", int(index-magic)/26),
 			rune('a'+(index-magic)%26),
@@ -0,0 +1,75 @@
+package lexorank
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetAlphabetForIndex(t *testing.T) {
+	tests := []struct {
+		index int64
+		want  Rank
+	}{
+		{index: 0, want: "aaaa"},
+		{index: 1, want: "aaab"},
+		{index: 25, want: "aaaz"},
+		{index: 26, want: "aaba"},
+		{index: 52, want: "aaca"},
+		{index: 676, want: "abaa"},
+		{index: magic - 1, want: "zzzz"},
+		{index: magic + 1, want: "zzzzb"},
+		{index: magic + 27, want: "zzzzzb"},
+	}
+	for _, tt := range tests {
+		if got := GetAlphabetForIndex(tt.index); got != tt.want {
+			t.Errorf("GetAlphabetForIndex(%d): expected %q, got %q", tt.index, tt.want, got)
+		}
+	}
+}
+
+func TestGetAlphabetForIndexOrder(t *testing.T) {
+	// topics are appended in the order of their index
+	prev := GetAlphabetForIndex(0)
+	for index := int64(1); index < magic+100; index++ {
+		rank := GetAlphabetForIndex(index)
+		if rank <= prev {
+			t.Fatalf("rank %q of index %d does not sort after %q", rank, index, prev)
+		}
+		prev = rank
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		first, second Rank
+		err           error
+	}{
+		{first: "aaaa", second: "aaab"},
+		{first: "aaaa", second: "aaac"},
+		{first: "aaaa", second: "zzzz"},
+		{first: "aaaz", second: "aaba"},
+		{first: "abcd", second: "abce"},
+		{first: "a", second: "b"},
+		{first: "a", second: "aab"},
+		{first: "aaaam", second: "aaab"},
+		{first: "aaab", second: "aaab", err: ErrInvalidOrder},
+		{first: "aaac", second: "aaab", err: ErrInvalidOrder},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.first)+"-"+string(tt.second), func(t *testing.T) {
+			got, err := tt.first.Between(tt.second)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got <= tt.first || got >= tt.second {
+				t.Fatalf("rank %q is not between %q and %q", got, tt.first, tt.second)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"database/sql"
+	"gorm.io/gorm"
+)
+
+// AccessToken is a personal access token which can be used instead of an ID token (e.g. for scripts)
+type AccessToken struct {
+	gorm.Model
+	// Name describes the purpose of the token
+	Name string `json:"name"`
+	// UserID is the ID of the user the token belongs to
+	UserID string `json:"user_id"`
+	// TokenHash is the SHA-256 hash of the token. The token itself is never stored
+	TokenHash string `gorm:"uniqueIndex" json:"-"`
+	// Prefix contains the first characters of the token to recognize it
+	Prefix string `json:"prefix"`
+	// ExpiresAt is the time when the token expires (if valid)
+	ExpiresAt sql.NullTime `json:"expires_at"`
+	// LastUsedAt is the time when the token was last used (if valid)
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	// ReadOnly tokens can only be used for non-mutating requests
+	ReadOnly bool `json:"read_only"`
+	// Projects restricts the token to the given projects (empty = all projects of the user)
+	Projects []Project `gorm:"many2many:access_token_projects" json:"projects"`
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// AuditEntityType is the type of entity an audit event refers to
+type AuditEntityType string
+
+const (
+	AuditEntityProject         AuditEntityType = "project"
+	AuditEntityMember          AuditEntityType = "member"
+	AuditEntityInvite          AuditEntityType = "invite"
+	AuditEntityMeeting         AuditEntityType = "meeting"
+	AuditEntityTopic           AuditEntityType = "topic"
+	AuditEntityAction          AuditEntityType = "action"
+	AuditEntityComment         AuditEntityType = "comment"
+	AuditEntityTag             AuditEntityType = "tag"
+	AuditEntityPriority        AuditEntityType = "priority"
+	AuditEntityFile            AuditEntityType = "file"
+	AuditEntityFolder          AuditEntityType = "folder"
+	AuditEntityRetentionPolicy AuditEntityType = "retention_policy"
+	AuditEntityMeetingSeries   AuditEntityType = "meeting_series"
+	AuditEntityMeetingTemplate AuditEntityType = "meeting_template"
+)
+
+// AuditAction is the kind of mutation an audit event records
+type AuditAction string
+
+const (
+	AuditActionCreate AuditAction = "create"
+	AuditActionUpdate AuditAction = "update"
+	AuditActionDelete AuditAction = "delete"
+	AuditActionLink   AuditAction = "link"
+	AuditActionUnlink AuditAction = "unlink"
+	AuditActionClose  AuditAction = "close"
+	AuditActionOpen   AuditAction = "open"
+)
+
+// AuditChange contains the value of a single field before and after a mutation
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditChanges maps the (JSON) field names of an entity to their changes.
+// It is stored as JSON in the database
+type AuditChanges map[string]AuditChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (c *AuditChanges) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	}
+	return errors.New("unsupported type for audit changes")
+}
+
+// AuditEvent records a single mutation in a project
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	// ProjectID is the ID of the project the mutated entity belongs to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// ActorID is the ID of the user who performed the mutation.
+	// It is empty for mutations performed by the instance, e.g. by retention policies or meeting series
+	ActorID string `gorm:"index" json:"actor_id"`
+	// EntityType is the type of the mutated entity
+	EntityType AuditEntityType `gorm:"index" json:"entity_type"`
+	// EntityID is the ID of the mutated entity
+	EntityID uint `json:"entity_id"`
+	// UserID is the ID of the user affected by the mutation (e.g. an added project member), if any
+	UserID string `gorm:"index" json:"user_id,omitempty"`
+	// Action is the kind of mutation
+	Action AuditAction `json:"action"`
+	// Changes contains all changed fields of the entity
+	Changes AuditChanges `gorm:"type:text" json:"changes"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"database/sql"
+	"gorm.io/gorm"
+)
+
+// CalendarFeed is a secret iCalendar feed URL which calendar clients can subscribe to without authentication
+type CalendarFeed struct {
+	gorm.Model
+	// Name describes the purpose of the feed
+	Name string `json:"name"`
+	// UserID is the ID of the user the feed belongs to
+	UserID string `gorm:"index" json:"user_id"`
+	// ProjectID restricts the feed to a project (nil = all projects of the user)
+	ProjectID *uint `gorm:"index" json:"project_id"`
+	// IncludeActions adds actions with a due date to the feed
+	IncludeActions bool `json:"include_actions"`
+	// TokenHash is the SHA-256 hash of the token in the feed URL. The token itself is never stored
+	TokenHash string `gorm:"uniqueIndex" json:"-"`
+	// Prefix contains the first characters of the token to recognize it
+	Prefix string `json:"prefix"`
+	// LastUsedAt is the time when the feed was last requested (if valid)
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+}
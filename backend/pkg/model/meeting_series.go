@@ -0,0 +1,70 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// TimeList is a list of times which is stored as JSON in the database
+type TimeList []time.Time
+
+func (l TimeList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (l *TimeList) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	}
+	return errors.New("unsupported type for time list")
+}
+
+// MeetingSeries is a recurring meeting. Its occurrences are created as meetings
+// when they are requested (see MaterializedUntil)
+type MeetingSeries struct {
+	gorm.Model
+	// Name of the meetings of the series
+	Name string `json:"name"`
+	// Description of the meetings of the series
+	Description string `json:"description"`
+	// StartDate is the start of the first occurrence
+	StartDate time.Time `json:"start_date"`
+	// EndDate is the end of the first occurrence, the duration applies to all occurrences
+	EndDate time.Time `json:"end_date"`
+	// TimeZone is the IANA time zone in which the wall clock time of the occurrences is kept
+	TimeZone string `json:"time_zone"`
+	// RRule is the RFC 5545 recurrence rule of the series (e.g. "FREQ=WEEKLY;BYDAY=MO")
+	RRule string `json:"rrule"`
+	// ExDates contains the start times of excluded occurrences
+	ExDates TimeList `gorm:"type:text" json:"exdates"`
+	// ProjectID is the project the series belongs to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// CreatorID is the ID of the creator of the series
+	CreatorID string `json:"creator_id"`
+	// MaterializedUntil is the time until which all occurrences were created as meetings
+	MaterializedUntil time.Time `json:"materialized_until"`
+	// AssignedUsers are assigned to all meetings of the series when they are created
+	AssignedUsers []User `gorm:"many2many:series_user_assignments" json:"assigned_users"`
+	// ICalUID is the UID of the imported iCalendar event (empty if not imported)
+	ICalUID string `gorm:"column:ical_uid;index" json:"ical_uid,omitempty"`
+}
+
+func (s MeetingSeries) CheckProjectOwnership(projectID uint) bool {
+	return s.ProjectID == projectID
+}
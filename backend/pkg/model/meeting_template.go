@@ -0,0 +1,42 @@
+package model
+
+import "gorm.io/gorm"
+
+// MeetingTemplate is a project-level blueprint for meetings with recurring agendas (e.g. retrospectives)
+type MeetingTemplate struct {
+	gorm.Model
+	// Name of the template, which is also the default name of created meetings
+	Name string `json:"name"`
+	// Description is the default description of created meetings
+	Description string `json:"description"`
+	// Duration is the default duration of created meetings in minutes
+	Duration int `json:"duration"`
+	// ProjectID is the project the template belongs to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// CreatorID is the ID of the creator of the template
+	CreatorID string `json:"creator_id"`
+	// Tags are added to created meetings
+	Tags []Tag `gorm:"many2many:meeting_template_tags" json:"tags"`
+	// AssignedUsers are assigned to created meetings
+	AssignedUsers []User `gorm:"many2many:meeting_template_users" json:"assigned_users"`
+	// Topics are created in created meetings (ordered by position)
+	Topics []TemplateTopic `gorm:"foreignKey:TemplateID;constraint:OnDelete:CASCADE" json:"topics"`
+}
+
+func (t MeetingTemplate) CheckProjectOwnership(projectID uint) bool {
+	return t.ProjectID == projectID
+}
+
+// TemplateTopic is the skeleton of a topic created by a meeting template
+type TemplateTopic struct {
+	ID uint `gorm:"primarykey" json:"id"`
+	// TemplateID is the ID of the template the topic belongs to
+	TemplateID uint `gorm:"index" json:"template_id"`
+	// Position is the position of the topic in the agenda
+	Position      int    `json:"position"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	ForceSolution bool   `json:"force_solution"`
+	// PriorityID is the ID of the priority of the topic (optional)
+	PriorityID *uint `json:"priority_id"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// ProjectFileTusUpload is a resumable upload using the tus protocol.
+// The received bytes are stored on the local disk until the upload is finished
+// and count towards the file quota of the project
+type ProjectFileTusUpload struct {
+	gorm.Model
+	// Name of the file (from the upload metadata)
+	Name string `json:"name"`
+	// ContentType of the file (from the upload metadata)
+	ContentType string `json:"content_type"`
+	// Length is the announced size of the file in bytes
+	Length int64 `json:"length"`
+	// Offset is the number of bytes received so far
+	Offset int64 `gorm:"column:upload_offset" json:"offset"`
+	// Metadata is the raw Upload-Metadata header of the creation request
+	Metadata string `json:"-"`
+	// ProjectID is the ID of the project the file is uploaded to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// FileID is the ID of the file the upload is a new version of (0 for new files)
+	FileID uint `json:"file_id,omitempty"`
+	// CreatorID is the ID of the user who created the upload
+	CreatorID string `json:"creator_id"`
+	// ExpiresAt is the time when the incomplete upload is deleted.
+	// It is extended with every received chunk
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+// IsExpired returns true if the upload cannot be continued anymore
+func (u ProjectFileTusUpload) IsExpired() bool {
+	return u.ExpiresAt.Before(time.Now())
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// ProjectFileUpload is a reservation for a file which is uploaded directly to the storage.
+// The reserved size counts towards the file quota of the project until the upload is completed or expires
+type ProjectFileUpload struct {
+	gorm.Model
+	// Name of the file
+	Name string `json:"name"`
+	// ObjectKey is the key the file must be uploaded to
+	ObjectKey string `gorm:"index" json:"-"`
+	// Size is the announced size of the file in bytes
+	Size int64 `json:"size"`
+	// ContentType is the announced content type of the file
+	ContentType string `json:"content_type"`
+	// ProjectID is the ID of the project the file is uploaded to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// FileID is the ID of the file the upload is a new version of (0 for new files)
+	FileID uint `json:"file_id,omitempty"`
+	// CreatorID is the ID of the user who requested the upload
+	CreatorID string `json:"creator_id"`
+	// ExpiresAt is the time when the reservation expires
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+// IsExpired returns true if the reservation cannot be completed anymore
+func (u ProjectFileUpload) IsExpired() bool {
+	return u.ExpiresAt.Before(time.Now())
+}
@@ -0,0 +1,33 @@
+package model
+
+import "gorm.io/gorm"
+
+// ProjectFileVersion is a previous version of a project file.
+// The current version of a file is always stored in the ProjectFile itself
+type ProjectFileVersion struct {
+	gorm.Model
+	// FileID is the ID of the file the version belongs to
+	FileID uint `gorm:"index" json:"file_id"`
+	// ProjectID is the ID of the project the file belongs to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// Version is the version number (starting at 1)
+	Version int `json:"version"`
+	// ObjectKey is the key of the version in the bucket
+	ObjectKey string `json:"object_key"`
+	// Size is the size of the version in bytes
+	Size int64 `json:"size"`
+	// Hash is the hex encoded SHA-256 hash of the content of the version
+	Hash string `json:"hash,omitempty"`
+	// ContentType is the detected content type of the version
+	ContentType string `json:"content_type"`
+	// ThumbnailKey is the key of the generated thumbnail in the bucket (only for images)
+	ThumbnailKey string `json:"thumbnail_key,omitempty"`
+	// ScanStatus is the result of the malware scan of the version
+	ScanStatus ScanStatus `gorm:"default:unscanned" json:"scan_status"`
+	// ScanSignature is the name of the malware found in the version
+	ScanSignature string `json:"scan_signature,omitempty"`
+	// CreatorID is the ID of the user who uploaded the version
+	CreatorID string `json:"creator_id"`
+	// Creator is the user who uploaded the version
+	Creator User `json:"creator,omitempty"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "gorm.io/gorm"
+
+// ProjectFolder is a folder for project files. Folders can be nested.
+// The names of folders are unique within their parent folder
+type ProjectFolder struct {
+	gorm.Model
+	// Name of the folder
+	Name string `gorm:"uniqueIndex:idx_folder_name,priority:3" json:"name"`
+	// ProjectID is the ID of the project the folder belongs to
+	ProjectID uint `gorm:"index;uniqueIndex:idx_folder_name,priority:1,where:deleted_at IS NULL" json:"project_id"`
+	// ParentID is the ID of the parent folder (nil for folders in the root folder)
+	ParentID *uint `gorm:"index;uniqueIndex:idx_folder_name,priority:2" json:"parent_id"`
+	// CreatorID is the ID of the user who created the folder
+	CreatorID string `json:"creator_id"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"database/sql"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ProjectInvite is an invitation link which adds the user accepting it to a project
+type ProjectInvite struct {
+	gorm.Model
+	// Token is the secret part of the invitation link
+	Token string `gorm:"uniqueIndex" json:"token"`
+	// ProjectID is the ID of the project the invite belongs to
+	ProjectID uint `json:"project_id"`
+	// Project is the project the invite belongs to
+	Project Project `json:"project,omitempty"`
+	// CreatorID is the ID of the user who created the invite
+	CreatorID string `json:"creator_id"`
+	// Role is the role the user gets when accepting the invite
+	Role ProjectRole `json:"role"`
+	// Email restricts the invite to the user with this email address (optional).
+	// Email invites are accepted automatically when a matching user registers
+	Email string `gorm:"index" json:"email"`
+	// MaxUses is the maximum number of times the invite can be accepted (0 = unlimited)
+	MaxUses int `json:"max_uses"`
+	// Uses is the number of times the invite was accepted
+	Uses int `json:"uses"`
+	// ExpiresAt is the time when the invite expires (if valid)
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+// IsUsable returns true if the invite is not expired and has uses left
+func (i ProjectInvite) IsUsable() bool {
+	if i.ExpiresAt.Valid && i.ExpiresAt.Time.Before(time.Now()) {
+		return false
+	}
+	return i.MaxUses <= 0 || i.Uses < i.MaxUses
+}
+
+func (i ProjectInvite) CheckProjectOwnership(projectID uint) bool {
+	return i.ProjectID == projectID
+}
@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// ProjectRole is the role of a user in a project
+type ProjectRole string
+
+const (
+	// RoleOwner is the creator of the project. The owner is not stored as a ProjectMember
+	RoleOwner ProjectRole = "owner"
+	// RoleAdmin can manage the project (users, tags, priorities, files) but cannot delete it
+	RoleAdmin ProjectRole = "admin"
+	// RoleMember can create and edit meetings, topics, actions and comments
+	RoleMember ProjectRole = "member"
+	// RoleViewer can only read the project
+	RoleViewer ProjectRole = "viewer"
+)
+
+// IsValid returns true if the role can be assigned to a project member
+func (r ProjectRole) IsValid() bool {
+	return r == RoleAdmin || r == RoleMember || r == RoleViewer
+}
+
+// ProjectMember is the join table between projects and users (user_projects)
+// and contains the role of the user in the project
+type ProjectMember struct {
+	// ProjectID is the ID of the project
+	ProjectID uint `gorm:"primaryKey" json:"project_id"`
+	// UserID is the ID of the user
+	UserID string `gorm:"primaryKey" json:"user_id"`
+	// Role is the role of the user in the project
+	Role ProjectRole `gorm:"default:member" json:"role"`
+	// CreatedAt is the time when the user joined the project
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ProjectMember) TableName() string {
+	return "user_projects"
+}
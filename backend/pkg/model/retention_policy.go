@@ -0,0 +1,36 @@
+package model
+
+import "gorm.io/gorm"
+
+// RetentionAction is what happens to files matched by a retention policy
+type RetentionAction string
+
+const (
+	// RetentionActionDelete deletes the file including all previous versions
+	RetentionActionDelete RetentionAction = "delete"
+	// RetentionActionArchive moves the content of the file to the archive storage class
+	RetentionActionArchive RetentionAction = "archive"
+)
+
+// RetentionPolicy cleans up files of a project automatically.
+// A file matches the policy if all enabled conditions apply to it
+type RetentionPolicy struct {
+	gorm.Model
+	// Name of the policy, shown in notifications
+	Name string `json:"name"`
+	// ProjectID is the ID of the project the policy belongs to
+	ProjectID uint `gorm:"index" json:"project_id"`
+	// Action is applied to matching files
+	Action RetentionAction `json:"action"`
+	// MaxIdleDays matches files which were not accessed for this many days (0 disables the condition)
+	MaxIdleDays int `json:"max_idle_days"`
+	// MeetingAgeDays matches files which are only attached to meetings which started
+	// more than this many days ago (0 disables the condition)
+	MeetingAgeDays int `json:"meeting_age_days"`
+	// NoticeDays is the number of days the uploader is notified before the action is applied
+	NoticeDays int `json:"notice_days"`
+	// Enabled is false if the policy is not evaluated
+	Enabled bool `json:"enabled"`
+	// CreatorID is the ID of the user who created the policy
+	CreatorID string `json:"creator_id"`
+}
@@ -0,0 +1,14 @@
+package model
+
+// ScanStatus is the result of the malware scan of an uploaded file
+type ScanStatus string
+
+const (
+	// ScanStatusUnscanned is the status of files which were not scanned,
+	// e.g. because no scanner is configured or the scanner was not reachable
+	ScanStatusUnscanned ScanStatus = "unscanned"
+	// ScanStatusClean is the status of files in which no malware was found
+	ScanStatusClean ScanStatus = "clean"
+	// ScanStatusInfected is the status of files in which malware was found
+	ScanStatusInfected ScanStatus = "infected"
+)
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// StoredObject is an object in the storage which is shared by all project files
+// and file versions with identical content
+type StoredObject struct {
+	// ObjectKey is the key of the object in the bucket
+	ObjectKey string `gorm:"primaryKey" json:"object_key"`
+	// Hash is the hex encoded SHA-256 hash of the content
+	Hash string `gorm:"uniqueIndex" json:"hash"`
+	// Size is the size of the object in bytes
+	Size int64 `json:"size"`
+	// RefCount is the number of project files and file versions which reference the object.
+	// The object is deleted when the last reference is released
+	RefCount  int       `json:"ref_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -13,6 +13,8 @@ type User struct {
 	ID string `gorm:"primarykey" json:"id"`
 	// UserName is the username which is displayed in the frontend
 	UserName string `gorm:"unique" json:"name"`
+	// Email is the (verified) email address of the user and is not exposed in the API
+	Email string `gorm:"index" json:"-"`
 	// CreatedAt is the time when the entry was first created
 	CreatedAt time.Time `json:"created_at"`
 	// UpdatedAt is the time when the entry was modified
@@ -35,6 +37,9 @@ type User struct {
 	SubscribedTopics []Topic `gorm:"many2many:topic_user_subscriptions" json:"subscribed_topics"`
 	// ProjectFiles contains all files the user created
 	ProjectFiles []ProjectFile `gorm:"foreignKey:CreatorID" json:"project_files"`
+	// FileSizeQuota is the maximum size (in bytes) of all files the user uploaded across all projects.
+	// If nil, the default quota of the instance is used
+	FileSizeQuota *int64 `json:"-"`
 }
 
 // Comment represents a comment in a topic
@@ -56,6 +61,8 @@ type Comment struct {
 	ActionID *uint `json:"action_id"`
 	// FileID is the ID of the file the comment belongs to
 	ProjectFileID *uint `json:"file_id"`
+	// Files contains all files attached to the comment
+	Files []ProjectFile `gorm:"many2many:comment_file_attachments" json:"files"`
 }
 
 // CheckProjectOwnership checks if the comment belongs to the project
@@ -98,10 +105,16 @@ type Topic struct {
 	Priority Priority `json:"priority,omitempty"`
 	// Tags contains all tags of the topic
 	Tags []Tag `gorm:"many2many:topic_tag_assignments" json:"tags"`
+	// Files contains all files attached to the topic
+	Files []ProjectFile `gorm:"many2many:topic_file_attachments" json:"files"`
 	// LexoRank is the sorting rank of the topic
 	LexoRank lexorank.Rank `json:"lexo_rank"`
 	// SubscribedUsers contains all users subscribed to the topic
 	SubscribedUsers []User `gorm:"many2many:topic_user_subscriptions" json:"subscribed_users"`
+	// CarriedOverFromID is the ID of the meeting the topic was carried over from (if not nil)
+	CarriedOverFromID *uint `json:"carried_over_from_id"`
+	// CarriedOverToID is the ID of the copy of the topic in the follow-up meeting (if not nil)
+	CarriedOverToID *uint `json:"carried_over_to_id"`
 }
 
 func (t Topic) CheckProjectOwnership(projectID uint) bool {
@@ -134,8 +147,23 @@ type Meeting struct {
 	AssignedUsers []User `gorm:"many2many:meeting_user_assignments" json:"assigned_users"`
 	// Tags contains all tags of the meeting
 	Tags []Tag `gorm:"many2many:meeting_tag_assignments" json:"tags"`
+	// Files contains all files attached to the meeting
+	Files []ProjectFile `gorm:"many2many:meeting_file_attachments" json:"files"`
 	// IsReady indicates if the meeting is ready to start (user defined)
 	IsReady bool `json:"is_ready"`
+	// SeriesID is the ID of the series the meeting is an occurrence of (if not nil)
+	// Each occurrence of a series has at most one meeting
+	SeriesID *uint `gorm:"index;uniqueIndex:idx_meeting_occurrence,where:deleted_at IS NULL" json:"series_id"`
+	// OccurrenceDate is the start of the occurrence as defined by the recurrence rule of the series
+	OccurrenceDate *time.Time `gorm:"uniqueIndex:idx_meeting_occurrence" json:"occurrence_date"`
+	// IsException indicates that the occurrence was edited and is no longer updated with the series
+	IsException bool `json:"is_exception"`
+	// ICalUID is the UID of the imported iCalendar event (empty if not imported)
+	ICalUID string `gorm:"column:ical_uid;index" json:"ical_uid,omitempty"`
+	// ConcludedAt represents the time when the meeting was concluded (if valid)
+	ConcludedAt sql.NullTime `json:"concluded_at"`
+	// FollowUpID is the ID of the meeting the open topics were carried over to (if not nil)
+	FollowUpID *uint `json:"follow_up_id"`
 }
 
 func (m Meeting) CheckProjectOwnership(projectID uint) bool {
@@ -155,8 +183,12 @@ type Project struct {
 	OwnerID string `json:"owner_id"`
 	// Owner is the creator of the project
 	Owner User `json:"owner,omitempty"`
+	// PendingOwnerID is the ID of the member the ownership should be transferred to (if not nil)
+	PendingOwnerID *string `json:"pending_owner_id"`
 	// Users contains all users which have access to the project
 	Users []User `gorm:"many2many:user_projects;" json:"users,omitempty"`
+	// Members contains the roles of all users which have access to the project
+	Members []ProjectMember `json:"members,omitempty"`
 	// Meetings contains all meetings in the project
 	Meetings []Meeting `json:"meetings,omitempty"`
 	// Priorities contains all priorities in the project
@@ -219,6 +251,8 @@ type Action struct {
 	Priority Priority `json:"priority,omitempty"`
 	// Tags contains all tags of the action
 	Tags []Tag `gorm:"many2many:action_tag_assignments" json:"tags"`
+	// Files contains all files attached to the action
+	Files []ProjectFile `gorm:"many2many:action_file_attachments" json:"files"`
 	// ClosedAt represents the time when the action was resolved (if valid)
 	ClosedAt sql.NullTime `json:"closed_at"`
 	// CreatorID is the ID of the creator of the action
@@ -277,18 +311,42 @@ type ProjectFile struct {
 	ObjectKey string `json:"object_key"`
 	// Size is the size of the file in bytes
 	Size int64 `json:"size"`
+	// Hash is the hex encoded SHA-256 hash of the content.
+	// It is empty for files uploaded before hashes were recorded
+	Hash string `gorm:"index" json:"hash,omitempty"`
+	// ContentType is the content type of the file detected from its first bytes
+	ContentType string `json:"content_type"`
+	// ThumbnailKey is the key of the generated thumbnail in the bucket (only for images)
+	ThumbnailKey string `json:"thumbnail_key,omitempty"`
+	// ScanStatus is the result of the malware scan of the current version
+	ScanStatus ScanStatus `gorm:"default:unscanned" json:"scan_status"`
+	// ScanSignature is the name of the malware found in the current version
+	ScanSignature string `json:"scan_signature,omitempty"`
 	// ProjectID is the ID of the project the file belongs to
 	ProjectID uint `json:"project_id"`
 	// Project is the project the file belongs to
 	Project Project `json:"project,omitempty"`
+	// FolderID is the ID of the folder the file is in (nil for the root folder)
+	FolderID *uint `gorm:"index" json:"folder_id"`
 	// CreatorID is the ID of the creator of the file
 	CreatorID string `json:"creator_id"`
 	// Creator is the creator of the file
 	Creator User `json:"creator,omitempty"`
+	// Version is the number of the current version of the file
+	Version int `gorm:"default:1" json:"version"`
+	// VersionCreatorID is the ID of the user who uploaded the current version.
+	// It is empty if the file has only one version
+	VersionCreatorID string `json:"version_creator_id,omitempty"`
 	// Comments for the file
 	Comments []Comment `json:"comments,omitempty"`
 	// LastAccessedAt is the time when the file was last accessed
 	LastAccessedAt time.Time `json:"last_accessed_at"`
 	// AccessCount is the number of times the file was accessed
 	AccessCount int `json:"access_count"`
+	// ArchivedAt is the time when the content was moved to the archive storage class (if not nil)
+	ArchivedAt *time.Time `json:"archived_at"`
+	// CleanupAt is the time when the retention policy CleanupPolicyID is applied to the file (if not nil)
+	CleanupAt *time.Time `gorm:"index" json:"cleanup_at"`
+	// CleanupPolicyID is the ID of the retention policy the file is scheduled for
+	CleanupPolicyID *uint `json:"cleanup_policy_id"`
 }
@@ -0,0 +1,352 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules used for meeting series:
+// DAILY, WEEKLY and MONTHLY rules with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and WKST.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxPeriods limits the number of periods which are expanded, so rules without an end terminate
+const maxPeriods = 100_000
+
+var (
+	ErrInvalidRule        = errors.New("invalid recurrence rule")
+	ErrUnsupportedRule    = errors.New("unsupported recurrence rule")
+	ErrCountAndUntil      = errors.New("recurrence rule must not contain both COUNT and UNTIL")
+	ErrUnsupportedByDay   = errors.New("BYDAY is only supported for WEEKLY and MONTHLY rules")
+	ErrUnsupportedMonthly = errors.New("BYMONTHDAY is only supported for MONTHLY rules")
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Weekday is a day of the week. N selects the n-th occurrence of the day within a month
+// (negative values count from the end of the month, 0 selects every occurrence)
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+func (w Weekday) String() string {
+	if w.N == 0 {
+		return weekdayNames[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdayNames[w.Day]
+}
+
+// Rule is a parsed recurrence rule
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// Count is the number of occurrences (0 if unlimited)
+	Count int
+	// Until is the last possible start of an occurrence (zero if unlimited)
+	Until      time.Time
+	ByDay      []Weekday
+	ByMonthDay []int
+	WeekStart  time.Weekday
+}
+
+// Parse parses a recurrence rule like "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;UNTIL=20240101T000000Z".
+// The "RRULE:" prefix is optional
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	r := &Rule{
+		Interval:  1,
+		WeekStart: time.Monday,
+	}
+	var hasCount bool
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch f := Frequency(strings.ToUpper(value)); f {
+			case Daily, Weekly, Monthly:
+				r.Freq = f
+			default:
+				return nil, fmt.Errorf("%w: FREQ=%s", ErrUnsupportedRule, value)
+			}
+		case "INTERVAL":
+			if r.Interval, err = strconv.Atoi(value); err != nil || r.Interval < 1 {
+				return nil, fmt.Errorf("%w: INTERVAL=%s", ErrInvalidRule, value)
+			}
+		case "COUNT":
+			if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("%w: COUNT=%s", ErrInvalidRule, value)
+			}
+			hasCount = true
+		case "UNTIL":
+			if r.Until, err = ParseTime(value); err != nil {
+				return nil, fmt.Errorf("%w: UNTIL=%s", ErrInvalidRule, value)
+			}
+		case "BYDAY":
+			for _, raw := range strings.Split(strings.ToUpper(value), ",") {
+				day, err := parseWeekday(raw)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, raw := range strings.Split(value, ",") {
+				day, err := strconv.Atoi(raw)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return nil, fmt.Errorf("%w: BYMONTHDAY=%s", ErrInvalidRule, value)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, day)
+			}
+		case "WKST":
+			day, ok := weekdays[strings.ToUpper(value)]
+			if !ok {
+				return nil, fmt.Errorf("%w: WKST=%s", ErrInvalidRule, value)
+			}
+			r.WeekStart = day
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedRule, key)
+		}
+	}
+	if r.Freq == "" {
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if hasCount && !r.Until.IsZero() {
+		return nil, ErrCountAndUntil
+	}
+	if len(r.ByDay) > 0 && r.Freq == Daily {
+		return nil, ErrUnsupportedByDay
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq != Monthly {
+		return nil, ErrUnsupportedMonthly
+	}
+	for _, day := range r.ByDay {
+		if day.N != 0 && r.Freq != Monthly {
+			return nil, fmt.Errorf("%w: ordinal weekdays are only supported for MONTHLY rules", ErrInvalidRule)
+		}
+	}
+	return r, nil
+}
+
+func parseWeekday(raw string) (Weekday, error) {
+	if len(raw) < 2 {
+		return Weekday{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, raw)
+	}
+	day, ok := weekdays[raw[len(raw)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, raw)
+	}
+	res := Weekday{Day: day}
+	if prefix := raw[:len(raw)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Weekday{}, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, raw)
+		}
+		res.N = n
+	}
+	return res, nil
+}
+
+// ParseTime parses a DATE ("20060102") or UTC DATE-TIME ("20060102T150405Z") value.
+// Floating DATE-TIME values ("20060102T150405") are interpreted as UTC
+func ParseTime(value string) (time.Time, error) {
+	switch len(value) {
+	case 8:
+		return time.Parse("20060102", value)
+	case 15:
+		return time.Parse("20060102T150405", value)
+	}
+	return time.Parse("20060102T150405Z", value)
+}
+
+// FormatTime formats the time as UTC DATE-TIME value
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// String formats the rule, e.g. "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO"
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+FormatTime(r.Until))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = d.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns the start times of all occurrences of the rule starting at dtstart which start
+// in [from, to). Occurrences keep the wall clock time of dtstart in its location.
+// Excluded times (EXDATE) still count towards COUNT as specified by RFC 5545
+func (r *Rule) Between(dtstart, from, to time.Time, exdates []time.Time) []time.Time {
+	excluded := make(map[int64]bool, len(exdates))
+	for _, t := range exdates {
+		excluded[t.Unix()] = true
+	}
+	var (
+		res   []time.Time
+		count int
+	)
+	for period := 0; period < maxPeriods; period++ {
+		for _, t := range r.period(dtstart, period) {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return res
+			}
+			if !t.Before(to) {
+				return res
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return res
+			}
+			if !t.Before(from) && !excluded[t.Unix()] {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}
+
+// period returns the candidate occurrences of the n-th period (in chronological order)
+func (r *Rule) period(dtstart time.Time, n int) []time.Time {
+	hour, minute, second := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, dtstart.Location())
+	}
+	switch r.Freq {
+	case Daily:
+		return []time.Time{dtstart.AddDate(0, 0, n*r.Interval)}
+	case Weekly:
+		// the week containing dtstart starts at the first WKST on or before dtstart
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(dtstart.Year(), dtstart.Month(), dtstart.Day()-offset+7*n*r.Interval)
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []Weekday{{Day: dtstart.Weekday()}}
+		}
+		res := make([]time.Time, 0, len(days))
+		for _, d := range days {
+			dayOffset := (int(d.Day) - int(r.WeekStart) + 7) % 7
+			res = append(res, at(weekStart.Year(), weekStart.Month(), weekStart.Day()+dayOffset))
+		}
+		return sortUnique(res)
+	case Monthly:
+		first := at(dtstart.Year(), dtstart.Month()+time.Month(n*r.Interval), 1)
+		year, month := first.Year(), first.Month()
+		daysInMonth := at(year, month+1, 0).Day()
+		// months without the day of dtstart are skipped
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+			if dtstart.Day() > daysInMonth {
+				return nil
+			}
+			return []time.Time{at(year, month, dtstart.Day())}
+		}
+		byDay := make(map[int]bool)
+		for _, d := range r.ByDay {
+			for _, day := range weekdaysInMonth(first, daysInMonth, d) {
+				byDay[day] = true
+			}
+		}
+		// BYDAY and BYMONTHDAY are combined as intersection, e.g. "BYDAY=FR;BYMONTHDAY=13"
+		var res []time.Time
+		for day := 1; day <= daysInMonth; day++ {
+			if len(r.ByDay) > 0 && !byDay[day] {
+				continue
+			}
+			if len(r.ByMonthDay) > 0 && !matchesMonthDay(r.ByMonthDay, day, daysInMonth) {
+				continue
+			}
+			res = append(res, at(year, month, day))
+		}
+		return res
+	}
+	return nil
+}
+
+// matchesMonthDay returns true if the day of the month is one of the (possibly negative) month days
+func matchesMonthDay(monthDays []int, day, daysInMonth int) bool {
+	for _, d := range monthDays {
+		if d == day || d < 0 && daysInMonth+d+1 == day {
+			return true
+		}
+	}
+	return false
+}
+
+// weekdaysInMonth returns the days of the month matching the weekday
+func weekdaysInMonth(first time.Time, daysInMonth int, d Weekday) []int {
+	firstDay := 1 + (int(d.Day)-int(first.Weekday())+7)%7
+	var days []int
+	for day := firstDay; day <= daysInMonth; day += 7 {
+		days = append(days, day)
+	}
+	switch {
+	case d.N > 0 && d.N <= len(days):
+		return []int{days[d.N-1]}
+	case d.N < 0 && -d.N <= len(days):
+		return []int{days[len(days)+d.N]}
+	case d.N == 0:
+		return days
+	}
+	return nil
+}
+
+func sortUnique(times []time.Time) []time.Time {
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	res := times[:0]
+	for i, t := range times {
+		if i == 0 || !t.Equal(times[i-1]) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
@@ -0,0 +1,250 @@
+package rrule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newYork is the time zone of the examples of RFC 5545 (section 3.8.5.3)
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	return loc
+}
+
+func TestBetweenRFC5545Examples(t *testing.T) {
+	loc := newYork(t)
+	// date returns 09:00 on the date in New York, the time of all examples
+	date := func(value string) time.Time {
+		d, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(d.Year(), d.Month(), d.Day(), 9, 0, 0, 0, loc)
+	}
+	tests := []struct {
+		name    string
+		dtstart string
+		rule    string
+		exdates []string
+		// limit is the number of occurrences compared for rules without an end
+		limit int
+		want  []string
+	}{
+		{
+			name:    "daily for 10 occurrences",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=DAILY;COUNT=10",
+			want: []string{"1997-09-02", "1997-09-03", "1997-09-04", "1997-09-05", "1997-09-06",
+				"1997-09-07", "1997-09-08", "1997-09-09", "1997-09-10", "1997-09-11"},
+		},
+		{
+			name:    "every other day",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=DAILY;INTERVAL=2",
+			limit:   5,
+			want:    []string{"1997-09-02", "1997-09-04", "1997-09-06", "1997-09-08", "1997-09-10"},
+		},
+		{
+			name:    "every 10 days, 5 occurrences",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			want:    []string{"1997-09-02", "1997-09-12", "1997-09-22", "1997-10-02", "1997-10-12"},
+		},
+		{
+			// the occurrences keep their wall clock time after the end of daylight saving time
+			name:    "weekly for 10 occurrences",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=WEEKLY;COUNT=10",
+			want: []string{"1997-09-02", "1997-09-09", "1997-09-16", "1997-09-23", "1997-09-30",
+				"1997-10-07", "1997-10-14", "1997-10-21", "1997-10-28", "1997-11-04"},
+		},
+		{
+			name:    "weekly on Tuesday and Thursday for five weeks",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+			want: []string{"1997-09-02", "1997-09-04", "1997-09-09", "1997-09-11", "1997-09-16",
+				"1997-09-18", "1997-09-23", "1997-09-25", "1997-09-30", "1997-10-02"},
+		},
+		{
+			name:    "every other week on Tuesday and Thursday, for 8 occurrences",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=8;WKST=SU;BYDAY=TU,TH",
+			want: []string{"1997-09-02", "1997-09-04", "1997-09-16", "1997-09-18",
+				"1997-09-30", "1997-10-02", "1997-10-14", "1997-10-16"},
+		},
+		{
+			name:    "monthly on the first Friday for 10 occurrences",
+			dtstart: "1997-09-05",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			want: []string{"1997-09-05", "1997-10-03", "1997-11-07", "1997-12-05", "1998-01-02",
+				"1998-02-06", "1998-03-06", "1998-04-03", "1998-05-01", "1998-06-05"},
+		},
+		{
+			name:    "every other month on the first and last Sunday for 10 occurrences",
+			dtstart: "1997-09-07",
+			rule:    "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+			want: []string{"1997-09-07", "1997-09-28", "1997-11-02", "1997-11-30", "1998-01-04",
+				"1998-01-25", "1998-03-01", "1998-03-29", "1998-05-03", "1998-05-31"},
+		},
+		{
+			name:    "monthly on the second-to-last Monday for 6 months",
+			dtstart: "1997-09-22",
+			rule:    "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			want: []string{"1997-09-22", "1997-10-20", "1997-11-17", "1997-12-22", "1998-01-19",
+				"1998-02-16"},
+		},
+		{
+			name:    "monthly on the third-to-the-last day",
+			dtstart: "1997-09-28",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-3",
+			limit:   6,
+			want: []string{"1997-09-28", "1997-10-29", "1997-11-28", "1997-12-29", "1998-01-29",
+				"1998-02-26"},
+		},
+		{
+			name:    "monthly on the 2nd and 15th for 10 occurrences",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			want: []string{"1997-09-02", "1997-09-15", "1997-10-02", "1997-10-15", "1997-11-02",
+				"1997-11-15", "1997-12-02", "1997-12-15", "1998-01-02", "1998-01-15"},
+		},
+		{
+			name:    "monthly on the first and last day for 10 occurrences",
+			dtstart: "1997-09-30",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			want: []string{"1997-09-30", "1997-10-01", "1997-10-31", "1997-11-01", "1997-11-30",
+				"1997-12-01", "1997-12-31", "1998-01-01", "1998-01-31", "1998-02-01"},
+		},
+		{
+			name:    "every 18 months on the 10th thru 15th for 10 occurrences",
+			dtstart: "1997-09-10",
+			rule:    "FREQ=MONTHLY;INTERVAL=18;COUNT=10;BYMONTHDAY=10,11,12,13,14,15",
+			want: []string{"1997-09-10", "1997-09-11", "1997-09-12", "1997-09-13", "1997-09-14",
+				"1997-09-15", "1999-03-10", "1999-03-11", "1999-03-12", "1999-03-13"},
+		},
+		{
+			name:    "every Tuesday, every other month",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=MONTHLY;INTERVAL=2;BYDAY=TU",
+			limit:   10,
+			want: []string{"1997-09-02", "1997-09-09", "1997-09-16", "1997-09-23", "1997-09-30",
+				"1997-11-04", "1997-11-11", "1997-11-18", "1997-11-25", "1998-01-06"},
+		},
+		{
+			name:    "every Friday the 13th",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			exdates: []string{"1997-09-02"},
+			limit:   5,
+			want:    []string{"1998-02-13", "1998-03-13", "1998-11-13", "1999-08-13", "2000-10-13"},
+		},
+		{
+			// excluded occurrences count towards COUNT
+			name:    "daily with excluded dates",
+			dtstart: "1997-09-02",
+			rule:    "FREQ=DAILY;COUNT=5",
+			exdates: []string{"1997-09-03", "1997-09-05"},
+			want:    []string{"1997-09-02", "1997-09-04", "1997-09-06"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("cannot parse %q: %v", tt.rule, err)
+			}
+			dtstart := date(tt.dtstart)
+			var exdates []time.Time
+			for _, d := range tt.exdates {
+				exdates = append(exdates, date(d))
+			}
+			got := rule.Between(dtstart, dtstart, dtstart.AddDate(5, 0, 0), exdates)
+			if tt.limit > 0 && len(got) > tt.limit {
+				got = got[:tt.limit]
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d occurrences, got %d: %v", len(tt.want), len(got), got)
+			}
+			for i, want := range tt.want {
+				if !got[i].Equal(date(want)) {
+					t.Errorf("occurrence %d: expected %s, got %s", i, date(want), got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBetweenRange(t *testing.T) {
+	loc := newYork(t)
+	dtstart := time.Date(1997, time.September, 2, 9, 0, 0, 0, loc)
+	rule, err := Parse("FREQ=DAILY;UNTIL=19971224T000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := rule.Between(dtstart, dtstart, dtstart.AddDate(1, 0, 0), nil)
+	if len(all) != 113 {
+		t.Fatalf("expected 113 occurrences, got %d", len(all))
+	}
+	if last := all[len(all)-1]; !last.Equal(time.Date(1997, time.December, 23, 9, 0, 0, 0, loc)) {
+		t.Fatalf("unexpected last occurrence %s", last)
+	}
+	// from is inclusive, to is exclusive
+	from, to := all[10], all[20]
+	got := rule.Between(dtstart, from, to, nil)
+	if len(got) != 10 || !got[0].Equal(from) || !got[9].Equal(all[19]) {
+		t.Fatalf("unexpected occurrences in [%s, %s): %v", from, to, got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		// want is the formatted rule, the input if empty
+		want string
+		err  error
+	}{
+		{rule: "FREQ=DAILY"},
+		{rule: "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"},
+		{rule: "FREQ=WEEKLY;COUNT=10;WKST=SU"},
+		{rule: "FREQ=MONTHLY;UNTIL=19971224T000000Z;BYDAY=1SU,-1SU"},
+		{rule: "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13"},
+		{rule: "freq=monthly;bymonthday=1,-1", want: "FREQ=MONTHLY;BYMONTHDAY=1,-1"},
+		{rule: "FREQ=WEEKLY;INTERVAL=1", want: "FREQ=WEEKLY"},
+		{rule: "FREQ=YEARLY", err: ErrUnsupportedRule},
+		{rule: "FREQ=DAILY;BYHOUR=9", err: ErrUnsupportedRule},
+		{rule: "INTERVAL=2", err: ErrInvalidRule},
+		{rule: "FREQ=DAILY;INTERVAL=0", err: ErrInvalidRule},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=19971224T000000Z", err: ErrCountAndUntil},
+		{rule: "FREQ=DAILY;BYDAY=MO", err: ErrUnsupportedByDay},
+		{rule: "FREQ=WEEKLY;BYMONTHDAY=1", err: ErrUnsupportedMonthly},
+		{rule: "FREQ=WEEKLY;BYDAY=1MO", err: ErrInvalidRule},
+		{rule: "FREQ=MONTHLY;BYDAY=6MO", err: ErrInvalidRule},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=32", err: ErrInvalidRule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.rule
+			}
+			if got := rule.String(); got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
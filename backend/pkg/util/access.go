@@ -2,6 +2,119 @@ package util
 
 import "github.com/darmiel/perplex/pkg/model"
 
+// Permission is an action a user can perform in a project
+type Permission int
+
+const (
+	// PermissionRead allows reading meetings, topics, actions, comments and files
+	PermissionRead Permission = iota
+	// PermissionWrite allows creating and editing meetings, topics, actions and comments
+	PermissionWrite
+	// PermissionDeleteMeetings allows deleting meetings
+	PermissionDeleteMeetings
+	// PermissionUploadFiles allows uploading files
+	PermissionUploadFiles
+	// PermissionDeleteFiles allows deleting files of other users
+	PermissionDeleteFiles
+	// PermissionManageTags allows creating, editing and deleting tags
+	PermissionManageTags
+	// PermissionManagePriorities allows creating, editing and deleting priorities
+	PermissionManagePriorities
+	// PermissionManageUsers allows adding and removing users and changing their roles
+	PermissionManageUsers
+	// PermissionEditProject allows editing the name and description of the project
+	PermissionEditProject
+	// PermissionDeleteProject allows deleting the project
+	PermissionDeleteProject
+	// PermissionTransferOwnership allows transferring the ownership of the project to another member
+	PermissionTransferOwnership
+	// PermissionViewAuditLog allows viewing the audit log of the project
+	PermissionViewAuditLog
+	// PermissionManageQuota allows changing the maximum file size and file quota of the project
+	PermissionManageQuota
+)
+
+// rolePermissions is the permission matrix for all project roles
+var rolePermissions = map[model.ProjectRole][]Permission{
+	model.RoleOwner: {
+		PermissionRead,
+		PermissionWrite,
+		PermissionDeleteMeetings,
+		PermissionUploadFiles,
+		PermissionDeleteFiles,
+		PermissionManageTags,
+		PermissionManagePriorities,
+		PermissionManageUsers,
+		PermissionEditProject,
+		PermissionDeleteProject,
+		PermissionTransferOwnership,
+		PermissionViewAuditLog,
+		PermissionManageQuota,
+	},
+	model.RoleAdmin: {
+		PermissionRead,
+		PermissionWrite,
+		PermissionDeleteMeetings,
+		PermissionUploadFiles,
+		PermissionDeleteFiles,
+		PermissionManageTags,
+		PermissionManagePriorities,
+		PermissionManageUsers,
+		PermissionEditProject,
+		PermissionManageQuota,
+	},
+	model.RoleMember: {
+		PermissionRead,
+		PermissionWrite,
+		PermissionUploadFiles,
+	},
+	model.RoleViewer: {
+		PermissionRead,
+	},
+}
+
+// RoleOf returns the role of the user in the project.
+// The project must be loaded with the "Members" association
+func RoleOf(project *model.Project, userID string) (model.ProjectRole, bool) {
+	if project.OwnerID == userID {
+		return model.RoleOwner, true
+	}
+	for _, m := range project.Members {
+		if m.UserID == userID {
+			return m.Role, true
+		}
+	}
+	return "", false
+}
+
+// HasPermission checks if the role is allowed to perform the action
+func HasPermission(role model.ProjectRole, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Can checks if the user is allowed to perform the action in the project
+func Can(project *model.Project, userID string, permission Permission) bool {
+	role, ok := RoleOf(project, userID)
+	return ok && HasPermission(role, permission)
+}
+
+// CanManageRole checks if a user with the role actor may add, remove or change users with the role target.
+// Admins can only manage members and viewers, the owner can manage everyone
+func CanManageRole(actor, target model.ProjectRole) bool {
+	if !HasPermission(actor, PermissionManageUsers) {
+		return false
+	}
+	if actor == model.RoleOwner {
+		return target != model.RoleOwner
+	}
+	return target == model.RoleMember || target == model.RoleViewer
+}
+
 func HasAccess(project *model.Project, userID string) bool {
 	if project.OwnerID == userID {
 		return true
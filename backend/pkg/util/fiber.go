@@ -1,14 +1,14 @@
 package util
 
 import (
+	"github.com/darmiel/perplex/pkg/auth"
 	"github.com/gofiber/fiber/v2"
-	gofiberfirebaseauth "github.com/ralf-life/gofiber-firebaseauth"
 )
 
 func GetFriendlyName(ctx *fiber.Ctx) string {
 	if invitorName, ok := ctx.Locals("friendly_user").(string); ok {
 		return invitorName
 	}
-	u := ctx.Locals("user").(gofiberfirebaseauth.User)
+	u := ctx.Locals("user").(auth.Principal)
 	return u.UserID
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/darmiel/perplex/api/services"
+	"os"
+)
+
+var ErrNoUserSpecified = errors.New("no user or project specified")
+
+// runQuotaCommand changes the file quota of a user across all projects or the quota of a project
+// and prints the quota as JSON. Project quotas set by this command may exceed the limits of the instance.
+// Usage: perplex-backend quota -user <id> [-set <bytes> | -reset]
+// or: perplex-backend quota -project <id> [-set <bytes>] [-max-file-size <bytes>]
+func runQuotaCommand(args []string, srv services.QuotaService) error {
+	fs := flag.NewFlagSet("quota", flag.ExitOnError)
+	userID := fs.String("user", "", "ID of the user")
+	projectID := fs.Uint("project", 0, "ID of the project")
+	set := fs.Int64("set", 0, "quota of the user or project in bytes (-1 for unlimited)")
+	maxFileSize := fs.Int64("max-file-size", 0, "maximum file size of the project in bytes (-1 for unlimited)")
+	reset := fs.Bool("reset", false, "use the default quota of the instance for the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	changed := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		changed[f.Name] = true
+	})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if *projectID != 0 {
+		return runProjectQuotaCommand(enc, srv, uint(*projectID), changed, *set, *maxFileSize)
+	}
+	if *userID == "" {
+		return ErrNoUserSpecified
+	}
+	switch {
+	case *reset:
+		if err := srv.SetUserQuota(*userID, nil); err != nil {
+			return err
+		}
+	case changed["set"]:
+		if err := srv.SetUserQuota(*userID, set); err != nil {
+			return err
+		}
+	}
+	info, err := srv.UserQuotaInfo(*userID)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(info)
+}
+
+// runProjectQuotaCommand changes the given values of the project quota
+func runProjectQuotaCommand(
+	enc *json.Encoder,
+	srv services.QuotaService,
+	projectID uint,
+	changed map[string]bool,
+	quota, maxFileSize int64,
+) error {
+	currentMaxFileSize, currentQuota, err := srv.ProjectQuota(projectID)
+	if err != nil {
+		return err
+	}
+	if !changed["set"] {
+		quota = currentQuota
+	}
+	if !changed["max-file-size"] {
+		maxFileSize = currentMaxFileSize
+	}
+	if changed["set"] || changed["max-file-size"] {
+		if err = srv.OverrideProjectQuota(projectID, maxFileSize, quota); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(map[string]int64{
+		"max_file_size": maxFileSize,
+		"quota":         quota,
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/darmiel/perplex/api/services"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// defaultReconcileMinAge is the default minimum age of objects and rows which are reconciled
+const defaultReconcileMinAge = time.Hour
+
+// runReconcileCommand runs the storage reconciliation once and prints the report as JSON.
+// Usage: perplex-backend reconcile [-dry-run] [-min-age 1h]
+func runReconcileCommand(args []string, srv services.ReconcileService) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report inconsistencies without removing them")
+	minAge := fs.Duration("min-age", defaultReconcileMinAge, "minimum age of objects and rows which are reconciled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report, err := srv.Reconcile(services.ReconcileOptions{
+		DryRun: *dryRun,
+		MinAge: *minAge,
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// scheduleReconcile runs the storage reconciliation every RECONCILE_INTERVAL (e.g. "24h").
+// Nothing is scheduled if the interval is not set. If RECONCILE_DRY_RUN is set,
+// inconsistencies are only logged
+func scheduleReconcile(srv services.ReconcileService, sugar *zap.SugaredLogger) {
+	rawInterval, ok := os.LookupEnv("RECONCILE_INTERVAL")
+	if !ok {
+		return
+	}
+	interval, err := time.ParseDuration(rawInterval)
+	if err != nil || interval <= 0 {
+		sugar.Warnf("invalid RECONCILE_INTERVAL %q, storage reconciliation is disabled", rawInterval)
+		return
+	}
+	_, dryRun := os.LookupEnv("RECONCILE_DRY_RUN")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := srv.Reconcile(services.ReconcileOptions{
+				DryRun: dryRun,
+				MinAge: defaultReconcileMinAge,
+			})
+			if err != nil {
+				sugar.Warnf("cannot reconcile storage: %v", err)
+				continue
+			}
+			sugar.Infof("storage reconciliation (dry run: %v): %d objects scanned, "+
+				"%d orphaned objects (%d bytes), %d dangling files, %d dangling versions, %d files of deleted projects",
+				report.DryRun, report.ScannedObjects,
+				len(report.OrphanedObjects), report.OrphanedSize,
+				len(report.DanglingFiles), len(report.DanglingVersions), len(report.DeletedProjectFiles))
+			for _, e := range report.Errors {
+				sugar.Warnf("storage reconciliation: %s", e)
+			}
+		}
+	}()
+}
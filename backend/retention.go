@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"github.com/darmiel/perplex/api/services"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// defaultRetentionInterval is the default interval in which retention policies are evaluated
+const defaultRetentionInterval = time.Hour
+
+// scheduleRetention evaluates the retention policies of all projects every RETENTION_INTERVAL
+// (default 1h). Retention policies are not evaluated if the interval is set to "0".
+// If multiple instances are running, the policies are evaluated by one instance at a time
+func scheduleRetention(srv services.RetentionService, sugar *zap.SugaredLogger) {
+	interval := defaultRetentionInterval
+	if rawInterval, ok := os.LookupEnv("RETENTION_INTERVAL"); ok {
+		var err error
+		if interval, err = time.ParseDuration(rawInterval); err != nil || interval < 0 {
+			sugar.Warnf("invalid RETENTION_INTERVAL %q, retention policies are disabled", rawInterval)
+			return
+		}
+	}
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			res, err := srv.Run()
+			if errors.Is(err, services.ErrRetentionRunning) {
+				continue
+			}
+			if err != nil {
+				sugar.Warnf("cannot evaluate retention policies: %v", err)
+				continue
+			}
+			if res.Scheduled > 0 || res.Unscheduled > 0 || res.Deleted > 0 || res.Archived > 0 {
+				sugar.Infof("retention policies: %d files scheduled, %d unscheduled, %d deleted, %d archived",
+					res.Scheduled, res.Unscheduled, res.Deleted, res.Archived)
+			}
+			for _, e := range res.Errors {
+				sugar.Warnf("retention policies: %s", e)
+			}
+		}
+	}()
+}